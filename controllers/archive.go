@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/storage"
+)
+
+// archiveEntry is a single item of a streamed ZIP. A nil File marks an (empty) directory.
+type archiveEntry struct {
+	Path string
+	File *models.File
+}
+
+// folderArchiveEntries lays out a folder tree as ZIP entries rooted at the top-level folder's name.
+func folderArchiveEntries(rootID uuid.UUID, folders []models.Folder, files []models.File, prefix string) []archiveEntry {
+	byID := make(map[uuid.UUID]models.Folder, len(folders))
+	for _, f := range folders {
+		byID[f.ID] = f
+	}
+
+	paths := make(map[uuid.UUID]string, len(folders))
+	var folderPath func(id uuid.UUID) string
+	folderPath = func(id uuid.UUID) string {
+		if p, ok := paths[id]; ok {
+			return p
+		}
+		folder := byID[id]
+		p := path.Join(prefix, sanitizeArchiveName(folder.Name))
+		if id != rootID && folder.ParentID != nil {
+			p = path.Join(folderPath(*folder.ParentID), sanitizeArchiveName(folder.Name))
+		}
+		paths[id] = p
+		return p
+	}
+
+	var entries []archiveEntry
+	for _, f := range folders {
+		entries = append(entries, archiveEntry{Path: folderPath(f.ID) + "/"})
+	}
+	for i := range files {
+		if files[i].FolderID == nil {
+			continue
+		}
+		entries = append(entries, archiveEntry{
+			Path: path.Join(folderPath(*files[i].FolderID), sanitizeArchiveName(files[i].Name)),
+			File: &files[i],
+		})
+	}
+	return entries
+}
+
+// streamZip writes the entries straight from object storage into a ZIP response,
+// so nothing is buffered on disk. Errors after the first byte can only be logged.
+func streamZip(c *gin.Context, store storage.ObjectStore, archiveName string, entries []archiveEntry) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(archiveName)))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	defer zw.Close()
+
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		name := uniqueArchivePath(entry.Path, seen)
+
+		if entry.File == nil {
+			if _, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store}); err != nil {
+				log.Printf("zip: failed to add directory %s: %v", name, err)
+				return
+			}
+			continue
+		}
+
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: entry.File.UpdatedAt,
+		})
+		if err != nil {
+			log.Printf("zip: failed to add %s: %v", name, err)
+			return
+		}
+
+		body, err := store.GetObject(c.Request.Context(), entry.File.ObjectKey, 0, -1)
+		if err != nil {
+			log.Printf("zip: failed to read object %s: %v", entry.File.ObjectKey, err)
+			return
+		}
+		_, err = io.Copy(w, body)
+		body.Close()
+		if err != nil {
+			log.Printf("zip: failed to stream %s: %v", name, err)
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+func sanitizeArchiveName(name string) string {
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, "\\", "_")
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// uniqueArchivePath suffixes duplicate names ("a.txt", "a (1).txt") since folders may hold several files with the same name.
+func uniqueArchivePath(p string, seen map[string]bool) string {
+	isDir := strings.HasSuffix(p, "/")
+	trimmed := strings.TrimSuffix(p, "/")
+	candidate := p
+
+	ext := path.Ext(trimmed)
+	if isDir {
+		ext = ""
+	}
+	base := strings.TrimSuffix(trimmed, ext)
+
+	for i := 1; seen[candidate]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if isDir {
+			candidate += "/"
+		}
+	}
+	seen[candidate] = true
+	return candidate
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -20,13 +21,15 @@ import (
 
 type AuthController struct {
 	Repo             *repositories.UserRepository
+	PermissionRepo   *repositories.PermissionRepository
 	oauthConfig      *oauth2.Config
 	oauthStateString string
 }
 
-func NewAuthController(userRepo *repositories.UserRepository) *AuthController {
+func NewAuthController(userRepo *repositories.UserRepository, permissionRepo *repositories.PermissionRepository) *AuthController {
 	return &AuthController{
-		Repo: userRepo,
+		Repo:           userRepo,
+		PermissionRepo: permissionRepo,
 		oauthConfig: &oauth2.Config{
 			ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
 			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
@@ -102,6 +105,14 @@ func (r *AuthController) GoogleCallback(c *gin.Context) {
 
 	if err := r.Repo.UpsertByGoogleID(user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync user data"})
+		return
+	}
+
+	// Shares sent to this email before the account existed
+	if accepted, err := r.PermissionRepo.AcceptInvitations(user); err != nil {
+		log.Printf("failed to accept invitations for %s: %v", user.Email, err)
+	} else if accepted > 0 {
+		log.Printf("accepted %d invitations for %s", accepted, user.Email)
 	}
 
 	tokens, err := r.generateTokens(user)
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/richeek45/filedrive/storage"
+)
+
+// declaredChecksums are the checksums a client promises at initiate.
+type declaredChecksums struct {
+	algorithm string
+	whole     storage.Checksum
+	// parts[i] is the checksum of part i+1
+	parts []string
+}
+
+// partAlgorithm is the algorithm the multipart upload is created with, if parts carry checksums.
+func (d declaredChecksums) partAlgorithm() string {
+	if len(d.parts) == 0 {
+		return ""
+	}
+	return d.algorithm
+}
+
+func parseDeclaredChecksums(algorithm string, whole string, parts []string, totalParts int) (declaredChecksums, error) {
+	if whole == "" && len(parts) == 0 {
+		return declaredChecksums{}, nil
+	}
+
+	d := declaredChecksums{algorithm: storage.NormalizeChecksumAlgorithm(algorithm)}
+	if d.algorithm == "" {
+		return d, fmt.Errorf("checksumAlgorithm must be %s or %s", storage.ChecksumSHA256, storage.ChecksumCRC32C)
+	}
+	if whole != "" {
+		if !storage.ValidChecksum(d.algorithm, whole) {
+			return d, errors.New("checksum is not a base64 " + d.algorithm + " digest")
+		}
+		d.whole = storage.Checksum{Algorithm: d.algorithm, Value: whole}
+	}
+	if len(parts) > 0 {
+		if len(parts) != totalParts {
+			return d, fmt.Errorf("expected %d partChecksums, got %d", totalParts, len(parts))
+		}
+		for i, value := range parts {
+			if !storage.ValidChecksum(d.algorithm, value) {
+				return d, fmt.Errorf("partChecksums[%d] is not a base64 %s digest", i, d.algorithm)
+			}
+		}
+		d.parts = parts
+	}
+	return d, nil
+}
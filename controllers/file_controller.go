@@ -7,31 +7,31 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/aws/smithy-go"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/richeek45/filedrive/dtos"
 	"github.com/richeek45/filedrive/models"
 	"github.com/richeek45/filedrive/repositories"
+	"github.com/richeek45/filedrive/storage"
+	"github.com/richeek45/filedrive/worker"
 	"github.com/wneessen/go-mail"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
 type FileController struct {
-	Repo       *repositories.FileRepository
-	FolderRepo *repositories.FolderRepository
-	UserRepo   *repositories.UserRepository
-	S3Client   *s3.Client
-	Bucket     string
+	Repo           *repositories.FileRepository
+	FolderRepo     *repositories.FolderRepository
+	UserRepo       *repositories.UserRepository
+	PermissionRepo *repositories.PermissionRepository
+	Store          storage.ObjectStore
+	Bucket         string
 }
 
 var (
@@ -39,6 +39,12 @@ var (
 	cleanupInterval = 5 * time.Minute
 )
 
+const (
+	// Presigned part URLs live this long unless the client asks for another lifetime
+	defaultPartURLExpiry = 15 * time.Minute
+	maxPresignBatch      = 1000
+)
+
 type CacheEntry struct {
 	folderID  uuid.UUID
 	expiresAt time.Time
@@ -70,6 +76,62 @@ func StartCacheCleaner() {
 	}()
 }
 
+// resolveFolderPath walks names below rootID (nil for the user's root) and returns the last folder's ID.
+// With create set, missing folders are created as the upload flow needs; otherwise a missing
+// folder returns gorm.ErrRecordNotFound. Each prefix is cached in folderCache.
+func resolveFolderPath(repo *repositories.FolderRepository, userID uuid.UUID, rootID *uuid.UUID, names []string, create bool) (*uuid.UUID, error) {
+	currentParentID := rootID
+	accumulatedPath := ""
+
+	for _, folderName := range names {
+		accumulatedPath = path.Join(accumulatedPath, folderName)
+		cacheKey := getFolderCacheKey(userID, rootID, accumulatedPath)
+
+		if val, ok := folderCache.Load(cacheKey); ok {
+			entry := val.(CacheEntry)
+			if time.Now().Before(entry.expiresAt) {
+				id := entry.folderID
+				currentParentID = &id
+				continue
+			}
+		}
+
+		var folderID uuid.UUID
+		if create {
+			id, err := repo.EnsureFolderExists(userID, currentParentID, folderName)
+			if err != nil {
+				return nil, err
+			}
+			folderID = id
+		} else {
+			folder, err := repo.FindFolderByName(userID, currentParentID, folderName)
+			if err != nil {
+				return nil, err
+			}
+			folderID = folder.ID
+		}
+
+		folderCache.Store(cacheKey, CacheEntry{
+			folderID:  folderID,
+			expiresAt: time.Now().Add(10 * time.Minute),
+		})
+
+		currentParentID = &folderID
+	}
+	return currentParentID, nil
+}
+
+// invalidateFolderCache drops the user's cached paths after a folder is renamed, moved or deleted.
+func invalidateFolderCache(userID uuid.UUID) {
+	prefix := userID.String() + ":"
+	folderCache.Range(func(key, value any) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			folderCache.Delete(key)
+		}
+		return true
+	})
+}
+
 func (fc *FileController) GetFilesFromParentFolder(c *gin.Context) {
 	var req struct {
 		FolderID string `form:"parentId"`
@@ -80,20 +142,32 @@ func (fc *FileController) GetFilesFromParentFolder(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	opts, ok := bindListOptions(c)
+	if !ok {
+		return
+	}
 	userID := uuid.MustParse(c.GetString("userID"))
 	var folderIDPtr *uuid.UUID
 	if req.FolderID != "" {
 		parsed := uuid.MustParse(req.FolderID)
 		folderIDPtr = &parsed
 	}
-	files, err := fc.Repo.GetFiles(userID, folderIDPtr, req.IsTrash)
+	ownerID, permission, ok := listingOwner(c, fc.PermissionRepo, userID, folderIDPtr, req.IsTrash)
+	if !ok {
+		return
+	}
+	files, nextCursor, err := fc.Repo.GetFiles(ownerID, folderIDPtr, req.IsTrash, opts)
 
 	if err != nil {
+		if errors.Is(err, repositories.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var response []dtos.FileResponse
+	response := make([]dtos.FileResponse, 0, len(files))
 
 	for _, f := range files {
 
@@ -105,20 +179,20 @@ func (fc *FileController) GetFilesFromParentFolder(c *gin.Context) {
 			CreatedAt:    f.CreatedAt,
 			IsDeleted:    f.IsDeleted,
 			UploadStatus: f.UploadStatus,
-			Permission:   "owner",
+			Permission:   string(permission),
 		})
 	}
 
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, dtos.FileListResponse{Items: response, NextCursor: optionalCursor(nextCursor)})
 }
 
 func (fc *FileController) GetDownloadURL(c *gin.Context) {
 	fileID := c.Param("fileId")
 	userID := c.GetString("userID")
 
-	file, err := fc.Repo.GetFileByID(uuid.MustParse(fileID), uuid.MustParse(userID))
+	file, _, err := fc.PermissionRepo.AuthorizeFile(uuid.MustParse(fileID), uuid.MustParse(userID), repositories.ActionRead)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		respondAuthzError(c, err, "File not found")
 		return
 	}
 
@@ -130,19 +204,192 @@ func (fc *FileController) GetDownloadURL(c *gin.Context) {
 	contentDisposition := fmt.Sprintf("attachment; filename*=UTF-8''%s", encodedName)
 
 	// 2. Create Presigned URL (Valid for 15 minutes)
-	presignClient := s3.NewPresignClient(fc.S3Client)
-	presignedReq, err := presignClient.PresignGetObject(c.Request.Context(), &s3.GetObjectInput{
-		Bucket:                     aws.String(fc.Bucket),
-		Key:                        aws.String(file.ObjectKey),
-		ResponseContentDisposition: aws.String(contentDisposition),
-	})
+	presignedURL, err := fc.Store.PresignGetObject(c.Request.Context(), file.ObjectKey, contentDisposition)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate URL"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"url": presignedReq.URL})
+	c.JSON(http.StatusOK, gin.H{"url": presignedURL})
+}
+
+// StreamFileContent proxies the object through the server instead of handing out a presigned URL.
+// It honours single byte ranges (for seeking and resumed downloads) and conditional requests on the stored ETag.
+func (fc *FileController) StreamFileContent(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("fileId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fileId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	file, _, err := fc.PermissionRepo.AuthorizeFile(fileID, userID, repositories.ActionRead)
+	if err != nil || file.UploadStatus != "completed" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	etag := ""
+	if file.ETag != nil && *file.ETag != "" {
+		etag = *file.ETag
+		if !strings.HasPrefix(etag, `"`) {
+			etag = `"` + etag + `"`
+		}
+		c.Header("ETag", etag)
+	}
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Cache-Control", "private, no-cache")
+
+	if etag != "" && etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	disposition := "inline"
+	if c.Query("download") == "true" {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("%s; filename*=UTF-8''%s", disposition, url.PathEscape(file.Name)))
+
+	contentType := "application/octet-stream"
+	if file.MimeType != nil && *file.MimeType != "" {
+		contentType = *file.MimeType
+	}
+
+	status := http.StatusOK
+	offset, length := int64(0), file.Size
+	extraHeaders := map[string]string{}
+
+	rangeHeader := c.GetHeader("Range")
+	// A stale If-Range means the client's partial copy is outdated, so send the whole object
+	if ifRange := c.GetHeader("If-Range"); ifRange != "" && ifRange != etag {
+		rangeHeader = ""
+	}
+	if rangeHeader != "" {
+		start, n, ok := parseByteRange(rangeHeader, file.Size)
+		if !ok {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", file.Size))
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		status = http.StatusPartialContent
+		offset, length = start, n
+		extraHeaders["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, file.Size)
+	}
+
+	body, err := fc.Store.GetObject(c.Request.Context(), file.ObjectKey, offset, length)
+	if err != nil {
+		log.Printf("failed to read object %s: %v", file.ObjectKey, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not read file"})
+		return
+	}
+	defer body.Close()
+
+	c.DataFromReader(status, length, contentType, body, extraHeaders)
+}
+
+// DownloadArchive streams an arbitrary selection of files and folders as one ZIP.
+// The combined size is capped at the caller's storage limit.
+func (fc *FileController) DownloadArchive(c *gin.Context) {
+	var req struct {
+		FileIDs   []uuid.UUID `json:"fileIds"`
+		FolderIDs []uuid.UUID `json:"folderIds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.FileIDs) == 0 && len(req.FolderIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Select at least one file or folder"})
+		return
+	}
+	// An item selected twice is archived and counted against the size cap once
+	req.FileIDs = uniqueIDs(req.FileIDs)
+	req.FolderIDs = uniqueIDs(req.FolderIDs)
+
+	userID := uuid.MustParse(c.GetString("userID"))
+	user, err := fc.UserRepo.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	type folderTree struct {
+		folders []models.Folder
+		files   []models.File
+	}
+	trees := make(map[uuid.UUID]folderTree, len(req.FolderIDs))
+	// Folders inside another selected folder are archived as part of it
+	covered := make(map[uuid.UUID]bool)
+
+	for _, folderID := range req.FolderIDs {
+		if _, _, err := fc.PermissionRepo.AuthorizeFolder(folderID, userID, repositories.ActionRead); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Folder %s not found", folderID)})
+			return
+		}
+		folders, files, err := fc.FolderRepo.GetFolderTree(folderID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Folder %s not found", folderID)})
+			return
+		}
+		for _, f := range folders {
+			if f.ID != folderID {
+				covered[f.ID] = true
+			}
+		}
+		trees[folderID] = folderTree{folders: folders, files: files}
+	}
+
+	var entries []archiveEntry
+	var totalSize int64
+	included := make(map[uuid.UUID]bool)
+
+	for _, folderID := range req.FolderIDs {
+		if covered[folderID] {
+			continue
+		}
+		tree := trees[folderID]
+		for _, f := range tree.files {
+			included[f.ID] = true
+			totalSize += f.Size
+		}
+		entries = append(entries, folderArchiveEntries(folderID, tree.folders, tree.files, "")...)
+	}
+
+	for _, fileID := range req.FileIDs {
+		if included[fileID] {
+			continue
+		}
+		file, _, err := fc.PermissionRepo.AuthorizeFile(fileID, userID, repositories.ActionRead)
+		if err != nil || file.UploadStatus != "completed" {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("File %s not found", fileID)})
+			return
+		}
+		included[fileID] = true
+		totalSize += file.Size
+		entries = append(entries, archiveEntry{Path: sanitizeArchiveName(file.Name), File: &file})
+	}
+
+	if totalSize > user.StorageLimit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Selection is larger than your storage limit. Download fewer items at once"})
+		return
+	}
+
+	streamZip(c, fc.Store, "filedrive-download.zip", entries)
+}
+
+// uniqueIDs drops repeated IDs, keeping the first occurrence of each in order.
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
 }
 
 func (fc *FileController) MoveToTrash(c *gin.Context) {
@@ -153,7 +400,12 @@ func (fc *FileController) MoveToTrash(c *gin.Context) {
 	}
 
 	userId := uuid.MustParse(c.GetString("userID"))
-	if err := fc.Repo.DeleteFile(fileId, userId, fc.S3Client); err != nil {
+	file, err := fc.PermissionRepo.AuthorizeFileRemoval(fileId, userId)
+	if err != nil {
+		respondAuthzError(c, err, "File not found")
+		return
+	}
+	if err := fc.Repo.DeleteFile(file.ID, file.OwnerID, fc.Store); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -171,7 +423,12 @@ func (fc *FileController) RestoreFileById(c *gin.Context) {
 	}
 
 	userID := uuid.MustParse(c.GetString("userID"))
-	err := fc.Repo.RestoreFileById(req.FileID, userID, fc.S3Client)
+	file, _, err := fc.PermissionRepo.AuthorizeFile(req.FileID, userID, repositories.ActionDelete)
+	if err != nil {
+		respondAuthzError(c, err, "File not found")
+		return
+	}
+	err = fc.Repo.RestoreFileById(file.ID, file.OwnerID, fc.Store)
 	if err != nil {
 		fmt.Printf("Error in restorinng file: %s, %v", req.FileID, err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -180,10 +437,10 @@ func (fc *FileController) RestoreFileById(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "restored successfully"})
 }
 
-// It only restores if the file is deleted in the last 30 days
+// It only restores files deleted within the user's trash retention window
 func (fc *FileController) RestorePermanentlyDeletedFiles(c *gin.Context) {
 	userID := uuid.MustParse(c.GetString("userID"))
-	err := fc.Repo.RestoreDeletedFiles(userID, fc.S3Client)
+	err := fc.Repo.RestoreDeletedFiles(userID, fc.Store)
 	if err != nil {
 		fmt.Printf("Error in restoring files: %v", err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -192,6 +449,23 @@ func (fc *FileController) RestorePermanentlyDeletedFiles(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "restored successfully"})
 }
 
+func (fc *FileController) EmptyTrash(c *gin.Context) {
+	userID := uuid.MustParse(c.GetString("userID"))
+	result, err := fc.Repo.EmptyTrash(userID, fc.Store)
+	if err != nil {
+		fmt.Printf("Error in emptying trash: %v", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	worker.RecordTrashReclaimed(worker.ReclaimEmptyTrash, int(result.Files), result.BytesReclaimed)
+
+	c.JSON(http.StatusOK, dtos.EmptyTrashResponse{
+		FilesDeleted:   result.Files,
+		FoldersDeleted: result.Folders,
+		BytesReclaimed: result.BytesReclaimed,
+	})
+}
+
 func (fc *FileController) RenameFile(c *gin.Context) {
 	var req struct {
 		NewName string `json:"name" binding:"required"`
@@ -204,9 +478,13 @@ func (fc *FileController) RenameFile(c *gin.Context) {
 	fileID := uuid.MustParse(c.Param("fileId"))
 	userID := uuid.MustParse(c.GetString("userID"))
 
-	err := fc.Repo.DB.Model(&models.File{}).
-		Where("id = ? AND owner_id = ?", fileID, userID).
-		Update("name", req.NewName).Error
+	file, _, err := fc.PermissionRepo.AuthorizeFile(fileID, userID, repositories.ActionRename)
+	if err != nil {
+		respondAuthzError(c, err, "File not found")
+		return
+	}
+
+	err = fc.Repo.DB.Model(&file).Update("name", req.NewName).Error
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
@@ -215,14 +493,90 @@ func (fc *FileController) RenameFile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Renamed successfully"})
 }
 
+func (fc *FileController) MoveFile(c *gin.Context) {
+	var req struct {
+		FolderID *uuid.UUID `json:"folderId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("fileId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fileId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	file, _, err := fc.PermissionRepo.AuthorizeFile(fileID, userID, repositories.ActionRename)
+	if err != nil {
+		respondMoveCopyError(c, err, "File not found")
+		return
+	}
+	ownerID, err := fc.PermissionRepo.AuthorizeDestination(req.FolderID, userID)
+	if err == nil && ownerID != file.OwnerID {
+		err = repositories.ErrCrossOwnerMove
+	}
+	if err != nil {
+		respondMoveCopyError(c, err, "File not found")
+		return
+	}
+
+	if err := fc.Repo.MoveFile(file.ID, file.OwnerID, req.FolderID); err != nil {
+		respondMoveCopyError(c, err, "File not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Moved successfully"})
+}
+
+func (fc *FileController) CopyFile(c *gin.Context) {
+	var req struct {
+		FolderID *uuid.UUID `json:"folderId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("fileId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fileId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	source, _, err := fc.PermissionRepo.AuthorizeFile(fileID, userID, repositories.ActionRead)
+	if err != nil {
+		respondMoveCopyError(c, err, "File not found")
+		return
+	}
+	ownerID, err := fc.PermissionRepo.AuthorizeDestination(req.FolderID, userID)
+	if err != nil {
+		respondMoveCopyError(c, err, "File not found")
+		return
+	}
+
+	file, err := fc.Repo.CopyFile(&source, ownerID, req.FolderID, fc.Store)
+	if err != nil {
+		respondMoveCopyError(c, err, "File not found")
+		return
+	}
+	c.JSON(http.StatusCreated, file)
+}
+
 func (fc *FileController) InitiateMultiPartUpload(c *gin.Context) {
 	var req struct {
 		FileName     string     `json:"fileName" binding:"required"`
 		ContentType  string     `json:"contentType" binding:"required"`
-		Size         int64      `json:"size" binding:"required"`
+		Size         int64      `json:"size" binding:"required,min=1"`
 		ParentID     *uuid.UUID `json:"parentId"`
-		TotalChunks  *int       `json:"totalChunks" binding:"required"`
+		TotalChunks  *int       `json:"totalChunks" binding:"omitempty,min=1,max=10000"` // defaults to parts of the recommended size
 		RelativePath string     `json:"relativePath"`
+		// Optional SHA256 or CRC32C checksums, base64 encoded as S3 expects them
+		ChecksumAlgorithm string   `json:"checksumAlgorithm"`
+		Checksum          string   `json:"checksum"`
+		PartChecksums     []string `json:"partChecksums"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -230,10 +584,37 @@ func (fc *FileController) InitiateMultiPartUpload(c *gin.Context) {
 		return
 	}
 
+	if req.Size > storage.MaxObjectSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is larger than the maximum object size"})
+		return
+	}
+	partSize := storage.RecommendedPartSize(req.Size)
+	totalParts := storage.PartCount(req.Size, partSize)
+	if req.TotalChunks != nil {
+		totalParts = *req.TotalChunks
+	}
+
+	checksums, err := parseDeclaredChecksums(req.ChecksumAlgorithm, req.Checksum, req.PartChecksums, totalParts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	userID := uuid.MustParse(c.GetString("userID"))
 	finalParentID := req.ParentID
 
-	user, err := fc.UserRepo.GetByID(userID)
+	// Uploading into a shared folder creates the file in, and charges it to, the folder owner
+	ownerID, err := fc.PermissionRepo.AuthorizeDestination(req.ParentID, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrDestinationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+		respondAuthzError(c, err, "Folder not found")
+		return
+	}
+
+	user, err := fc.UserRepo.GetByID(ownerID)
 	if err != nil {
 		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		return
@@ -256,11 +637,7 @@ func (fc *FileController) InitiateMultiPartUpload(c *gin.Context) {
 
 	if err == nil {
 		// Found existing! Ask S3 which parts it already has
-		out, S3err := fc.S3Client.ListParts(c.Request.Context(), &s3.ListPartsInput{
-			Bucket:   aws.String(fc.Bucket),
-			Key:      aws.String(pending.S3Key),
-			UploadId: aws.String(pending.UploadID),
-		})
+		parts, S3err := fc.Store.ListParts(c.Request.Context(), pending.S3Key, pending.UploadID)
 
 		if S3err != nil {
 			// If S3 says it doesn't exist (maybe expired), delete pending and start fresh
@@ -268,9 +645,12 @@ func (fc *FileController) InitiateMultiPartUpload(c *gin.Context) {
 		} else {
 			// Return parts with ETags so frontend can "Complete" later
 			c.JSON(http.StatusOK, gin.H{
+				"sessionId":      pending.ID,
 				"uploadId":       pending.UploadID,
 				"key":            pending.S3Key,
-				"completedParts": out.Parts, // This includes PartNumber and ETag
+				"partSize":       partSize,
+				"totalParts":     pending.TotalParts,
+				"completedParts": parts, // This includes PartNumber and ETag
 				"resumed":        true,
 			})
 			return
@@ -281,13 +661,7 @@ func (fc *FileController) InitiateMultiPartUpload(c *gin.Context) {
 
 	key := fmt.Sprintf("uploads/%s/%s", uuid.New().String(), req.FileName) // TODO remove filename
 
-	input := &s3.CreateMultipartUploadInput{
-		Bucket:      &fc.Bucket,
-		Key:         aws.String(key),
-		ContentType: aws.String(req.ContentType),
-	}
-
-	resp, err := fc.S3Client.CreateMultipartUpload(c.Request.Context(), input)
+	uploadID, err := fc.Store.CreateMultipartUpload(c.Request.Context(), key, req.ContentType, checksums.partAlgorithm())
 
 	fmt.Println(err)
 
@@ -302,61 +676,44 @@ func (fc *FileController) InitiateMultiPartUpload(c *gin.Context) {
 		folderParts := pathsSplit[:len(pathsSplit)-1]
 
 		if len(folderParts) > 0 {
-			currentParentID := req.ParentID
-			accumulatedPath := ""
-
-			for _, folderName := range folderParts {
-				accumulatedPath := filepath.Join(accumulatedPath, folderName)
-				cacheKey := getFolderCacheKey(userID, req.ParentID, accumulatedPath)
-
-				if val, ok := folderCache.Load(cacheKey); ok {
-					entry := val.(CacheEntry)
-					if time.Now().Before(entry.expiresAt) {
-						id := entry.folderID
-						currentParentID = &id
-						continue
-					}
-				}
-
-				newFolderID, err := fc.FolderRepo.EnsureFolderExists(userID, currentParentID, folderName)
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Folder creation failed"})
-					return
-				}
-
-				folderCache.Store(cacheKey, CacheEntry{
-					folderID:  newFolderID,
-					expiresAt: time.Now().Add(10 * time.Minute),
-				})
-
-				currentParentID = &newFolderID
+			finalParentID, err = resolveFolderPath(fc.FolderRepo, ownerID, req.ParentID, folderParts, true)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Folder creation failed"})
+				return
 			}
-			finalParentID = currentParentID
 		}
 
 	}
 
 	newFile := &models.File{
 		Name:         req.FileName,
-		OwnerID:      userID,
+		OwnerID:      ownerID,
 		FolderID:     finalParentID,
 		Size:         req.Size,
 		MimeType:     &req.ContentType,
 		BucketName:   fc.Bucket,
 		ObjectKey:    key,
-		S3UploadID:   resp.UploadId,
+		S3UploadID:   &uploadID,
 		UploadStatus: "pending",
-		TotalChunks:  req.TotalChunks,
+		TotalChunks:  &totalParts,
+	}
+	if !checksums.whole.IsZero() {
+		newFile.ChecksumAlgorithm = &checksums.whole.Algorithm
+		newFile.Checksum = &checksums.whole.Value
 	}
 
 	pendingEntry := &models.PendingUpload{
 		ID:         uuid.New(),
 		UserID:     userID,
-		UploadID:   *resp.UploadId,
+		UploadID:   uploadID,
 		S3Key:      key,
 		FileName:   req.FileName,
 		ParentID:   req.ParentID,
-		TotalParts: *req.TotalChunks,
+		TotalParts: totalParts,
+	}
+	if len(checksums.parts) > 0 {
+		pendingEntry.ChecksumAlgorithm = checksums.algorithm
+		pendingEntry.PartChecksums = strings.Join(checksums.parts, ",")
 	}
 
 	if err := fc.Repo.UpsertFilePending(newFile, pendingEntry); err != nil {
@@ -365,80 +722,279 @@ func (fc *FileController) InitiateMultiPartUpload(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"uploadId": *resp.UploadId,
-		"key":      key,
+		"sessionId":  pendingEntry.ID,
+		"uploadId":   uploadID,
+		"key":        key,
+		"partSize":   partSize,
+		"totalParts": totalParts,
 	})
 }
 
 func (fc *FileController) PresignPart(c *gin.Context) {
 	var req struct {
-		UploadID   string `json:"uploadId" binding:"required"`
-		Key        string `json:"key" binding:"required"`
-		PartNumber int32  `json:"partNumber" binding:"required"`
+		SessionID  uuid.UUID `json:"sessionId" binding:"required"`
+		UploadID   string    `json:"uploadId"`
+		Key        string    `json:"key"`
+		PartNumber int32     `json:"partNumber" binding:"required"`
+		// Lifetime of the URL in seconds
+		ExpiresIn int `json:"expiresIn" binding:"omitempty,min=60,max=604800"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	presignClient := s3.NewPresignClient(fc.S3Client)
+	session, ok := fc.uploadSession(c, req.SessionID, req.UploadID, req.Key)
+	if !ok {
+		return
+	}
+	if err := session.CheckPart(req.PartNumber); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := fc.Repo.TouchUploadSession(session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update upload session"})
+		return
+	}
+	checksum := session.PartChecksum(req.PartNumber)
 
 	// Request a presigned URL for the UploadPart operation
-	presignedReq, err := presignClient.PresignUploadPart(c.Request.Context(), &s3.UploadPartInput{
-		Bucket:     aws.String(fc.Bucket),
-		Key:        aws.String(req.Key),
-		UploadId:   aws.String(req.UploadID),
-		PartNumber: aws.Int32(req.PartNumber),
-	})
+	presignedURL, err := fc.Store.PresignUploadPart(c.Request.Context(), session.Key(), session.UploadID(), req.PartNumber, checksum, partURLExpiry(req.ExpiresIn))
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to presign part"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"url": presignedReq.URL})
+	response := gin.H{"url": presignedURL}
+	if !checksum.IsZero() {
+		// The checksum is signed into the URL, so the PUT has to carry it
+		response["headers"] = gin.H{storage.ChecksumHeader(checksum.Algorithm): checksum.Value}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-func (fc *FileController) CompleteMultipartUpload(c *gin.Context) {
+// PresignParts presigns a contiguous range of parts in one call, so a large upload does not
+// need a request per part.
+func (fc *FileController) PresignParts(c *gin.Context) {
 	var req struct {
-		UploadID string                `json:"uploadId" binding:"required"`
-		Key      string                `json:"key" binding:"required"`
-		ParentID *uuid.UUID            `json:"parentId"`
-		Parts    []types.CompletedPart `json:"parts" binding:"required"`
+		SessionID uuid.UUID `json:"sessionId" binding:"required"`
+		UploadID  string    `json:"uploadId"`
+		Key       string    `json:"key"`
+		StartPart int32     `json:"startPart" binding:"required"`
+		EndPart   int32     `json:"endPart" binding:"required,gtefield=StartPart"`
+		// Lifetime of the URLs in seconds
+		ExpiresIn int `json:"expiresIn" binding:"omitempty,min=60,max=604800"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.EndPart-req.StartPart >= maxPresignBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d parts can be presigned at once", maxPresignBatch)})
+		return
+	}
 
-	result, err := fc.S3Client.CompleteMultipartUpload(c.Request.Context(), &s3.CompleteMultipartUploadInput{
-		Bucket:   aws.String(fc.Bucket),
-		Key:      aws.String(req.Key),
-		UploadId: aws.String(req.UploadID),
-		MultipartUpload: &types.CompletedMultipartUpload{
-			Parts: req.Parts,
-		},
+	session, ok := fc.uploadSession(c, req.SessionID, req.UploadID, req.Key)
+	if !ok {
+		return
+	}
+	for _, partNumber := range []int32{req.StartPart, req.EndPart} {
+		if err := session.CheckPart(partNumber); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := fc.Repo.TouchUploadSession(session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update upload session"})
+		return
+	}
+
+	expiry := partURLExpiry(req.ExpiresIn)
+	parts := make([]dtos.PresignedPartResponse, 0, req.EndPart-req.StartPart+1)
+	for partNumber := req.StartPart; partNumber <= req.EndPart; partNumber++ {
+		checksum := session.PartChecksum(partNumber)
+		presignedURL, err := fc.Store.PresignUploadPart(c.Request.Context(), session.Key(), session.UploadID(), partNumber, checksum, expiry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to presign part"})
+			return
+		}
+
+		part := dtos.PresignedPartResponse{PartNumber: partNumber, URL: presignedURL}
+		if !checksum.IsZero() {
+			part.Headers = map[string]string{storage.ChecksumHeader(checksum.Algorithm): checksum.Value}
+		}
+		parts = append(parts, part)
+	}
+
+	c.JSON(http.StatusOK, dtos.PresignedPartsResponse{
+		Parts:     parts,
+		ExpiresAt: time.Now().Add(expiry),
 	})
+}
+
+func (fc *FileController) CompleteMultipartUpload(c *gin.Context) {
+	var req struct {
+		SessionID uuid.UUID               `json:"sessionId" binding:"required"`
+		UploadID  string                  `json:"uploadId"`
+		Key       string                  `json:"key"`
+		ParentID  *uuid.UUID              `json:"parentId"`
+		Parts     []storage.CompletedPart `json:"parts" binding:"required"`
+		// Optional hex SHA-256 of the whole file; verified, then used to deduplicate
+		SHA256 string `json:"sha256" binding:"omitempty,len=64,hexadecimal"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, ok := fc.uploadSession(c, req.SessionID, req.UploadID, req.Key)
+	if !ok {
+		return
+	}
+	if err := session.CheckParts(req.Parts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Size and quota are checked against what was actually uploaded, before anything is assembled
+	uploaded, err := fc.Store.ListParts(c.Request.Context(), session.Key(), session.UploadID())
+	if err != nil {
+		if errors.Is(err, storage.ErrNoSuchUpload) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "upload no longer exists in storage"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list uploaded parts"})
+		return
+	}
+	size, err := session.AssembledSize(req.Parts, uploaded)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := fc.UserRepo.GetByID(session.File.OwnerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user"})
+		return
+	}
+	if user.StorageUsed+size >= user.StorageLimit {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": "Not enough space. Delete some files"})
+		return
+	}
+
+	// Part checksums come from what was declared at initiate, not from the client
+	for i := range req.Parts {
+		req.Parts[i].Checksum = session.PartChecksum(req.Parts[i].PartNumber)
+	}
+
+	status := "completed"
+	finalETag, err := fc.Store.CompleteMultipartUpload(c.Request.Context(), session.Key(), session.UploadID(), req.Parts)
+	if errors.Is(err, storage.ErrChecksumMismatch) {
+		status = "error"
+		// A rejected completion leaves the parts behind in the backend
+		fc.Store.AbortMultipartUpload(c.Request.Context(), session.Key(), session.UploadID())
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete S3 upload"})
 		return
 	}
 
-	finalETag := ""
-	if result.ETag != nil {
-		finalETag = *result.ETag
+	check := repositories.ContentCheck{Declared: session.DeclaredChecksum(), SHA256: strings.ToLower(req.SHA256)}
+	var sha256 string
+	if status == "completed" {
+		ok, verified, known, err := check.CheckWithBackend(c.Request.Context(), fc.Store, session.Key())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify uploaded content"})
+			return
+		}
+		if !known {
+			// Reading the object back could take minutes, so it is checked outside this request
+			if err := fc.Repo.MarkVerifying(session.UploadID(), len(req.Parts), finalETag, check.SHA256); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file record"})
+				return
+			}
+			fc.Repo.DB.Delete(&session.Pending)
+			worker.VerifyUpload(fc.Repo.DB, fc.Store, session.File.ID)
+			c.JSON(http.StatusAccepted, gin.H{
+				"message":      "upload received, verifying content",
+				"fileId":       session.File.ID,
+				"uploadStatus": "verifying",
+			})
+			return
+		}
+		if !ok {
+			status = "error"
+		}
+		sha256 = verified
 	}
 
-	err = fc.Repo.FinalizeFile(req.UploadID, len(req.Parts), finalETag, "completed")
+	err = fc.Repo.FinalizeFile(session.UploadID(), len(req.Parts), finalETag, status, sha256, fc.Store)
+	if errors.Is(err, repositories.ErrInsufficientStorage) {
+		// Another upload used up the space while this one was being assembled
+		status = "error"
+		err = fc.Repo.FinalizeFile(session.UploadID(), len(req.Parts), finalETag, status, "", fc.Store)
+		if err == nil {
+			fc.Repo.DB.Delete(&session.Pending)
+			c.JSON(http.StatusInsufficientStorage, gin.H{"error": "Not enough space. Delete some files"})
+			return
+		}
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file record"})
 		return
 	}
-	fc.Repo.DB.Where("upload_id = ?", req.UploadID).Delete(&models.PendingUpload{})
+	fc.Repo.DB.Delete(&session.Pending)
 
+	if status == "error" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "uploaded content does not match its declared checksum"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "upload completed successfully"})
 }
 
+// AbortMultipartUpload abandons an in-progress upload, discarding the uploaded parts
+// along with its pending file.
+func (fc *FileController) AbortMultipartUpload(c *gin.Context) {
+	var req struct {
+		SessionID uuid.UUID `json:"sessionId" binding:"required"`
+		UploadID  string    `json:"uploadId"`
+		Key       string    `json:"key"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, ok := fc.uploadSession(c, req.SessionID, req.UploadID, req.Key)
+	if !ok {
+		return
+	}
+	if err := fc.Repo.AbortUploadSession(session, fc.Store); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to abort upload"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "upload aborted"})
+}
+
+// uploadSession loads the caller's upload session, responding with an error if it is not
+// theirs or does not match the uploadId and key they sent.
+func (fc *FileController) uploadSession(c *gin.Context, sessionID uuid.UUID, uploadID string, key string) (*repositories.UploadSession, bool) {
+	userID := uuid.MustParse(c.GetString("userID"))
+	session, err := fc.Repo.GetUploadSession(sessionID, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrUploadSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load upload session"})
+		return nil, false
+	}
+	if err := session.Matches(uploadID, key); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return session, true
+}
+
 // Syncs the files on opening my files tab to check and update the upload status of pending S3 multipart uploads
 func (fc *FileController) SyncUserUploads(c *gin.Context) {
 	userID := uuid.MustParse(c.GetString("userID"))
@@ -454,34 +1010,25 @@ func (fc *FileController) SyncUserUploads(c *gin.Context) {
 		}
 		// if file.S3UploadID == nil || time.Since(file.UpdatedAt) < 5 * time.Minute { continue }
 
-		out, err := fc.S3Client.ListParts(c.Request.Context(), &s3.ListPartsInput{
-			Bucket:   aws.String(file.BucketName),
-			Key:      aws.String(file.ObjectKey),
-			UploadId: file.S3UploadID,
-		})
+		parts, err := fc.Store.ListParts(c.Request.Context(), file.ObjectKey, *file.S3UploadID)
 
 		if err != nil {
-			var apiErr smithy.APIError
 			// Check if the error is specifically because the upload no longer exists in S3
-			if errors.As(err, &apiErr) {
-				switch apiErr.ErrorCode() {
-				case "NoSuchUpload":
-					// S3 Lifecycle rule likely deleted the parts. Reset the DB record.
-					fc.Repo.DB.Model(&file).Updates(map[string]interface{}{
-						"s3_upload_id":          nil,
-						"uploaded_chunks":       0,
-						"uploaded_part_numbers": 0,
-						"upload_status":         "paused",
-					})
-					continue
-				}
+			if errors.Is(err, storage.ErrNoSuchUpload) {
+				// S3 Lifecycle rule likely deleted the parts. Reset the DB record.
+				fc.Repo.DB.Model(&file).Updates(map[string]interface{}{
+					"s3_upload_id":          nil,
+					"uploaded_chunks":       0,
+					"uploaded_part_numbers": 0,
+					"upload_status":         "paused",
+				})
 			}
 			continue
 		}
 
 		// Update DB with what S3 actually has
 		fc.Repo.DB.Model(&file).Updates(map[string]interface{}{
-			"uploaded_chunks": len(out.Parts),
+			"uploaded_chunks": len(parts),
 			"upload_status":   "paused",
 		})
 	}
@@ -489,11 +1036,12 @@ func (fc *FileController) SyncUserUploads(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Sync complete"})
 }
 
+// ShareRequest shares either one file or a whole folder; a folder grant covers everything below it.
 type ShareRequest struct {
-	FileID     uuid.UUID             `json:"fileId" binding:"required"`
+	FileID     uuid.UUID             `json:"fileId" binding:"required_without=FolderID,excluded_with=FolderID"`
 	FolderID   uuid.UUID             `json:"folderId"`
-	Emails     []string              `json:"emails" binding:"required"`
-	Permission models.PermissionType `json:"permission" binding:"required"`
+	Emails     []string              `json:"emails" binding:"required,min=1,dive,email"`
+	Permission models.PermissionType `json:"permission" binding:"required,oneof=viewer editor"`
 }
 
 func (fc *FileController) ShareFilesToUsersByEmails(c *gin.Context) {
@@ -505,77 +1053,108 @@ func (fc *FileController) ShareFilesToUsersByEmails(c *gin.Context) {
 
 	userID := uuid.MustParse(c.GetString("userID"))
 
-	fmt.Println(userID, req.FileID)
+	var resourceName string
+	target := models.ResourcePermission{GrantedBy: userID, Permission: req.Permission}
+	// The partial unique indexes repeat their predicate in the conflict target
+	conflict := clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"permission", "granted_by"})}
 
-	file, err := fc.Repo.GetFileByID(req.FileID, userID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
-		return
+	if req.FolderID != uuid.Nil {
+		folder, _, err := fc.PermissionRepo.AuthorizeFolder(req.FolderID, userID, repositories.ActionReshare)
+		if err != nil {
+			respondAuthzError(c, err, "Folder not found")
+			return
+		}
+		resourceName = folder.Name
+		target.FolderID = &folder.ID
+		conflict.Columns = []clause.Column{{Name: "folder_id"}, {Name: "user_id"}}
+		conflict.TargetWhere = clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "folder_id IS NOT NULL"}}}
+	} else {
+		file, _, err := fc.PermissionRepo.AuthorizeFile(req.FileID, userID, repositories.ActionReshare)
+		if err != nil {
+			respondAuthzError(c, err, "File not found")
+			return
+		}
+		resourceName = file.Name
+		target.FileID = &file.ID
+		conflict.Columns = []clause.Column{{Name: "file_id"}, {Name: "user_id"}}
+		conflict.TargetWhere = clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "file_id IS NOT NULL"}}}
+	}
+
+	emails := make([]string, 0, len(req.Emails))
+	seen := make(map[string]bool, len(req.Emails))
+	for _, email := range req.Emails {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
 	}
 
 	var targetUsers []models.Users
-	if err := fc.UserRepo.DB.Where("email IN ?", req.Emails).Find(&targetUsers).Error; err != nil {
+	if err := fc.UserRepo.DB.Where("LOWER(email) IN ?", emails).Find(&targetUsers).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up users"})
 		return
 	}
 
-	if len(targetUsers) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No registered users found for these emails"})
-		return
+	// Emails without an account get an invitation that turns into a grant when they sign up
+	registered := make(map[string]bool, len(targetUsers))
+	for _, user := range targetUsers {
+		registered[strings.ToLower(user.Email)] = true
+	}
+	var invited []string
+	for _, email := range emails {
+		if !registered[email] {
+			invited = append(invited, email)
+		}
 	}
 
 	var permissions []models.ResourcePermission
 	for _, user := range targetUsers {
-		permission := models.ResourcePermission{
-			FileID:     &file.ID,
-			UserID:     user.ID,
-			FolderID:   &req.FolderID,
-			GrantedBy:  userID,
-			Permission: models.PermissionType(req.Permission),
-			CreatedAt:  time.Now(),
-		}
-
-		if req.FolderID != uuid.Nil {
-			permission.FolderID = &req.FolderID
-		} else {
-			permission.FolderID = nil
-		}
+		permission := target
+		permission.UserID = user.ID
+		permission.CreatedAt = time.Now()
 		permissions = append(permissions, permission)
 	}
 
-	err = fc.Repo.DB.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "file_id"}, {Name: "user_id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"permission", "granted_by"}),
-	}).Create(&permissions).Error
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update permissions"})
+	if len(permissions) > 0 {
+		if err := fc.Repo.DB.Clauses(conflict).Create(&permissions).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update permissions"})
+			return
+		}
+	}
+	if err := fc.PermissionRepo.CreateInvitations(target, invited); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite users"})
 		return
 	}
 
-	go fc.sendShareEmails(targetUsers, file.Name)
+	if len(targetUsers) > 0 {
+		go fc.sendShareEmails(targetUsers, resourceName)
+	}
+	if len(invited) > 0 {
+		if inviter, err := fc.UserRepo.GetByID(userID); err == nil {
+			go sendInviteEmails(invited, inviter.FirstName, resourceName)
+		}
+	}
 
+	message := "File shared successfully"
+	if target.FolderID != nil {
+		message = "Folder shared successfully"
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message":         "File shared successfully",
+		"message":         message,
 		"sharedWithCount": len(targetUsers),
+		"invitedCount":    len(invited),
 	})
 }
 
-func (fc *FileController) sendShareEmails(users []models.Users, fileName string) {
+func (fc *FileController) sendShareEmails(users []models.Users, resourceName string) {
 	m := mail.NewMsg()
 	if err := m.From(os.Getenv("GMAIL_USER")); err != nil {
 		fmt.Printf("failed to set from address: %v\n", err)
 		return
 	}
 
-	client, err := mail.NewClient("smtp.gmail.com",
-		mail.WithPort(465),
-		mail.WithSSL(),
-		mail.WithSMTPAuth(mail.SMTPAuthPlain),
-		mail.WithUsername(os.Getenv("GMAIL_USER")),
-		mail.WithPassword(os.Getenv("GMAIL_APP_PASSWORD")),
-	)
-
+	client, err := newMailClient()
 	if err != nil {
 		fmt.Printf("failed to create mail client: %v\n", err)
 		return
@@ -584,13 +1163,13 @@ func (fc *FileController) sendShareEmails(users []models.Users, fileName string)
 	// Need to change the link to real link later
 	for _, user := range users {
 		m.To(user.Email)
-		m.Subject(fmt.Sprintf("A file has been shared with you: %s", fileName))
+		m.Subject(fmt.Sprintf("%s has been shared with you", resourceName))
 		body := fmt.Sprintf(`
 			<h3>Hello %s,</h3>
 			<p>You have been granted access to <b>%s</b>.</p>
 			<p>Click the link below to view the file:</p>
 			<a href="%s/dashboard/shared">View File</a>
-		`, user.FirstName, fileName, os.Getenv("FRONTEND_URL"))
+		`, user.FirstName, resourceName, os.Getenv("FRONTEND_URL"))
 
 		m.SetBodyString(mail.TypeTextHTML, body)
 
@@ -601,6 +1180,16 @@ func (fc *FileController) sendShareEmails(users []models.Users, fileName string)
 
 }
 
+func newMailClient() (*mail.Client, error) {
+	return mail.NewClient("smtp.gmail.com",
+		mail.WithPort(465),
+		mail.WithSSL(),
+		mail.WithSMTPAuth(mail.SMTPAuthPlain),
+		mail.WithUsername(os.Getenv("GMAIL_USER")),
+		mail.WithPassword(os.Getenv("GMAIL_APP_PASSWORD")),
+	)
+}
+
 func (fc *FileController) SharedWithUserFiles(c *gin.Context) {
 	userID := uuid.MustParse(c.GetString("userID"))
 
@@ -630,3 +1219,125 @@ func (fc *FileController) SharedWithUserFiles(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+// parseByteRange parses a single-range "bytes=" header against an object of the given size
+// and returns the offset and length to serve. Multi-range requests are not supported.
+func parseByteRange(header string, size int64) (int64, int64, bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") || size == 0 {
+		return 0, 0, false
+	}
+
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if startStr == "" {
+		// Suffix range: the last N bytes
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
+
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondAuthzError answers a failed authorization: 404 when the caller cannot see the
+// resource at all, 403 when they can but their role does not allow the action.
+func respondAuthzError(c *gin.Context, err error, notFound string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+	case errors.Is(err, repositories.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// respondMoveCopyError maps the repository errors shared by the move and copy endpoints.
+func respondMoveCopyError(c *gin.Context, err error, notFound string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+	case errors.Is(err, repositories.ErrDestinationNotFound), errors.Is(err, repositories.ErrMoveIntoDescendant),
+		errors.Is(err, repositories.ErrCrossOwnerMove):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repositories.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, repositories.ErrInsufficientStorage):
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": "Not enough space. Delete some files"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// bindListOptions reads the sort, order, cursor and limit query parameters shared by the listings.
+// It writes a 400 and returns false when they are invalid.
+func bindListOptions(c *gin.Context) (repositories.ListOptions, bool) {
+	var req struct {
+		Sort   string `form:"sort"`
+		Order  string `form:"order" binding:"omitempty,oneof=asc desc"`
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit" binding:"omitempty,min=1"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return repositories.ListOptions{}, false
+	}
+	if !repositories.IsValidSort(req.Sort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of name, size, createdAt, updatedAt, type"})
+		return repositories.ListOptions{}, false
+	}
+	return repositories.ListOptions{
+		Sort:   req.Sort,
+		Desc:   req.Order == "desc",
+		Cursor: req.Cursor,
+		Limit:  req.Limit,
+	}, true
+}
+
+func partURLExpiry(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultPartURLExpiry
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func optionalCursor(cursor string) *string {
+	if cursor == "" {
+		return nil
+	}
+	return &cursor
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/dtos"
+	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/repositories"
+	"gorm.io/gorm"
+)
+
+// ListFileVersions returns the current version followed by the older ones, newest first.
+func (fc *FileController) ListFileVersions(c *gin.Context) {
+	file, ok := fc.loadVersionedFile(c, repositories.ActionRead)
+	if !ok {
+		return
+	}
+
+	versions, err := fc.Repo.GetFileVersions(file.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := []dtos.FileVersionResponse{{
+		VersionNumber: file.Version,
+		Size:          file.Size,
+		MimeType:      file.MimeType,
+		CreatedAt:     file.UpdatedAt,
+		IsCurrent:     true,
+	}}
+	for _, v := range versions {
+		response = append(response, dtos.FileVersionResponse{
+			VersionNumber: v.VersionNumber,
+			Size:          v.Size,
+			MimeType:      v.MimeType,
+			CreatedAt:     v.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (fc *FileController) GetFileVersionDownloadURL(c *gin.Context) {
+	file, ok := fc.loadVersionedFile(c, repositories.ActionRead)
+	if !ok {
+		return
+	}
+	versionNumber, ok := versionParam(c)
+	if !ok {
+		return
+	}
+
+	objectKey := file.ObjectKey
+	if versionNumber != file.Version {
+		version, err := fc.Repo.GetFileVersion(file.ID, versionNumber)
+		if err != nil {
+			respondVersionError(c, err)
+			return
+		}
+		objectKey = version.ObjectKey
+	}
+
+	contentDisposition := fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(file.Name))
+	presignedURL, err := fc.Store.PresignGetObject(c.Request.Context(), objectKey, contentDisposition)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate URL"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": presignedURL})
+}
+
+func (fc *FileController) RestoreFileVersion(c *gin.Context) {
+	file, ok := fc.loadVersionedFile(c, repositories.ActionUpload)
+	if !ok {
+		return
+	}
+	versionNumber, ok := versionParam(c)
+	if !ok {
+		return
+	}
+
+	if err := fc.Repo.RestoreFileVersion(&file, versionNumber); err != nil {
+		respondVersionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Version restored"})
+}
+
+func (fc *FileController) DeleteFileVersion(c *gin.Context) {
+	file, ok := fc.loadVersionedFile(c, repositories.ActionDelete)
+	if !ok {
+		return
+	}
+	versionNumber, ok := versionParam(c)
+	if !ok {
+		return
+	}
+
+	if err := fc.Repo.DeleteFileVersion(&file, versionNumber, fc.Store); err != nil {
+		respondVersionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Version deleted"})
+}
+
+// loadVersionedFile resolves :fileId for the caller and checks their role allows action.
+// Anyone the file is shared with may read its history, editors may restore an older version and
+// only the owner may delete one.
+func (fc *FileController) loadVersionedFile(c *gin.Context, action repositories.Action) (models.File, bool) {
+	fileID, err := uuid.Parse(c.Param("fileId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fileId"})
+		return models.File{}, false
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	file, _, err := fc.PermissionRepo.AuthorizeFile(fileID, userID, action)
+	if err != nil {
+		respondAuthzError(c, err, "File not found")
+		return models.File{}, false
+	}
+	if file.IsDeleted || file.UploadStatus != "completed" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return models.File{}, false
+	}
+	return file, true
+}
+
+func versionParam(c *gin.Context) (int, bool) {
+	versionNumber, err := strconv.Atoi(c.Param("version"))
+	if err != nil || versionNumber < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return 0, false
+	}
+	return versionNumber, true
+}
+
+func respondVersionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+	case errors.Is(err, repositories.ErrCurrentVersion):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,26 +1,30 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/richeek45/filedrive/dtos"
 	"github.com/richeek45/filedrive/models"
 	"github.com/richeek45/filedrive/repositories"
+	"github.com/richeek45/filedrive/storage"
+	"gorm.io/gorm"
 )
 
 type FolderController struct {
-	Repo     *repositories.FolderRepository
-	S3Client *s3.Client
-	Bucket   string
+	Repo           *repositories.FolderRepository
+	PermissionRepo *repositories.PermissionRepository
+	Store          storage.ObjectStore
+	Bucket         string
 }
 
 func formatFolders(folders []models.Folder) []dtos.FolderResponse {
-	var response []dtos.FolderResponse
+	response := make([]dtos.FolderResponse, 0, len(folders))
 
 	for _, f := range folders {
 		var parentID uuid.UUID
@@ -63,12 +67,18 @@ func (fc *FolderController) CreateFolder(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot parse UUID"})
 		return
 	}
-	folder, err := fc.Repo.CreateFolder(userID, req.Name, req.ParentID)
+	ownerID, err := fc.PermissionRepo.AuthorizeDestination(req.ParentID, userID)
+	if err != nil {
+		respondMoveCopyError(c, err, "Folder not found")
+		return
+	}
+	folder, err := fc.Repo.CreateFolder(ownerID, req.Name, req.ParentID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	invalidateFolderCache(ownerID)
 	c.JSON(http.StatusCreated, folder)
 }
 
@@ -92,35 +102,39 @@ func (fc *FolderController) FindRootFolders(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot parse UUID"})
 		return
 	}
+	opts, ok := bindListOptions(c)
+	if !ok {
+		return
+	}
 	parentIDParam := c.Query("parentId")
 
-	if parentIDParam == "" {
-		folders, err := fc.Repo.GetRootLevelFolderFromUserID(userID, isTrash)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// If parentId present → fetch children
+	var parentID *uuid.UUID
+	if parentIDParam != "" {
+		parentUUID, parseErr := uuid.Parse(parentIDParam)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parentId"})
 			return
 		}
-		response := formatFolders(folders)
-
-		c.JSON(http.StatusOK, response)
-		return
+		parentID = &parentUUID
 	}
 
-	// If parentId present → fetch children
-	parentUUID, err := uuid.Parse(parentIDParam)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parentId"})
+	ownerID, _, ok := listingOwner(c, fc.PermissionRepo, userID, parentID, isTrash)
+	if !ok {
 		return
 	}
 
-	folders, err := fc.Repo.GetFoldersByParentID(userID, parentUUID, isTrash)
+	folders, nextCursor, err := fc.Repo.GetFolders(ownerID, parentID, isTrash, opts)
 	if err != nil {
+		if errors.Is(err, repositories.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	response := formatFolders(folders)
 
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, dtos.FolderListResponse{Items: formatFolders(folders), NextCursor: optionalCursor(nextCursor)})
 }
 
 func (fc *FolderController) RenameFolder(c *gin.Context) {
@@ -135,13 +149,382 @@ func (fc *FolderController) RenameFolder(c *gin.Context) {
 	folderID := uuid.MustParse(c.Param("folderId"))
 	userID := uuid.MustParse(c.GetString("userID"))
 
-	err := fc.Repo.DB.Model(&models.Folder{}).
-		Where("id = ? AND owner_id = ?", folderID, userID).
-		Update("name", req.NewName).Error
+	folder, _, err := fc.PermissionRepo.AuthorizeFolder(folderID, userID, repositories.ActionRename)
+	if err != nil {
+		respondAuthzError(c, err, "Folder not found")
+		return
+	}
 
+	err = fc.Repo.DB.Model(&folder).Update("name", req.NewName).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
 		return
 	}
+	invalidateFolderCache(folder.OwnerID)
 	c.JSON(http.StatusOK, gin.H{"message": "Renamed successfully"})
 }
+
+// DownloadFolder streams the folder and everything below it as a ZIP archive.
+func (fc *FolderController) DownloadFolder(c *gin.Context) {
+	folderID, err := uuid.Parse(c.Param("folderId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folderId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	if _, _, err := fc.PermissionRepo.AuthorizeFolder(folderID, userID, repositories.ActionRead); err != nil {
+		respondAuthzError(c, err, "Folder not found")
+		return
+	}
+	folders, files, err := fc.Repo.GetFolderTree(folderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rootName string
+	for _, f := range folders {
+		if f.ID == folderID {
+			rootName = f.Name
+		}
+	}
+
+	entries := folderArchiveEntries(folderID, folders, files, "")
+	streamZip(c, fc.Store, sanitizeArchiveName(rootName)+".zip", entries)
+}
+
+func (fc *FolderController) MoveToTrash(c *gin.Context) {
+	folderID, err := uuid.Parse(c.Param("folderId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folderId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	folder, err := fc.PermissionRepo.AuthorizeFolderRemoval(folderID, userID)
+	if err != nil {
+		respondAuthzError(c, err, "Folder not found")
+		return
+	}
+
+	if err := fc.Repo.DeleteFolder(folder.ID, folder.OwnerID, fc.Store); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	invalidateFolderCache(folder.OwnerID)
+	c.JSON(http.StatusOK, gin.H{"message": "Folder moved to trash"})
+}
+
+func (fc *FolderController) RestoreFolder(c *gin.Context) {
+	var req struct {
+		FolderID uuid.UUID `json:"folderId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := uuid.MustParse(c.GetString("userID"))
+	folder, _, err := fc.PermissionRepo.AuthorizeFolder(req.FolderID, userID, repositories.ActionDelete)
+	if err != nil {
+		respondAuthzError(c, err, "Folder not found")
+		return
+	}
+
+	if err := fc.Repo.RestoreFolder(folder.ID, folder.OwnerID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	invalidateFolderCache(folder.OwnerID)
+	c.JSON(http.StatusOK, gin.H{"message": "restored successfully"})
+}
+
+// PermanentDeleteFolder skips the trash; the folder may be live or already trashed.
+func (fc *FolderController) PermanentDeleteFolder(c *gin.Context) {
+	folderID, err := uuid.Parse(c.Param("folderId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folderId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	folder, _, err := fc.PermissionRepo.AuthorizeFolder(folderID, userID, repositories.ActionDelete)
+	if err != nil {
+		respondAuthzError(c, err, "Folder not found")
+		return
+	}
+
+	if err := fc.Repo.PermanentDeleteFolder(&folder, fc.Store); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	invalidateFolderCache(folder.OwnerID)
+	c.JSON(http.StatusOK, gin.H{"message": "Folder deleted permanently"})
+}
+
+func (fc *FolderController) MoveFolder(c *gin.Context) {
+	var req struct {
+		ParentID *uuid.UUID `json:"parentId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folderID, err := uuid.Parse(c.Param("folderId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folderId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	folder, _, err := fc.PermissionRepo.AuthorizeFolder(folderID, userID, repositories.ActionRename)
+	if err != nil {
+		respondMoveCopyError(c, err, "Folder not found")
+		return
+	}
+	ownerID, err := fc.PermissionRepo.AuthorizeDestination(req.ParentID, userID)
+	if err == nil && ownerID != folder.OwnerID {
+		err = repositories.ErrCrossOwnerMove
+	}
+	if err != nil {
+		respondMoveCopyError(c, err, "Folder not found")
+		return
+	}
+
+	if err := fc.Repo.MoveFolder(folder.ID, folder.OwnerID, req.ParentID); err != nil {
+		respondMoveCopyError(c, err, "Folder not found")
+		return
+	}
+	invalidateFolderCache(folder.OwnerID)
+	c.JSON(http.StatusOK, gin.H{"message": "Moved successfully"})
+}
+
+func (fc *FolderController) CopyFolder(c *gin.Context) {
+	var req struct {
+		ParentID *uuid.UUID `json:"parentId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folderID, err := uuid.Parse(c.Param("folderId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folderId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	if _, _, err := fc.PermissionRepo.AuthorizeFolder(folderID, userID, repositories.ActionRead); err != nil {
+		respondMoveCopyError(c, err, "Folder not found")
+		return
+	}
+	ownerID, err := fc.PermissionRepo.AuthorizeDestination(req.ParentID, userID)
+	if err != nil {
+		respondMoveCopyError(c, err, "Folder not found")
+		return
+	}
+
+	folder, err := fc.Repo.CopyFolder(folderID, ownerID, req.ParentID, fc.Store)
+	if err != nil {
+		respondMoveCopyError(c, err, "Folder not found")
+		return
+	}
+	invalidateFolderCache(ownerID)
+	c.JSON(http.StatusCreated, folder)
+}
+
+// GetBreadcrumbs returns the folders from the root down to folderId, for the dashboard's path bar.
+func (fc *FolderController) GetBreadcrumbs(c *gin.Context) {
+	folderID, err := uuid.Parse(c.Param("folderId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folderId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	ownerID, _, ok := listingOwner(c, fc.PermissionRepo, userID, &folderID, false)
+	if !ok {
+		return
+	}
+
+	folders, err := fc.Repo.GetAncestors(folderID, ownerID)
+	if err == nil && ownerID != userID {
+		folders, err = fc.sharedAncestors(userID, folders)
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := make([]dtos.BreadcrumbResponse, 0, len(folders))
+	for _, f := range folders {
+		response = append(response, dtos.BreadcrumbResponse{ID: f.ID, Name: f.Name})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ResolvePath maps a slash separated path such as /a/b/c to the ID of the folder it names.
+// The root path resolves to a null id.
+func (fc *FolderController) ResolvePath(c *gin.Context) {
+	var names []string
+	for _, name := range strings.Split(c.Query("path"), "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	folderID, err := resolveFolderPath(fc.Repo, userID, nil, names, false)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": folderID, "path": "/" + strings.Join(names, "/")})
+}
+
+// GetFolderContents lists the folders and then the files under parentId in one paginated response,
+// so the dashboard needs a single round trip per page.
+func (fc *FolderController) GetFolderContents(c *gin.Context) {
+	var req struct {
+		ParentID string `form:"parentId"`
+		IsTrash  bool   `form:"isTrash"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts, ok := bindListOptions(c)
+	if !ok {
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	var parentID *uuid.UUID
+	if req.ParentID != "" {
+		parsed, err := uuid.Parse(req.ParentID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parentId"})
+			return
+		}
+		parentID = &parsed
+	}
+
+	ownerID, permission, ok := listingOwner(c, fc.PermissionRepo, userID, parentID, req.IsTrash)
+	if !ok {
+		return
+	}
+
+	contents, nextCursor, err := fc.Repo.GetFolderContents(ownerID, parentID, req.IsTrash, opts)
+	if err != nil {
+		if errors.Is(err, repositories.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]dtos.FolderContentItem, 0, len(contents.Folders)+len(contents.Files))
+	for _, f := range contents.Folders {
+		childCount := contents.ChildCounts[f.ID]
+		items = append(items, dtos.FolderContentItem{
+			Type:       "folder",
+			ID:         f.ID,
+			Name:       f.Name,
+			ParentID:   f.ParentID,
+			Permission: string(permission),
+			ChildCount: &childCount,
+			IsDeleted:  f.IsDeleted,
+			CreatedAt:  f.CreatedAt,
+			UpdatedAt:  f.UpdatedAt,
+		})
+	}
+	for _, f := range contents.Files {
+		items = append(items, dtos.FolderContentItem{
+			Type:         "file",
+			ID:           f.ID,
+			Name:         f.Name,
+			ParentID:     f.FolderID,
+			Size:         f.Size,
+			MimeType:     f.MimeType,
+			Permission:   string(permission),
+			UploadStatus: f.UploadStatus,
+			IsDeleted:    f.IsDeleted,
+			CreatedAt:    f.CreatedAt,
+			UpdatedAt:    f.UpdatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, dtos.FolderContentsResponse{Items: items, NextCursor: optionalCursor(nextCursor)})
+}
+
+// SharedWithMe lists the folders other users have shared with the caller.
+func (fc *FolderController) SharedWithMe(c *gin.Context) {
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	folders, err := fc.PermissionRepo.SharedFoldersByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, folders)
+}
+
+// sharedAncestors cuts a breadcrumb trail through someone else's folders down to the part
+// userID can see: from the highest folder shared with them.
+func (fc *FolderController) sharedAncestors(userID uuid.UUID, folders []models.Folder) ([]models.Folder, error) {
+	ids := make([]uuid.UUID, 0, len(folders))
+	for _, f := range folders {
+		ids = append(ids, f.ID)
+	}
+	granted, err := fc.PermissionRepo.GrantedFolderIDs(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range folders {
+		if granted[f.ID] {
+			return folders[i:], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// listingOwner decides whose tree a listing under parentID reads from: the caller's own, or the
+// folder owner's when the folder is shared with the caller. It responds 404 if the caller cannot
+// see the folder. Shared folders are browsed live only; the trash belongs to their owner, so
+// trashed folders and trash listings are authorized as ActionDelete.
+func listingOwner(c *gin.Context, perms *repositories.PermissionRepository, userID uuid.UUID, parentID *uuid.UUID, isTrash bool) (uuid.UUID, models.PermissionType, bool) {
+	if parentID == nil {
+		return userID, models.PermissionOwner, true
+	}
+
+	action := repositories.ActionRead
+	if isTrash {
+		action = repositories.ActionDelete
+	}
+	folder, permission, err := perms.AuthorizeFolder(*parentID, userID, action)
+	if errors.Is(err, gorm.ErrRecordNotFound) && action == repositories.ActionRead {
+		folder, permission, err = perms.AuthorizeFolder(*parentID, userID, repositories.ActionDelete)
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, repositories.ErrForbidden) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return uuid.Nil, "", false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, "", false
+	}
+	return folder.OwnerID, permission, true
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/dtos"
+	"github.com/richeek45/filedrive/repositories"
+)
+
+const maxSearchPageSize = 100
+
+type SearchController struct {
+	Repo *repositories.SearchRepository
+}
+
+func (sc *SearchController) Search(c *gin.Context) {
+	var req struct {
+		Query    string     `form:"q"`
+		Mode     string     `form:"mode" binding:"omitempty,oneof=substring prefix"`
+		Type     string     `form:"type" binding:"omitempty,oneof=file folder"`
+		MimeType string     `form:"mimeType"`
+		MinSize  *int64     `form:"minSize" binding:"omitempty,min=0"`
+		MaxSize  *int64     `form:"maxSize" binding:"omitempty,min=0"`
+		From     *time.Time `form:"modifiedFrom"`
+		To       *time.Time `form:"modifiedTo"`
+		Owner    string     `form:"owner" binding:"omitempty,oneof=me shared"`
+		Trashed  bool       `form:"trashed"`
+		Page     int        `form:"page" binding:"omitempty,min=1"`
+		PageSize int        `form:"pageSize" binding:"omitempty,min=1"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.PageSize == 0 {
+		req.PageSize = 50
+	}
+	if req.PageSize > maxSearchPageSize {
+		req.PageSize = maxSearchPageSize
+	}
+
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	// One extra row tells us whether there is another page
+	results, err := sc.Repo.Search(userID, repositories.SearchParams{
+		Query:        req.Query,
+		Prefix:       req.Mode == "prefix",
+		Type:         req.Type,
+		MimeType:     req.MimeType,
+		MinSize:      req.MinSize,
+		MaxSize:      req.MaxSize,
+		ModifiedFrom: req.From,
+		ModifiedTo:   req.To,
+		Owner:        req.Owner,
+		Trashed:      req.Trashed,
+		Limit:        req.PageSize + 1,
+		Offset:       (req.Page - 1) * req.PageSize,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	hasMore := len(results) > req.PageSize
+	if hasMore {
+		results = results[:req.PageSize]
+	}
+
+	// Paths are only shown for our own items; a shared file's folders belong to someone else
+	var folderIDs []uuid.UUID
+	for _, r := range results {
+		if r.FolderID != nil && r.OwnerID == userID {
+			folderIDs = append(folderIDs, *r.FolderID)
+		}
+	}
+	paths, err := sc.Repo.FolderPaths(folderIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]dtos.SearchResultResponse, 0, len(results))
+	for _, r := range results {
+		path := ""
+		if r.OwnerID == userID {
+			path = "/"
+			if r.FolderID != nil {
+				path = paths[*r.FolderID]
+			}
+		}
+		items = append(items, dtos.SearchResultResponse{
+			Type:       r.Type,
+			ID:         r.ID,
+			Name:       r.Name,
+			FolderID:   r.FolderID,
+			Path:       path,
+			Size:       r.Size,
+			MimeType:   r.MimeType,
+			Permission: r.Permission,
+			IsDeleted:  r.IsDeleted,
+			CreatedAt:  r.CreatedAt,
+			UpdatedAt:  r.UpdatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, dtos.SearchResponse{
+		Items:    items,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+		HasMore:  hasMore,
+	})
+}
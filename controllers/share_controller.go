@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/dtos"
+	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/repositories"
+	"github.com/wneessen/go-mail"
+	"gorm.io/gorm"
+)
+
+// ShareTarget names either one file or one folder, like ShareRequest.
+type ShareTarget struct {
+	FileID   uuid.UUID `json:"fileId" binding:"required_without=FolderID,excluded_with=FolderID"`
+	FolderID uuid.UUID `json:"folderId"`
+}
+
+// ListGrants shows who has access to a file or folder: its owner, and every grant on it or
+// inherited from the folders above it.
+func (fc *FileController) ListGrants(c *gin.Context) {
+	fileID, fileErr := uuid.Parse(c.Query("fileId"))
+	folderID, folderErr := uuid.Parse(c.Query("folderId"))
+	if (fileErr == nil) == (folderErr == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of fileId and folderId is required"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	var ownerID uuid.UUID
+	var grants []dtos.GrantResponse
+	if folderErr == nil {
+		folder, _, err := fc.PermissionRepo.AuthorizeFolder(folderID, userID, repositories.ActionRead)
+		if err != nil {
+			respondAuthzError(c, err, "Folder not found")
+			return
+		}
+		ownerID = folder.OwnerID
+		grants, err = fc.PermissionRepo.FolderGrants(&folder)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		file, _, err := fc.PermissionRepo.AuthorizeFile(fileID, userID, repositories.ActionRead)
+		if err != nil {
+			respondAuthzError(c, err, "File not found")
+			return
+		}
+		ownerID = file.OwnerID
+		grants, err = fc.PermissionRepo.FileGrants(&file)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	owner, err := fc.UserRepo.GetByID(ownerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load owner"})
+		return
+	}
+	if grants == nil {
+		grants = []dtos.GrantResponse{}
+	}
+	c.JSON(http.StatusOK, dtos.SharingResponse{
+		Owner:  dtos.ShareOwnerResponse{ID: owner.ID, Name: owner.FirstName + " " + owner.LastName, Email: owner.Email},
+		Grants: grants,
+	})
+}
+
+// UpdateGrant changes the role of one grant. Inherited grants are changed on the folder they
+// were made on.
+func (fc *FileController) UpdateGrant(c *gin.Context) {
+	var req struct {
+		Permission models.PermissionType `json:"permission" binding:"required,oneof=viewer editor"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	grant, ok := fc.loadGrant(c, false)
+	if !ok {
+		return
+	}
+	if err := fc.Repo.DB.Model(&grant).Update("permission", req.Permission).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update permission"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Permission updated"})
+}
+
+// RevokeGrant removes one grant. Grantees may always give up their own access.
+func (fc *FileController) RevokeGrant(c *gin.Context) {
+	grant, ok := fc.loadGrant(c, true)
+	if !ok {
+		return
+	}
+	if err := fc.Repo.DB.Delete(&grant).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke permission"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Access revoked"})
+}
+
+// TransferOwnership hands a file or folder over to another registered user, who is notified by
+// email. The item moves to the new owner's root and the previous owner keeps editor access.
+func (fc *FileController) TransferOwnership(c *gin.Context) {
+	var req struct {
+		ShareTarget
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	var newOwner models.Users
+	if err := fc.UserRepo.DB.Where("LOWER(email) = ?", strings.ToLower(req.Email)).First(&newOwner).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No registered user found for this email"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+	if newOwner.ID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": repositories.ErrAlreadyOwner.Error()})
+		return
+	}
+
+	var resourceName string
+	var err error
+	if req.FolderID != uuid.Nil {
+		var folder models.Folder
+		folder, _, err = fc.PermissionRepo.AuthorizeFolder(req.FolderID, userID, repositories.ActionTransfer)
+		if err != nil {
+			respondAuthzError(c, err, "Folder not found")
+			return
+		}
+		resourceName = folder.Name
+		err = fc.PermissionRepo.TransferFolder(&folder, newOwner.ID)
+	} else {
+		var file models.File
+		file, _, err = fc.PermissionRepo.AuthorizeFile(req.FileID, userID, repositories.ActionTransfer)
+		if err != nil {
+			respondAuthzError(c, err, "File not found")
+			return
+		}
+		resourceName = file.Name
+		err = fc.PermissionRepo.TransferFile(&file, newOwner.ID)
+	}
+	if err != nil {
+		if errors.Is(err, repositories.ErrInsufficientStorage) {
+			c.JSON(http.StatusInsufficientStorage, gin.H{"error": "The new owner does not have enough space"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	invalidateFolderCache(userID)
+	invalidateFolderCache(newOwner.ID)
+
+	previousOwner, err := fc.UserRepo.GetByID(userID)
+	if err == nil {
+		go sendOwnershipEmail(newOwner, previousOwner.FirstName, resourceName)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Ownership transferred"})
+}
+
+// loadGrant resolves :permissionId and checks the caller may manage it: reshare rights on the
+// item it was made on, or, when allowSelf is set, being the grantee.
+func (fc *FileController) loadGrant(c *gin.Context, allowSelf bool) (models.ResourcePermission, bool) {
+	grantID, err := uuid.Parse(c.Param("permissionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid permissionId"})
+		return models.ResourcePermission{}, false
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	var grant models.ResourcePermission
+	if err := fc.Repo.DB.Where("id = ?", grantID).First(&grant).Error; err != nil {
+		respondAuthzError(c, err, "Permission not found")
+		return models.ResourcePermission{}, false
+	}
+	if allowSelf && grant.UserID == userID {
+		return grant, true
+	}
+
+	if grant.FolderID != nil {
+		_, _, err = fc.PermissionRepo.AuthorizeFolder(*grant.FolderID, userID, repositories.ActionReshare)
+	} else {
+		_, _, err = fc.PermissionRepo.AuthorizeFile(*grant.FileID, userID, repositories.ActionReshare)
+	}
+	if err != nil {
+		respondAuthzError(c, err, "Permission not found")
+		return models.ResourcePermission{}, false
+	}
+	return grant, true
+}
+
+func sendOwnershipEmail(newOwner models.Users, previousOwnerName string, resourceName string) {
+	m := mail.NewMsg()
+	if err := m.From(os.Getenv("GMAIL_USER")); err != nil {
+		fmt.Printf("failed to set from address: %v\n", err)
+		return
+	}
+	if err := m.To(newOwner.Email); err != nil {
+		fmt.Printf("failed to set recipient %s: %v\n", newOwner.Email, err)
+		return
+	}
+
+	client, err := newMailClient()
+	if err != nil {
+		fmt.Printf("failed to create mail client: %v\n", err)
+		return
+	}
+
+	m.Subject(fmt.Sprintf("You are now the owner of %s", resourceName))
+	m.SetBodyString(mail.TypeTextHTML, fmt.Sprintf(`
+		<h3>Hello %s,</h3>
+		<p>%s has made you the owner of <b>%s</b>.</p>
+		<p>It now counts towards your storage and can be found in My Drive.</p>
+		<a href="%s/dashboard">Open FileDrive</a>
+	`, newOwner.FirstName, previousOwnerName, resourceName, os.Getenv("FRONTEND_URL")))
+
+	if err := client.DialAndSend(m); err != nil {
+		fmt.Printf("failed to send email to %s: %v\n", newOwner.Email, err)
+	}
+}
+
+// sendInviteEmails asks people without an account to sign up; what was shared with them is
+// waiting once they do.
+func sendInviteEmails(emails []string, inviterName string, resourceName string) {
+	client, err := newMailClient()
+	if err != nil {
+		fmt.Printf("failed to create mail client: %v\n", err)
+		return
+	}
+
+	for _, email := range emails {
+		m := mail.NewMsg()
+		if err := m.From(os.Getenv("GMAIL_USER")); err != nil {
+			fmt.Printf("failed to set from address: %v\n", err)
+			return
+		}
+		if err := m.To(email); err != nil {
+			fmt.Printf("failed to set recipient %s: %v\n", email, err)
+			continue
+		}
+
+		m.Subject(fmt.Sprintf("%s shared %s with you", inviterName, resourceName))
+		m.SetBodyString(mail.TypeTextHTML, fmt.Sprintf(`
+			<h3>Hello,</h3>
+			<p>%s has shared <b>%s</b> with you on FileDrive.</p>
+			<p>Sign up with this email address to open it:</p>
+			<a href="%s/login?email=%s">Sign up</a>
+		`, inviterName, resourceName, os.Getenv("FRONTEND_URL"), url.QueryEscape(email)))
+
+		if err := client.DialAndSend(m); err != nil {
+			fmt.Printf("failed to send email to %s: %v\n", email, err)
+		}
+	}
+}
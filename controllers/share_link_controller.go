@@ -0,0 +1,289 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/dtos"
+	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/repositories"
+	"github.com/richeek45/filedrive/storage"
+	"gorm.io/gorm"
+)
+
+// ShareLinkController manages public links to files and folders and serves them to
+// anonymous visitors. Only the management endpoints sit behind AuthMiddleware.
+type ShareLinkController struct {
+	Repo           *repositories.ShareLinkRepository
+	PermissionRepo *repositories.PermissionRepository
+	FolderRepo     *repositories.FolderRepository
+	Store          storage.ObjectStore
+}
+
+// LinkPasswordHeader carries a link's password when looking it up; downloads send it in the body.
+const LinkPasswordHeader = "X-Link-Password"
+
+func formatShareLink(link *models.ShareLink) dtos.ShareLinkResponse {
+	return dtos.ShareLinkResponse{
+		ID:             link.ID,
+		Token:          link.Token,
+		URL:            fmt.Sprintf("%s/s/%s", os.Getenv("FRONTEND_URL"), link.Token),
+		FileID:         link.FileID,
+		FolderID:       link.FolderID,
+		ExpiresAt:      link.ExpiresAt,
+		HasPassword:    link.PasswordHash != nil,
+		MaxDownloads:   link.MaxDownloads,
+		AccessCount:    link.AccessCount,
+		DownloadCount:  link.DownloadCount,
+		LastAccessedAt: link.LastAccessedAt,
+		RevokedAt:      link.RevokedAt,
+		CreatedAt:      link.CreatedAt,
+	}
+}
+
+// CreateLink makes a public link to a file or folder the caller may reshare.
+func (sc *ShareLinkController) CreateLink(c *gin.Context) {
+	var req struct {
+		ShareTarget
+		ExpiresAt    *time.Time `json:"expiresAt"`
+		Password     string     `json:"password" binding:"omitempty,min=4,max=72"`
+		MaxDownloads *int       `json:"maxDownloads" binding:"omitempty,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresAt must be in the future"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	link := models.ShareLink{
+		CreatedBy:    userID,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+	}
+	if req.FolderID != uuid.Nil {
+		folder, _, err := sc.PermissionRepo.AuthorizeFolder(req.FolderID, userID, repositories.ActionReshare)
+		if err != nil {
+			respondAuthzError(c, err, "Folder not found")
+			return
+		}
+		link.FolderID = &folder.ID
+	} else {
+		file, _, err := sc.PermissionRepo.AuthorizeFile(req.FileID, userID, repositories.ActionReshare)
+		if err != nil {
+			respondAuthzError(c, err, "File not found")
+			return
+		}
+		link.FileID = &file.ID
+	}
+
+	if err := sc.Repo.CreateLink(&link, req.Password); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create link"})
+		return
+	}
+	c.JSON(http.StatusCreated, formatShareLink(&link))
+}
+
+// ListLinks returns the links on a file or folder with their counters.
+func (sc *ShareLinkController) ListLinks(c *gin.Context) {
+	fileID, fileErr := uuid.Parse(c.Query("fileId"))
+	folderID, folderErr := uuid.Parse(c.Query("folderId"))
+	if (fileErr == nil) == (folderErr == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of fileId and folderId is required"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	var links []models.ShareLink
+	var err error
+	if folderErr == nil {
+		if _, _, err := sc.PermissionRepo.AuthorizeFolder(folderID, userID, repositories.ActionReshare); err != nil {
+			respondAuthzError(c, err, "Folder not found")
+			return
+		}
+		links, err = sc.Repo.LinksFor(nil, &folderID)
+	} else {
+		if _, _, err := sc.PermissionRepo.AuthorizeFile(fileID, userID, repositories.ActionReshare); err != nil {
+			respondAuthzError(c, err, "File not found")
+			return
+		}
+		links, err = sc.Repo.LinksFor(&fileID, nil)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := make([]dtos.ShareLinkResponse, 0, len(links))
+	for i := range links {
+		response = append(response, formatShareLink(&links[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeLink disables a link. Its creator and anyone who may reshare the item can revoke it.
+func (sc *ShareLinkController) RevokeLink(c *gin.Context) {
+	linkID, err := uuid.Parse(c.Param("linkId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid linkId"})
+		return
+	}
+	userID := uuid.MustParse(c.GetString("userID"))
+
+	link, err := sc.Repo.GetLink(linkID)
+	if err == nil && link.CreatedBy != userID {
+		if link.FolderID != nil {
+			_, _, err = sc.PermissionRepo.AuthorizeFolder(*link.FolderID, userID, repositories.ActionReshare)
+		} else {
+			_, _, err = sc.PermissionRepo.AuthorizeFile(*link.FileID, userID, repositories.ActionReshare)
+		}
+	}
+	if err != nil {
+		respondAuthzError(c, err, "Link not found")
+		return
+	}
+
+	if err := sc.Repo.RevokeLink(&link); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke link"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Link revoked"})
+}
+
+// GetPublicLink describes what a link points at, for the page an anonymous visitor lands on.
+func (sc *ShareLinkController) GetPublicLink(c *gin.Context) {
+	link, file, folder, ok := sc.resolvePublicLink(c)
+	if !ok {
+		return
+	}
+
+	response := dtos.PublicLinkResponse{
+		Type:             "file",
+		RequiresPassword: link.PasswordHash != nil,
+		ExpiresAt:        link.ExpiresAt,
+	}
+	if folder != nil {
+		response.Type = "folder"
+	}
+	if link.MaxDownloads != nil {
+		remaining := max(*link.MaxDownloads-link.DownloadCount, 0)
+		response.DownloadsRemaining = &remaining
+	}
+
+	if repositories.CheckLinkPassword(&link, c.GetHeader(LinkPasswordHeader)) == nil {
+		if folder != nil {
+			response.Name = folder.Name
+		} else {
+			response.Name = file.Name
+			response.Size = file.Size
+			response.MimeType = file.MimeType
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DownloadPublicLink serves the linked file, or the linked folder as a ZIP archive, and counts
+// the download. It accepts a JSON or form body so a plain HTML form can submit the password.
+func (sc *ShareLinkController) DownloadPublicLink(c *gin.Context) {
+	var req struct {
+		Password string `json:"password" form:"password"`
+	}
+	if err := c.ShouldBind(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	link, file, folder, ok := sc.resolvePublicLink(c)
+	if !ok {
+		return
+	}
+	if err := repositories.CheckLinkPassword(&link, req.Password); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err := sc.Repo.ClaimDownload(&link); err != nil {
+		respondPublicLinkError(c, err)
+		return
+	}
+
+	if folder != nil {
+		folders, files, err := sc.FolderRepo.GetFolderTree(folder.ID)
+		if err != nil {
+			sc.Repo.ReleaseDownload(&link)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		entries := folderArchiveEntries(folder.ID, folders, files, "")
+		streamZip(c, sc.Store, sanitizeArchiveName(folder.Name)+".zip", entries)
+		return
+	}
+
+	body, err := sc.Store.GetObject(c.Request.Context(), file.ObjectKey, 0, file.Size)
+	if err != nil {
+		sc.Repo.ReleaseDownload(&link)
+		log.Printf("failed to read object %s: %v", file.ObjectKey, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not read file"})
+		return
+	}
+	defer body.Close()
+
+	contentType := "application/octet-stream"
+	if file.MimeType != nil && *file.MimeType != "" {
+		contentType = *file.MimeType
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(file.Name)))
+	c.Header("Cache-Control", "no-store")
+	c.DataFromReader(http.StatusOK, file.Size, contentType, body, nil)
+}
+
+// resolvePublicLink resolves :token to a live link and the live item behind it, exactly one of
+// file and folder. Links to items that have been trashed answer 404 until the item is restored.
+func (sc *ShareLinkController) resolvePublicLink(c *gin.Context) (models.ShareLink, *models.File, *models.Folder, bool) {
+	link, err := sc.Repo.ResolveLink(c.Param("token"))
+	if err != nil {
+		respondPublicLinkError(c, err)
+		return models.ShareLink{}, nil, nil, false
+	}
+
+	db := sc.Repo.DB
+	if link.FolderID != nil {
+		var folder models.Folder
+		err = db.Where("id = ? AND is_deleted = ?", *link.FolderID, false).First(&folder).Error
+		if err != nil {
+			respondPublicLinkError(c, err)
+			return models.ShareLink{}, nil, nil, false
+		}
+		return link, nil, &folder, true
+	}
+
+	var file models.File
+	err = db.Where("id = ? AND is_deleted = ? AND upload_status = ?", *link.FileID, false, "completed").First(&file).Error
+	if err != nil {
+		respondPublicLinkError(c, err)
+		return models.ShareLink{}, nil, nil, false
+	}
+	return link, &file, nil, true
+}
+
+func respondPublicLinkError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+	case errors.Is(err, repositories.ErrLinkRevoked), errors.Is(err, repositories.ErrLinkExpired),
+		errors.Is(err, repositories.ErrLinkExhausted):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/richeek45/filedrive/storage"
+)
+
+// LocalStorageController serves the presigned URLs handed out by storage.LocalStore,
+// standing in for the S3 endpoints the browser would otherwise talk to.
+type LocalStorageController struct {
+	Store *storage.LocalStore
+}
+
+func (lc *LocalStorageController) UploadPart(c *gin.Context) {
+	query := c.Request.URL.Query()
+	if err := lc.Store.Verify(storage.LocalUploadPartPath, query); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid partNumber"})
+		return
+	}
+
+	checksum := storage.Checksum{Algorithm: query.Get("checksumAlgorithm"), Value: query.Get("checksum")}
+	etag, err := lc.Store.WritePart(query.Get("key"), query.Get("uploadId"), int32(partNumber), c.Request.Body, checksum)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoSuchUpload) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, storage.ErrChecksumMismatch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store part"})
+		return
+	}
+
+	c.Header("ETag", etag)
+	c.Status(http.StatusOK)
+}
+
+func (lc *LocalStorageController) Download(c *gin.Context) {
+	query := c.Request.URL.Query()
+	if err := lc.Store.Verify(storage.LocalDownloadPath, query); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := query.Get("key")
+	f, err := lc.Store.Open(key)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open object"})
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open object"})
+		return
+	}
+
+	if disposition := query.Get("disposition"); disposition != "" {
+		c.Header("Content-Disposition", disposition)
+	}
+	http.ServeContent(c.Writer, c.Request, path.Base(key), info.ModTime(), f)
+}
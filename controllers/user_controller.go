@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/dtos"
 	"github.com/richeek45/filedrive/models"
 	"github.com/richeek45/filedrive/repositories"
 )
@@ -67,3 +69,58 @@ func (r *UserController) GetProfile(c *gin.Context) {
 		"storageLimit": user.StorageLimit,
 	})
 }
+
+func (r *UserController) GetTrashPolicy(c *gin.Context) {
+	userID := uuid.MustParse(c.GetString("userID"))
+	r.respondTrashPolicy(c, userID)
+}
+
+func (r *UserController) SetTrashPolicy(c *gin.Context) {
+	var req struct {
+		RetentionDays int   `json:"retentionDays" binding:"required,min=1"`
+		AutoEmpty     *bool `json:"autoEmpty"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RetentionDays > repositories.MaxTrashRetentionDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("retentionDays must be at most %d", repositories.MaxTrashRetentionDays)})
+		return
+	}
+	autoEmpty := true
+	if req.AutoEmpty != nil {
+		autoEmpty = *req.AutoEmpty
+	}
+
+	userID := uuid.MustParse(c.GetString("userID"))
+	if err := r.Repo.SetTrashPolicy(userID, req.RetentionDays, autoEmpty); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save trash policy"})
+		return
+	}
+	r.respondTrashPolicy(c, userID)
+}
+
+// ResetTrashPolicy goes back to the global default
+func (r *UserController) ResetTrashPolicy(c *gin.Context) {
+	userID := uuid.MustParse(c.GetString("userID"))
+	if err := r.Repo.ResetTrashPolicy(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset trash policy"})
+		return
+	}
+	r.respondTrashPolicy(c, userID)
+}
+
+func (r *UserController) respondTrashPolicy(c *gin.Context, userID uuid.UUID) {
+	policy, err := r.Repo.GetTrashPolicy(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch trash policy"})
+		return
+	}
+	c.JSON(http.StatusOK, dtos.TrashPolicyResponse{
+		RetentionDays: policy.RetentionDays,
+		AutoEmpty:     policy.AutoEmpty,
+		IsDefault:     policy.IsDefault,
+		DefaultDays:   repositories.DefaultTrashRetentionDays(),
+	})
+}
@@ -39,6 +39,18 @@ func InitDB() *gorm.DB {
 		END $$;
 	`)
 
+	// Deduplicated files and their versions share an object key, so the old unique indexes on it have to go
+	db.Exec(`
+		DO $$ BEGIN
+			IF EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'idx_file_object_key' AND indexdef LIKE 'CREATE UNIQUE%') THEN
+				DROP INDEX idx_file_object_key;
+			END IF;
+			IF EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'idx_file_version_object_key' AND indexdef LIKE 'CREATE UNIQUE%') THEN
+				DROP INDEX idx_file_version_object_key;
+			END IF;
+		END $$;
+	`)
+
 	err = db.AutoMigrate(
 		&models.Users{},
 		&models.File{},
@@ -47,11 +59,31 @@ func InitDB() *gorm.DB {
 		&models.PendingUpload{},
 		&models.DeletedFile{},
 		&models.FailedS3Deletion{},
+		&models.FileVersion{},
+		&models.TrashRetentionPolicy{},
+		&models.Blob{},
+		&models.ShareLink{},
+		&models.PendingInvitation{},
 	)
 	if err != nil {
 		panic("failed to migrate database: " + err.Error())
 	}
 
+	// Trigram indexes back the case-insensitive substring search in SearchRepository. Without
+	// them search still works, through sequential scans
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS pg_trgm`).Error; err != nil {
+		log.Printf("Failed to enable pg_trgm, search will not be indexed: %v", err)
+	} else {
+		for _, stmt := range []string{
+			`CREATE INDEX IF NOT EXISTS idx_file_name_trgm ON file USING gin (lower(name) gin_trgm_ops)`,
+			`CREATE INDEX IF NOT EXISTS idx_folder_name_trgm ON folder USING gin (lower(name) gin_trgm_ops)`,
+		} {
+			if err := db.Exec(stmt).Error; err != nil {
+				log.Printf("Failed to create search index: %v", err)
+			}
+		}
+	}
+
 	if err := db.Use(otelgorm.NewPlugin()); err != nil {
 		log.Printf("Failed to instrument GORM: %v", err)
 	}
@@ -17,6 +17,20 @@ type FileResponse struct {
 	Permission   string    `json:"permission"`
 }
 
+type FileListResponse struct {
+	Items []FileResponse `json:"items"`
+	// Pass back as ?cursor= for the next page; null on the last page
+	NextCursor *string `json:"nextCursor"`
+}
+
+type FileVersionResponse struct {
+	VersionNumber int       `json:"versionNumber"`
+	Size          int64     `json:"size"`
+	MimeType      *string   `json:"mimeType"`
+	CreatedAt     time.Time `json:"createdAt"`
+	IsCurrent     bool      `json:"isCurrent"`
+}
+
 type FolderResponse struct {
 	ID        uuid.UUID `json:"id"`
 	Name      string    `json:"name"`
@@ -25,8 +39,151 @@ type FolderResponse struct {
 	IsDeleted bool      `json:"isDeleted"`
 }
 
+type FolderListResponse struct {
+	Items      []FolderResponse `json:"items"`
+	NextCursor *string          `json:"nextCursor"`
+}
+
+type FolderContentItem struct {
+	// "folder" or "file"
+	Type       string     `json:"type"`
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	ParentID   *uuid.UUID `json:"parentId"`
+	Size       int64      `json:"size"`
+	MimeType   *string    `json:"mimeType"`
+	Permission string     `json:"permission"`
+	// Subfolders plus files; folders only
+	ChildCount   *int64    `json:"childCount,omitempty"`
+	UploadStatus string    `json:"uploadStatus,omitempty"`
+	IsDeleted    bool      `json:"isDeleted"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+type FolderContentsResponse struct {
+	Items      []FolderContentItem `json:"items"`
+	NextCursor *string             `json:"nextCursor"`
+}
+
+type BreadcrumbResponse struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
 type SharedFileResponse struct {
 	FileResponse
 	Permission string `json:"permission"`
 	SharedBy   string `json:"sharedBy"`
 }
+
+type SharedFolderResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	ParentID   *uuid.UUID `json:"parentId"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	IsDeleted  bool       `json:"isDeleted"`
+	Permission string     `json:"permission"`
+	SharedBy   string     `json:"sharedBy"`
+}
+
+type GrantResponse struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"userId"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	GrantedBy  uuid.UUID `json:"grantedBy"`
+	// Name and email of whoever made the grant
+	GrantedByName  string `json:"grantedByName"`
+	GrantedByEmail string `json:"grantedByEmail"`
+	// The folder above the item the grant was made on; null for grants on the item itself
+	InheritedFrom *uuid.UUID `json:"inheritedFrom"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+type ShareOwnerResponse struct {
+	ID    uuid.UUID `json:"id"`
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+}
+
+type SharingResponse struct {
+	Owner  ShareOwnerResponse `json:"owner"`
+	Grants []GrantResponse    `json:"grants"`
+}
+
+type ShareLinkResponse struct {
+	ID    uuid.UUID `json:"id"`
+	Token string    `json:"token"`
+	// Frontend page that resolves the token
+	URL            string     `json:"url"`
+	FileID         *uuid.UUID `json:"fileId"`
+	FolderID       *uuid.UUID `json:"folderId"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+	HasPassword    bool       `json:"hasPassword"`
+	MaxDownloads   *int       `json:"maxDownloads"`
+	AccessCount    int        `json:"accessCount"`
+	DownloadCount  int        `json:"downloadCount"`
+	LastAccessedAt *time.Time `json:"lastAccessedAt"`
+	RevokedAt      *time.Time `json:"revokedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// PublicLinkResponse is what an anonymous visitor learns about a link. Details of the item
+// are withheld until the password is given, on links that have one.
+type PublicLinkResponse struct {
+	// "folder" or "file"
+	Type               string     `json:"type"`
+	Name               string     `json:"name,omitempty"`
+	Size               int64      `json:"size,omitempty"`
+	MimeType           *string    `json:"mimeType,omitempty"`
+	RequiresPassword   bool       `json:"requiresPassword"`
+	ExpiresAt          *time.Time `json:"expiresAt"`
+	DownloadsRemaining *int       `json:"downloadsRemaining"`
+}
+
+type SearchResultResponse struct {
+	Type       string     `json:"type"`
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	FolderID   *uuid.UUID `json:"folderId"`
+	Path       string     `json:"path"`
+	Size       int64      `json:"size"`
+	MimeType   *string    `json:"mimeType"`
+	Permission string     `json:"permission"`
+	IsDeleted  bool       `json:"isDeleted"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+type SearchResponse struct {
+	Items    []SearchResultResponse `json:"items"`
+	Page     int                    `json:"page"`
+	PageSize int                    `json:"pageSize"`
+	HasMore  bool                   `json:"hasMore"`
+}
+
+type EmptyTrashResponse struct {
+	FilesDeleted   int64 `json:"filesDeleted"`
+	FoldersDeleted int64 `json:"foldersDeleted"`
+	BytesReclaimed int64 `json:"bytesReclaimed"`
+}
+
+type TrashPolicyResponse struct {
+	RetentionDays int  `json:"retentionDays"`
+	AutoEmpty     bool `json:"autoEmpty"`
+	IsDefault     bool `json:"isDefault"`
+	DefaultDays   int  `json:"defaultDays"`
+}
+
+type PresignedPartResponse struct {
+	PartNumber int32             `json:"partNumber"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+type PresignedPartsResponse struct {
+	Parts     []PresignedPartResponse `json:"parts"`
+	ExpiresAt time.Time               `json:"expiresAt"`
+}
@@ -0,0 +1,2330 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+	"github.com/richeek45/filedrive/dtos"
+	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/repositories"
+	"github.com/richeek45/filedrive/storage"
+	"github.com/richeek45/filedrive/worker"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+const testBucket = "filedrive-test"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("JWT_SECRET", "integration-test-secret")
+	os.Setenv("FRONTEND_URL", "http://localhost:5173")
+
+	// The models rely on a few Postgres functions; provide SQLite stand-ins for them
+	sql.Register("sqlite3_filedrive", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("gen_random_uuid", func() string { return uuid.NewString() }, false); err != nil {
+				return err
+			}
+			if err := conn.RegisterFunc("now", func() string {
+				return time.Now().Format(sqlite3.SQLiteTimestampFormats[0])
+			}, false); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("pg_try_advisory_xact_lock", func(int64) bool { return true }, false)
+		},
+	})
+
+	os.Exit(m.Run())
+}
+
+// sqliteDialector wraps the SQLite dialector so Postgres-style function defaults
+// (gen_random_uuid(), now()) are emitted in the parenthesised form SQLite accepts.
+type sqliteDialector struct {
+	gorm.Dialector
+}
+
+func (d sqliteDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return sqliteMigrator{d.Dialector.Migrator(db)}
+}
+
+type sqliteMigrator struct {
+	gorm.Migrator
+}
+
+func (m sqliteMigrator) FullDataTypeOf(field *schema.Field) clause.Expr {
+	expr := m.Migrator.FullDataTypeOf(field)
+	if field.DefaultValueInterface == nil && strings.HasSuffix(field.DefaultValue, ")") {
+		expr.SQL = strings.Replace(expr.SQL, "DEFAULT "+field.DefaultValue, "DEFAULT ("+field.DefaultValue+")", 1)
+	}
+	return expr
+}
+
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+	db     *gorm.DB
+	store  *storage.MemoryStore
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "filedrive.db") + "?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on"
+	db, err := gorm.Open(sqliteDialector{sqlite.New(sqlite.Config{DriverName: "sqlite3_filedrive", DSN: dsn})}, &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+
+	err = db.AutoMigrate(
+		&models.Users{},
+		&models.File{},
+		&models.Folder{},
+		&models.ResourcePermission{},
+		&models.PendingUpload{},
+		&models.DeletedFile{},
+		&models.FailedS3Deletion{},
+		&models.FileVersion{},
+		&models.TrashRetentionPolicy{},
+		&models.Blob{},
+		&models.ShareLink{},
+		&models.PendingInvitation{},
+	)
+	if err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	store := storage.NewMemoryStore(testBucket)
+	return &testServer{
+		t:      t,
+		router: setupRouter(db, store, "test"),
+		db:     db,
+		store:  store,
+	}
+}
+
+func (s *testServer) createUser(firstName string) (models.Users, string) {
+	s.t.Helper()
+
+	user := models.Users{
+		FirstName: firstName,
+		LastName:  "Tester",
+		Email:     strings.ToLower(firstName) + "@example.com",
+		GoogleID:  "google-" + strings.ToLower(firstName),
+	}
+	if err := s.db.Create(&user).Error; err != nil {
+		s.t.Fatalf("failed to create user: %v", err)
+	}
+	if err := s.db.First(&user, "id = ?", user.ID).Error; err != nil {
+		s.t.Fatalf("failed to reload user: %v", err)
+	}
+
+	claims := &models.Claims{
+		UserID: user.ID.String(),
+		Email:  user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Subject:   user.ID.String(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		s.t.Fatalf("failed to sign token: %v", err)
+	}
+	return user, token
+}
+
+func (s *testServer) do(method string, path string, token string, body any) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("failed to encode body: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func (s *testServer) doWithHeaders(method string, path string, token string, headers map[string]string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func (s *testServer) expect(w *httptest.ResponseRecorder, status int, out any) {
+	s.t.Helper()
+
+	if w.Code != status {
+		s.t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("failed to decode response %q: %v", w.Body.String(), err)
+		}
+	}
+}
+
+// upload runs the initiate → presign-part → PUT → complete flow and returns the new file.
+func (s *testServer) upload(token string, name string, parentID *uuid.UUID, parts ...string) models.File {
+	s.t.Helper()
+
+	w, key := s.uploadWith(token, name, parentID, nil, nil, parts...)
+	s.expect(w, http.StatusOK, nil)
+
+	var file models.File
+	if err := s.db.Where("object_key = ?", key).First(&file).Error; err != nil {
+		s.t.Fatalf("uploaded file not found: %v", err)
+	}
+	return file
+}
+
+// uploadWith is upload with extra fields for the initiate and complete calls; it returns the
+// complete response and the key the upload was initiated with.
+func (s *testServer) uploadWith(token string, name string, parentID *uuid.UUID, initiate gin.H, complete gin.H, parts ...string) (*httptest.ResponseRecorder, string) {
+	s.t.Helper()
+
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+
+	var initiated struct {
+		SessionID string `json:"sessionId"`
+		UploadID  string `json:"uploadId"`
+		Key       string `json:"key"`
+	}
+	initiateBody := gin.H{
+		"fileName":    name,
+		"contentType": "text/plain",
+		"size":        size,
+		"parentId":    parentID,
+		"totalChunks": len(parts),
+	}
+	for k, v := range initiate {
+		initiateBody[k] = v
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", token, initiateBody), http.StatusOK, &initiated)
+
+	var completed []storage.CompletedPart
+	for i, data := range parts {
+		partNumber := int32(i + 1)
+
+		var presigned struct {
+			URL string `json:"url"`
+		}
+		s.expect(s.do(http.MethodPost, "/api/files/uploads/presign-part", token, gin.H{
+			"sessionId":  initiated.SessionID,
+			"partNumber": partNumber,
+		}), http.StatusOK, &presigned)
+		if presigned.URL == "" {
+			s.t.Fatal("presign-part returned an empty url")
+		}
+
+		etag, err := s.store.UploadPart(initiated.Key, initiated.UploadID, partNumber, []byte(data))
+		if err != nil {
+			s.t.Fatalf("failed to upload part %d: %v", partNumber, err)
+		}
+		completed = append(completed, storage.CompletedPart{PartNumber: partNumber, ETag: strings.Trim(etag, `"`)})
+	}
+
+	body := gin.H{
+		"sessionId": initiated.SessionID,
+		"uploadId":  initiated.UploadID,
+		"key":       initiated.Key,
+		"parentId":  parentID,
+		"parts":     completed,
+	}
+	for k, v := range complete {
+		body[k] = v
+	}
+	return s.do(http.MethodPost, "/api/files/uploads/complete", token, body), initiated.Key
+}
+
+func (s *testServer) storageUsed(userID uuid.UUID) int64 {
+	s.t.Helper()
+
+	var user models.Users
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		s.t.Fatalf("failed to load user: %v", err)
+	}
+	return user.StorageUsed
+}
+
+type fileListItem struct {
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	UploadStatus string    `json:"uploadStatus"`
+}
+
+func (s *testServer) listFiles(token string, query string) []fileListItem {
+	s.t.Helper()
+
+	var page struct {
+		Items []fileListItem `json:"items"`
+	}
+	s.expect(s.do(http.MethodGet, "/api/files/?"+query, token, nil), http.StatusOK, &page)
+	return page.Items
+}
+
+func (s *testServer) listFolders(token string, query string) []dtos.FolderResponse {
+	s.t.Helper()
+
+	var page dtos.FolderListResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/?"+query, token, nil), http.StatusOK, &page)
+	return page.Items
+}
+
+func (s *testServer) createFolder(token string, name string, parentID *uuid.UUID) models.Folder {
+	s.t.Helper()
+
+	var folder models.Folder
+	s.expect(s.do(http.MethodPost, "/api/folders/", token, gin.H{"name": name, "parentId": parentID}), http.StatusCreated, &folder)
+	return folder
+}
+
+// readZip returns the archive's entries keyed by path; directories map to "/".
+func readZip(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("response is not a valid zip: %v", err)
+	}
+
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			entries[f.Name] = "/"
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open zip entry %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("failed to read zip entry %s: %v", f.Name, err)
+		}
+		entries[f.Name] = string(data)
+	}
+	return entries
+}
+
+func containsFile(files []fileListItem, id uuid.UUID) bool {
+	for _, f := range files {
+		if f.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUploadFlow(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.createUser("Alice")
+
+	var folder models.Folder
+	s.expect(s.do(http.MethodPost, "/api/folders/", token, gin.H{"name": "docs"}), http.StatusCreated, &folder)
+
+	file := s.upload(token, "notes.txt", &folder.ID, "hello ", "world")
+
+	if file.UploadStatus != "completed" {
+		t.Fatalf("expected completed upload, got %q", file.UploadStatus)
+	}
+	if file.FolderID == nil || *file.FolderID != folder.ID {
+		t.Fatalf("expected file in folder %s, got %v", folder.ID, file.FolderID)
+	}
+	if data, ok := s.store.Object(file.ObjectKey); !ok || string(data) != "hello world" {
+		t.Fatalf("unexpected stored object %q (found=%v)", data, ok)
+	}
+	if used := s.storageUsed(user.ID); used != file.Size {
+		t.Fatalf("expected storage used %d, got %d", file.Size, used)
+	}
+
+	files := s.listFiles(token, "parentId="+folder.ID.String())
+	if len(files) != 1 || files[0].ID != file.ID || files[0].UploadStatus != "completed" {
+		t.Fatalf("unexpected folder listing: %+v", files)
+	}
+
+	var pending int64
+	s.db.Model(&models.PendingUpload{}).Count(&pending)
+	if pending != 0 {
+		t.Fatalf("expected pending uploads to be cleared, found %d", pending)
+	}
+
+	var download struct {
+		URL string `json:"url"`
+	}
+	s.expect(s.do(http.MethodGet, "/api/files/"+file.ID.String()+"/download", token, nil), http.StatusOK, &download)
+	if !strings.Contains(download.URL, "notes.txt") {
+		t.Fatalf("download url does not reference the file: %s", download.URL)
+	}
+}
+
+func TestUploadRequiresAuth(t *testing.T) {
+	s := newTestServer(t)
+
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", "", gin.H{"fileName": "x"}), http.StatusUnauthorized, nil)
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.createUser("Bob")
+
+	file := s.upload(token, "report.txt", nil, "quarterly numbers")
+
+	s.expect(s.do(http.MethodPatch, "/api/files/"+file.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+
+	if containsFile(s.listFiles(token, ""), file.ID) {
+		t.Fatal("trashed file is still listed in the root folder")
+	}
+	if !containsFile(s.listFiles(token, "isTrash=true"), file.ID) {
+		t.Fatal("trashed file is missing from the trash listing")
+	}
+
+	s.expect(s.do(http.MethodPost, "/api/files/restore-file", token, gin.H{"fileId": file.ID}), http.StatusOK, nil)
+
+	if !containsFile(s.listFiles(token, ""), file.ID) {
+		t.Fatal("restored file is not listed in the root folder")
+	}
+	if containsFile(s.listFiles(token, "isTrash=true"), file.ID) {
+		t.Fatal("restored file is still listed in the trash")
+	}
+}
+
+func TestPermanentDeleteAndRestoreDeletedFiles(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.createUser("Carol")
+
+	file := s.upload(token, "photo.txt", nil, "pixels")
+	originalKey := file.ObjectKey
+
+	// The first trash call soft-deletes, the second one removes it permanently
+	s.expect(s.do(http.MethodPatch, "/api/files/"+file.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+file.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+
+	if _, ok := s.store.Object(originalKey); ok {
+		t.Fatal("permanently deleted object is still at its original key")
+	}
+	if _, ok := s.store.Object("delete/" + originalKey); !ok {
+		t.Fatal("permanently deleted object was not moved under delete/")
+	}
+	if err := s.db.Unscoped().First(&models.File{}, "id = ?", file.ID).Error; err == nil {
+		t.Fatal("permanently deleted file row still exists")
+	}
+	if used := s.storageUsed(user.ID); used != 0 {
+		t.Fatalf("expected storage used to drop to 0, got %d", used)
+	}
+
+	s.expect(s.do(http.MethodPost, "/api/files/restore-deleted-files", token, nil), http.StatusOK, nil)
+
+	if data, ok := s.store.Object(originalKey); !ok || string(data) != "pixels" {
+		t.Fatalf("object was not restored to its original key (found=%v)", ok)
+	}
+	if _, ok := s.store.Object("delete/" + originalKey); ok {
+		t.Fatal("restored object is still under delete/")
+	}
+	if !containsFile(s.listFiles(token, ""), file.ID) {
+		t.Fatal("restored file is not listed")
+	}
+	if used := s.storageUsed(user.ID); used != file.Size {
+		t.Fatalf("expected storage used %d after restore, got %d", file.Size, used)
+	}
+}
+
+func TestPurgeExpiredDeletedFiles(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.createUser("Dave")
+
+	expired := s.upload(token, "old.txt", nil, "stale")
+	recent := s.upload(token, "new.txt", nil, "fresh")
+
+	s.expect(s.do(http.MethodPatch, "/api/files/"+expired.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+recent.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+
+	err := s.db.Unscoped().Model(&models.File{}).Where("id = ?", expired.ID).
+		Update("deleted_at", time.Now().AddDate(0, 0, -31)).Error
+	if err != nil {
+		t.Fatalf("failed to backdate deleted_at: %v", err)
+	}
+
+	worker.PurgeExpiredDeletedFiles(s.db, s.store, testBucket)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := s.db.Unscoped().First(&models.File{}, "id = ?", expired.ID).Error
+		if err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired file was not purged")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if _, ok := s.store.Object(expired.ObjectKey); ok {
+		t.Fatal("purged object still exists in storage")
+	}
+	if _, ok := s.store.Object(recent.ObjectKey); !ok {
+		t.Fatal("recently trashed object was purged")
+	}
+	if err := s.db.Unscoped().First(&models.File{}, "id = ?", recent.ID).Error; err != nil {
+		t.Fatalf("recently trashed file row was purged: %v", err)
+	}
+	if used := s.storageUsed(user.ID); used != recent.Size {
+		t.Fatalf("expected storage used %d after purge, got %d", recent.Size, used)
+	}
+}
+
+func TestTrashRetentionPolicy(t *testing.T) {
+	s := newTestServer(t)
+	defaultUser, defaultToken := s.createUser("Erin")
+	shortUser, shortToken := s.createUser("Frank")
+	_, keepToken := s.createUser("Gina")
+
+	var policy dtos.TrashPolicyResponse
+	s.expect(s.do(http.MethodGet, "/api/users/trash-policy", defaultToken, nil), http.StatusOK, &policy)
+	if !policy.IsDefault || policy.RetentionDays != 30 || !policy.AutoEmpty {
+		t.Fatalf("unexpected default policy: %+v", policy)
+	}
+
+	s.expect(s.do(http.MethodPut, "/api/users/trash-policy", shortToken, gin.H{"retentionDays": 0}), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodPut, "/api/users/trash-policy", shortToken, gin.H{"retentionDays": 366}), http.StatusBadRequest, nil)
+
+	s.expect(s.do(http.MethodPut, "/api/users/trash-policy", shortToken, gin.H{"retentionDays": 7}), http.StatusOK, &policy)
+	if policy.IsDefault || policy.RetentionDays != 7 || !policy.AutoEmpty {
+		t.Fatalf("unexpected override policy: %+v", policy)
+	}
+	s.expect(s.do(http.MethodPut, "/api/users/trash-policy", keepToken, gin.H{"retentionDays": 7, "autoEmpty": false}), http.StatusOK, &policy)
+	if policy.AutoEmpty {
+		t.Fatal("autoEmpty was not turned off")
+	}
+
+	// Ten days in the trash is past Frank's window only
+	trashed := map[string]models.File{}
+	for name, token := range map[string]string{"erin": defaultToken, "frank": shortToken, "gina": keepToken} {
+		file := s.upload(token, name+".txt", nil, "trash me")
+		s.expect(s.do(http.MethodPatch, "/api/files/"+file.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+		err := s.db.Unscoped().Model(&models.File{}).Where("id = ?", file.ID).
+			Update("deleted_at", time.Now().AddDate(0, 0, -10)).Error
+		if err != nil {
+			t.Fatalf("failed to backdate deleted_at: %v", err)
+		}
+		trashed[name] = file
+	}
+
+	worker.PurgeExpiredDeletedFiles(s.db, s.store, testBucket)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.db.Unscoped().First(&models.File{}, "id = ?", trashed["frank"].ID).Error == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("file past the user's retention was not purged")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for _, name := range []string{"erin", "gina"} {
+		if err := s.db.Unscoped().First(&models.File{}, "id = ?", trashed[name].ID).Error; err != nil {
+			t.Fatalf("%s's trashed file was purged: %v", name, err)
+		}
+	}
+	if used := s.storageUsed(shortUser.ID); used != 0 {
+		t.Fatalf("expected purge to release storage, got %d used", used)
+	}
+
+	s.expect(s.do(http.MethodDelete, "/api/users/trash-policy", shortToken, nil), http.StatusOK, &policy)
+	if !policy.IsDefault || policy.RetentionDays != 30 {
+		t.Fatalf("policy was not reset: %+v", policy)
+	}
+
+	folder := s.createFolder(defaultToken, "old stuff", nil)
+	nested := s.upload(defaultToken, "nested.txt", &folder.ID, "in a folder")
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+folder.ID.String()+"/trash", defaultToken, nil), http.StatusOK, nil)
+
+	var emptied dtos.EmptyTrashResponse
+	s.expect(s.do(http.MethodPost, "/api/files/trash/empty", defaultToken, nil), http.StatusOK, &emptied)
+	if emptied.FilesDeleted != 2 || emptied.FoldersDeleted != 1 {
+		t.Fatalf("unexpected empty trash counts: %+v", emptied)
+	}
+	if emptied.BytesReclaimed != trashed["erin"].Size+nested.Size {
+		t.Fatalf("expected %d bytes reclaimed, got %d", trashed["erin"].Size+nested.Size, emptied.BytesReclaimed)
+	}
+	if used := s.storageUsed(defaultUser.ID); used != 0 {
+		t.Fatalf("expected storage used 0 after emptying trash, got %d", used)
+	}
+	if files := s.listFiles(defaultToken, "isTrash=true"); len(files) != 0 {
+		t.Fatalf("trash still lists %d files", len(files))
+	}
+	if err := s.db.First(&models.Folder{}, "id = ?", folder.ID).Error; err == nil {
+		t.Fatal("trashed folder still exists after emptying trash")
+	}
+}
+
+func TestShareFile(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.createUser("Erin")
+	grantee, granteeToken := s.createUser("Frank")
+	_, strangerToken := s.createUser("Grace")
+
+	file := s.upload(ownerToken, "plan.txt", nil, "secret plan")
+
+	s.expect(s.do(http.MethodGet, "/api/files/"+file.ID.String()+"/download", granteeToken, nil), http.StatusNotFound, nil)
+
+	s.expect(s.do(http.MethodPost, "/api/files/share", ownerToken, gin.H{
+		"fileId":     file.ID,
+		"emails":     []string{grantee.Email},
+		"permission": models.PermissionViewer,
+	}), http.StatusOK, nil)
+
+	// Sharing again must update the existing grant rather than fail on the unique index
+	s.expect(s.do(http.MethodPost, "/api/files/share", ownerToken, gin.H{
+		"fileId":     file.ID,
+		"emails":     []string{grantee.Email},
+		"permission": models.PermissionEditor,
+	}), http.StatusOK, nil)
+
+	var shared []struct {
+		ID         uuid.UUID `json:"id"`
+		Permission string    `json:"permission"`
+		SharedBy   string    `json:"sharedBy"`
+	}
+	s.expect(s.do(http.MethodGet, "/api/files/shared-by", granteeToken, nil), http.StatusOK, &shared)
+	if len(shared) != 1 || shared[0].ID != file.ID {
+		t.Fatalf("unexpected shared listing: %+v", shared)
+	}
+	if shared[0].Permission != string(models.PermissionEditor) || shared[0].SharedBy != owner.FirstName {
+		t.Fatalf("unexpected share details: %+v", shared[0])
+	}
+
+	s.expect(s.do(http.MethodGet, "/api/files/"+file.ID.String()+"/download", granteeToken, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodGet, "/api/files/"+file.ID.String()+"/download", strangerToken, nil), http.StatusNotFound, nil)
+
+	// Unknown emails are invited rather than rejected
+	var result struct {
+		SharedWithCount int `json:"sharedWithCount"`
+		InvitedCount    int `json:"invitedCount"`
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/share", ownerToken, gin.H{
+		"fileId":     file.ID,
+		"emails":     []string{"nobody@example.com"},
+		"permission": models.PermissionViewer,
+	}), http.StatusOK, &result)
+	if result.SharedWithCount != 0 || result.InvitedCount != 1 {
+		t.Fatalf("unexpected share result: %+v", result)
+	}
+}
+
+func TestStreamFileContent(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.createUser("Heidi")
+	_, strangerToken := s.createUser("Ivan")
+
+	file := s.upload(token, "clip.txt", nil, "0123456789", "abcdef")
+	path := "/api/files/" + file.ID.String() + "/content"
+
+	w := s.doWithHeaders(http.MethodGet, path, token, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789abcdef" {
+		t.Fatalf("unexpected full response %d: %q", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("missing ETag or Accept-Ranges headers: %v", w.Header())
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "inline; filename*=UTF-8''clip.txt") {
+		t.Fatalf("unexpected Content-Disposition: %s", w.Header().Get("Content-Disposition"))
+	}
+
+	w = s.doWithHeaders(http.MethodGet, path, token, map[string]string{"Range": "bytes=8-11"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "89ab" {
+		t.Fatalf("unexpected range response %d: %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 8-11/16" {
+		t.Fatalf("unexpected Content-Range: %s", got)
+	}
+
+	w = s.doWithHeaders(http.MethodGet, path, token, map[string]string{"Range": "bytes=-3"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "def" {
+		t.Fatalf("unexpected suffix range response %d: %q", w.Code, w.Body.String())
+	}
+
+	w = s.doWithHeaders(http.MethodGet, path, token, map[string]string{"Range": "bytes=16-"})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */16" {
+		t.Fatalf("expected 416 for an out of bounds range, got %d %v", w.Code, w.Header())
+	}
+
+	w = s.doWithHeaders(http.MethodGet, path, token, map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected 304 for a matching ETag, got %d", w.Code)
+	}
+
+	w = s.doWithHeaders(http.MethodGet, path, token, map[string]string{"Range": "bytes=0-3", "If-Range": `"stale"`})
+	if w.Code != http.StatusOK || w.Body.Len() != 16 {
+		t.Fatalf("expected the full object for a stale If-Range, got %d", w.Code)
+	}
+
+	w = s.doWithHeaders(http.MethodGet, path+"?download=true", token, nil)
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment;") {
+		t.Fatalf("expected an attachment disposition, got %s", w.Header().Get("Content-Disposition"))
+	}
+
+	s.expect(s.doWithHeaders(http.MethodGet, path, strangerToken, nil), http.StatusNotFound, nil)
+}
+
+func TestZeroByteFiles(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.createUser("Nora")
+
+	// Uploads need at least one byte, but copies and older rows can be empty
+	key := "empty-" + uuid.NewString()
+	uploadID, err := s.store.CreateMultipartUpload(context.Background(), key, "text/plain", "")
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	etag, err := s.store.UploadPart(key, uploadID, 1, nil)
+	if err != nil {
+		t.Fatalf("failed to upload empty part: %v", err)
+	}
+	if _, err := s.store.CompleteMultipartUpload(context.Background(), key, uploadID, []storage.CompletedPart{{PartNumber: 1, ETag: etag}}); err != nil {
+		t.Fatalf("failed to complete upload: %v", err)
+	}
+	file := models.File{
+		Name:         "empty.txt",
+		OwnerID:      user.ID,
+		BucketName:   testBucket,
+		ObjectKey:    key,
+		UploadStatus: "completed",
+	}
+	if err := s.db.Create(&file).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	w := s.doWithHeaders(http.MethodGet, "/api/files/"+file.ID.String()+"/content", token, nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("empty file served %d: %q", w.Code, w.Body.String())
+	}
+
+	var link dtos.ShareLinkResponse
+	s.expect(s.do(http.MethodPost, "/api/links", token, gin.H{"fileId": file.ID}), http.StatusCreated, &link)
+	w = s.do(http.MethodPost, "/api/public/links/"+link.Token+"/download", "", nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("empty file link served %d: %q", w.Code, w.Body.String())
+	}
+
+	// S3 is not asked for an empty range, which it would reject as bytes=0--1
+	var ranges []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Write([]byte("hello"))
+	}))
+	defer backend.Close()
+	s3Store := storage.NewS3Store(s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(backend.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	}), testBucket)
+
+	body, err := s3Store.GetObject(context.Background(), key, 0, 0)
+	if err != nil {
+		t.Fatalf("empty read failed: %v", err)
+	}
+	if data, _ := io.ReadAll(body); len(data) != 0 || len(ranges) != 0 {
+		t.Fatalf("empty read returned %q after %d requests", data, len(ranges))
+	}
+	body, err = s3Store.GetObject(context.Background(), key, 0, 5)
+	if err != nil {
+		t.Fatalf("ranged read failed: %v", err)
+	}
+	body.Close()
+	if len(ranges) != 1 || ranges[0] != "bytes=0-4" {
+		t.Fatalf("unexpected ranges sent to S3: %v", ranges)
+	}
+}
+
+func TestDownloadFolderAsZip(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.createUser("Judy")
+	_, strangerToken := s.createUser("Mallory")
+
+	root := s.createFolder(token, "project", nil)
+	sub := s.createFolder(token, "src", &root.ID)
+	s.createFolder(token, "empty", &root.ID)
+
+	s.upload(token, "readme.md", &root.ID, "# project")
+	s.upload(token, "main.go", &sub.ID, "package main")
+	// Re-uploading the name would add a version, so rename a sibling onto it instead
+	dup := s.upload(token, "dup.go", &sub.ID, "package dup")
+	s.expect(s.do(http.MethodPatch, "/api/files/"+dup.ID.String()+"/rename", token, gin.H{"name": "main.go"}), http.StatusOK, nil)
+	trashed := s.upload(token, "old.txt", &root.ID, "gone")
+	s.expect(s.do(http.MethodPatch, "/api/files/"+trashed.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+
+	w := s.do(http.MethodGet, "/api/folders/"+root.ID.String()+"/download", token, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+
+	entries := readZip(t, w.Body.Bytes())
+	if entries["project/readme.md"] != "# project" || entries["project/empty/"] != "/" {
+		t.Fatalf("unexpected archive contents: %v", entries)
+	}
+	mains := []string{entries["project/src/main.go"], entries["project/src/main (1).go"]}
+	if !(mains[0] == "package main" && mains[1] == "package dup") && !(mains[0] == "package dup" && mains[1] == "package main") {
+		t.Fatalf("duplicate file names were not preserved: %v", entries)
+	}
+	if _, ok := entries["project/old.txt"]; ok {
+		t.Fatal("trashed file was included in the archive")
+	}
+
+	s.expect(s.do(http.MethodGet, "/api/folders/"+root.ID.String()+"/download", strangerToken, nil), http.StatusNotFound, nil)
+}
+
+func TestDownloadSelectionAsZip(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.createUser("Niaj")
+	_, token := s.createUser("Olivia")
+
+	photos := s.createFolder(token, "photos", nil)
+	cat := s.upload(token, "cat.jpg", &photos.ID, "meow")
+	note := s.upload(token, "note.txt", nil, "remember")
+	shared := s.upload(ownerToken, "shared.txt", nil, "from niaj")
+	private := s.upload(ownerToken, "private.txt", nil, "keep out")
+
+	s.expect(s.do(http.MethodPost, "/api/files/share", ownerToken, gin.H{
+		"fileId":     shared.ID,
+		"emails":     []string{"olivia@example.com"},
+		"permission": models.PermissionViewer,
+	}), http.StatusOK, nil)
+
+	w := s.do(http.MethodPost, "/api/files/download-archive", token, gin.H{
+		"fileIds":   []uuid.UUID{note.ID, shared.ID},
+		"folderIds": []uuid.UUID{photos.ID},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	entries := readZip(t, w.Body.Bytes())
+	if entries["note.txt"] != "remember" || entries["shared.txt"] != "from niaj" || entries["photos/cat.jpg"] != "meow" {
+		t.Fatalf("unexpected archive contents: %v", entries)
+	}
+
+	// Repeated IDs are archived once
+	w = s.do(http.MethodPost, "/api/files/download-archive", token, gin.H{
+		"fileIds":   []uuid.UUID{note.ID, note.ID},
+		"folderIds": []uuid.UUID{photos.ID, photos.ID},
+	})
+	s.expect(w, http.StatusOK, nil)
+	entries = readZip(t, w.Body.Bytes())
+	if len(entries) != 3 || entries["note.txt"] != "remember" || entries["photos/cat.jpg"] != "meow" {
+		t.Fatalf("duplicate selection produced %v", entries)
+	}
+
+	// Items inside a selected folder are archived and counted once, as part of it
+	sub := s.createFolder(token, "2024", &photos.ID)
+	dog := s.upload(token, "dog.jpg", &sub.ID, "woof")
+	s.db.Model(&models.Users{}).Where("email = ?", "olivia@example.com").Update("storage_limit", 8)
+	w = s.do(http.MethodPost, "/api/files/download-archive", token, gin.H{
+		"fileIds":   []uuid.UUID{dog.ID, cat.ID},
+		"folderIds": []uuid.UUID{sub.ID, photos.ID},
+	})
+	s.expect(w, http.StatusOK, nil)
+	entries = readZip(t, w.Body.Bytes())
+	if len(entries) != 4 || entries["photos/cat.jpg"] != "meow" || entries["photos/2024/dog.jpg"] != "woof" {
+		t.Fatalf("nested selection produced %v", entries)
+	}
+
+	s.expect(s.do(http.MethodPost, "/api/files/download-archive", token, gin.H{
+		"fileIds": []uuid.UUID{note.ID, private.ID},
+	}), http.StatusNotFound, nil)
+
+	s.expect(s.do(http.MethodPost, "/api/files/download-archive", token, gin.H{}), http.StatusBadRequest, nil)
+
+	s.db.Model(&models.Users{}).Where("email = ?", "olivia@example.com").Update("storage_limit", 5)
+	s.expect(s.do(http.MethodPost, "/api/files/download-archive", token, gin.H{
+		"fileIds": []uuid.UUID{note.ID},
+	}), http.StatusRequestEntityTooLarge, nil)
+}
+
+func TestFolderTrashRestoreAndDelete(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.createUser("Olivia")
+
+	root := s.createFolder(token, "photos", nil)
+	sub := s.createFolder(token, "2024", &root.ID)
+	top := s.upload(token, "cover.txt", &root.ID, "cover")
+	nested := s.upload(token, "beach.txt", &sub.ID, "sand")
+	earlier := s.upload(token, "blurry.txt", &sub.ID, "blur")
+
+	// Trashed on its own before the folder, so restoring the folder must leave it in the trash
+	s.expect(s.do(http.MethodPatch, "/api/files/"+earlier.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+root.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+
+	folders := s.listFolders(token, "isTrash=false")
+	if len(folders) != 0 {
+		t.Fatalf("trashed folder is still listed: %v", folders)
+	}
+	folders = s.listFolders(token, "isTrash=true")
+	if len(folders) != 1 || folders[0].ID != root.ID {
+		t.Fatalf("expected only the trashed top folder in the trash, got %v", folders)
+	}
+	if containsFile(s.listFiles(token, "isTrash=true"), nested.ID) {
+		t.Fatal("file trashed with its folder is listed at the trash root")
+	}
+	if !containsFile(s.listFiles(token, "isTrash=true&parentId="+sub.ID.String()), nested.ID) {
+		t.Fatal("file trashed with its folder is missing from the trashed folder")
+	}
+
+	s.expect(s.do(http.MethodPost, "/api/folders/restore-folder", token, gin.H{"folderId": root.ID}), http.StatusOK, nil)
+
+	if !containsFile(s.listFiles(token, "parentId="+sub.ID.String()), nested.ID) {
+		t.Fatal("nested file was not restored")
+	}
+	if containsFile(s.listFiles(token, "parentId="+sub.ID.String()), earlier.ID) {
+		t.Fatal("file trashed before the folder was restored with it")
+	}
+
+	// A second trash call deletes the whole tree permanently
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+root.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+root.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+
+	for _, f := range []models.File{top, nested, earlier} {
+		if _, ok := s.store.Object(f.ObjectKey); ok {
+			t.Fatalf("object %s is still at its original key", f.ObjectKey)
+		}
+		if _, ok := s.store.Object("delete/" + f.ObjectKey); !ok {
+			t.Fatalf("object %s was not moved under delete/", f.ObjectKey)
+		}
+	}
+	var remaining int64
+	s.db.Model(&models.Folder{}).Where("id IN ?", []uuid.UUID{root.ID, sub.ID}).Count(&remaining)
+	if remaining != 0 {
+		t.Fatalf("expected folder rows to be deleted, %d remain", remaining)
+	}
+	if used := s.storageUsed(user.ID); used != 0 {
+		t.Fatalf("expected storage used to drop to 0, got %d", used)
+	}
+
+	// The folders are gone, so restored files land at the root
+	s.expect(s.do(http.MethodPost, "/api/files/restore-deleted-files", token, nil), http.StatusOK, nil)
+	if !containsFile(s.listFiles(token, ""), nested.ID) {
+		t.Fatal("file from a deleted folder was not restored to the root")
+	}
+
+	other := s.createFolder(token, "scratch", nil)
+	s.expect(s.do(http.MethodDelete, "/api/folders/"+other.ID.String(), token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodDelete, "/api/folders/"+other.ID.String(), token, nil), http.StatusNotFound, nil)
+}
+
+func TestMoveAndCopy(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.createUser("Peggy")
+	_, strangerToken := s.createUser("Trent")
+
+	docs := s.createFolder(token, "docs", nil)
+	drafts := s.createFolder(token, "drafts", &docs.ID)
+	archive := s.createFolder(token, "archive", nil)
+	file := s.upload(token, "notes.txt", nil, "hello ", "world")
+
+	s.expect(s.do(http.MethodPatch, "/api/files/"+file.ID.String()+"/move", token, gin.H{"folderId": drafts.ID}), http.StatusOK, nil)
+	if !containsFile(s.listFiles(token, "parentId="+drafts.ID.String()), file.ID) {
+		t.Fatal("moved file is not listed in its new folder")
+	}
+	if containsFile(s.listFiles(token, ""), file.ID) {
+		t.Fatal("moved file is still listed at the root")
+	}
+
+	// Destinations must belong to the caller, and folders cannot move under themselves
+	strangerFolder := s.createFolder(strangerToken, "theirs", nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+file.ID.String()+"/move", token, gin.H{"folderId": strangerFolder.ID}), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+file.ID.String()+"/move", strangerToken, gin.H{"folderId": nil}), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+docs.ID.String()+"/move", token, gin.H{"parentId": drafts.ID}), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+docs.ID.String()+"/move", token, gin.H{"parentId": docs.ID}), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+drafts.ID.String()+"/move", token, gin.H{"parentId": archive.ID}), http.StatusOK, nil)
+
+	var copied models.File
+	s.expect(s.do(http.MethodPost, "/api/files/"+file.ID.String()+"/copy", token, gin.H{"folderId": nil}), http.StatusCreated, &copied)
+	if copied.ID == file.ID || copied.ObjectKey == file.ObjectKey {
+		t.Fatal("copy reused the original file identity")
+	}
+	if data, ok := s.store.Object(copied.ObjectKey); !ok || string(data) != "hello world" {
+		t.Fatalf("copied object has unexpected contents (found=%v)", ok)
+	}
+	if used := s.storageUsed(user.ID); used != 2*file.Size {
+		t.Fatalf("expected storage used %d after copy, got %d", 2*file.Size, used)
+	}
+
+	var copiedFolder models.Folder
+	s.expect(s.do(http.MethodPost, "/api/folders/"+archive.ID.String()+"/copy", token, gin.H{"parentId": docs.ID}), http.StatusCreated, &copiedFolder)
+	copiedDrafts := s.listFolders(token, "isTrash=false&parentId="+copiedFolder.ID.String())
+	if len(copiedDrafts) != 1 || copiedDrafts[0].Name != "drafts" {
+		t.Fatalf("subfolders were not copied: %v", copiedDrafts)
+	}
+	nested := s.listFiles(token, "parentId="+copiedDrafts[0].ID.String())
+	if len(nested) != 1 || nested[0].Name != "notes.txt" || nested[0].ID == file.ID {
+		t.Fatalf("nested files were not copied: %v", nested)
+	}
+	if used := s.storageUsed(user.ID); used != 3*file.Size {
+		t.Fatalf("expected storage used %d after folder copy, got %d", 3*file.Size, used)
+	}
+
+	// A copy that fails part way removes the objects it already copied
+	partial := s.createFolder(strangerToken, "partial", nil)
+	s.upload(strangerToken, "kept.txt", &partial.ID, "kept")
+	nestedFolder := s.createFolder(strangerToken, "nested", &partial.ID)
+	broken := s.upload(strangerToken, "broken.txt", &nestedFolder.ID, "broken")
+	s.store.DeleteObject(context.Background(), broken.ObjectKey)
+	objects := s.store.ObjectCount()
+	s.expect(s.do(http.MethodPost, "/api/folders/"+partial.ID.String()+"/copy", strangerToken, gin.H{"parentId": nil}), http.StatusInternalServerError, nil)
+	if count := s.store.ObjectCount(); count != objects {
+		t.Fatalf("failed folder copy left %d objects behind", count-objects)
+	}
+
+	s.db.Model(&models.Users{}).Where("id = ?", user.ID).Update("storage_limit", 3*file.Size+1)
+	s.expect(s.do(http.MethodPost, "/api/files/"+file.ID.String()+"/copy", token, gin.H{"folderId": nil}), http.StatusInsufficientStorage, nil)
+	if used := s.storageUsed(user.ID); used != 3*file.Size {
+		t.Fatalf("rejected copy changed storage used to %d", used)
+	}
+}
+
+func TestBreadcrumbsAndPathResolution(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.createUser("Quentin")
+	_, strangerToken := s.createUser("Rupert")
+
+	// Folder uploads create the intermediate folders; a repeated name must nest, not loop back
+	var initiated struct {
+		Key string `json:"key"`
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", token, gin.H{
+		"fileName":     "leaf.txt",
+		"contentType":  "text/plain",
+		"size":         4,
+		"totalChunks":  1,
+		"relativePath": "a/b/a/leaf.txt",
+	}), http.StatusOK, &initiated)
+
+	var pending models.File
+	if err := s.db.Where("object_key = ?", initiated.Key).First(&pending).Error; err != nil {
+		t.Fatalf("pending file not found: %v", err)
+	}
+
+	var resolved struct {
+		ID   *uuid.UUID `json:"id"`
+		Path string     `json:"path"`
+	}
+	s.expect(s.do(http.MethodGet, "/api/folders/resolve?path=/a/b/a/", token, nil), http.StatusOK, &resolved)
+	if resolved.ID == nil || pending.FolderID == nil || *resolved.ID != *pending.FolderID || resolved.Path != "/a/b/a" {
+		t.Fatalf("path resolved to %v (%s), upload went to %v", resolved.ID, resolved.Path, pending.FolderID)
+	}
+
+	var crumbs []dtos.BreadcrumbResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/"+resolved.ID.String()+"/breadcrumbs", token, nil), http.StatusOK, &crumbs)
+	if len(crumbs) != 3 || crumbs[0].Name != "a" || crumbs[1].Name != "b" || crumbs[2].ID != *resolved.ID {
+		t.Fatalf("unexpected breadcrumbs: %v", crumbs)
+	}
+	s.expect(s.do(http.MethodGet, "/api/folders/"+resolved.ID.String()+"/breadcrumbs", strangerToken, nil), http.StatusNotFound, nil)
+
+	s.expect(s.do(http.MethodGet, "/api/folders/resolve?path=/", token, nil), http.StatusOK, &resolved)
+	if resolved.ID != nil {
+		t.Fatalf("root path should resolve to a null id, got %v", resolved.ID)
+	}
+	s.expect(s.do(http.MethodGet, "/api/folders/resolve?path=/a/missing", token, nil), http.StatusNotFound, nil)
+
+	// Renaming must not leave the old path cached
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+crumbs[1].ID.String()+"/rename", token, gin.H{"name": "c"}), http.StatusOK, nil)
+	s.expect(s.do(http.MethodGet, "/api/folders/resolve?path=/a/b/a", token, nil), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodGet, "/api/folders/resolve?path=/a/c/a", token, nil), http.StatusOK, &resolved)
+	if resolved.ID == nil || *resolved.ID != crumbs[2].ID {
+		t.Fatalf("renamed path resolved to %v", resolved.ID)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.createUser("Sybil")
+	_, friendToken := s.createUser("Victor")
+
+	finance := s.createFolder(token, "Finance", nil)
+	reports := s.createFolder(token, "reports", &finance.ID)
+	s.createFolder(token, "Budget plans", nil)
+	nested := s.upload(token, "Budget 2024.txt", &reports.ID, "numbers")
+	rootFile := s.upload(token, "old_budget.csv", nil, "a,b,c,d,e,f,g,h")
+	s.upload(token, "notes.txt", nil, "unrelated")
+	trashed := s.upload(token, "budget-draft.txt", nil, "draft")
+	s.expect(s.do(http.MethodPatch, "/api/files/"+trashed.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+	s.db.Model(&models.File{}).Where("id = ?", rootFile.ID).Update("mime_type", "text/csv")
+
+	shared := s.upload(friendToken, "Team budget.txt", nil, "shared")
+	s.expect(s.do(http.MethodPost, "/api/files/share", friendToken, gin.H{
+		"fileId":     shared.ID,
+		"emails":     []string{user.Email},
+		"permission": models.PermissionViewer,
+	}), http.StatusOK, nil)
+
+	search := func(query string) dtos.SearchResponse {
+		t.Helper()
+		var res dtos.SearchResponse
+		s.expect(s.do(http.MethodGet, "/api/search?"+query, token, nil), http.StatusOK, &res)
+		return res
+	}
+	names := func(res dtos.SearchResponse) []string {
+		var out []string
+		for _, item := range res.Items {
+			out = append(out, item.Name)
+		}
+		return out
+	}
+
+	res := search("q=BUDGET")
+	if got := names(res); len(got) != 4 || got[0] != "Budget plans" {
+		t.Fatalf("substring search returned %v", got)
+	}
+	for _, item := range res.Items {
+		switch item.ID {
+		case nested.ID:
+			if item.Path != "/Finance/reports" || item.Permission != "owner" {
+				t.Fatalf("unexpected nested result %+v", item)
+			}
+		case shared.ID:
+			if item.Path != "" || item.Permission != string(models.PermissionViewer) {
+				t.Fatalf("shared result leaked its owner's path or permission: %+v", item)
+			}
+		case rootFile.ID:
+			if item.Path != "/" {
+				t.Fatalf("root file path is %q", item.Path)
+			}
+		}
+	}
+
+	if got := names(search("q=budget&mode=prefix")); len(got) != 2 || got[0] != "Budget plans" || got[1] != "Budget 2024.txt" {
+		t.Fatalf("prefix search returned %v", got)
+	}
+	if got := names(search("q=budget&owner=shared")); len(got) != 1 || got[0] != "Team budget.txt" {
+		t.Fatalf("shared-with-me search returned %v", got)
+	}
+	if got := names(search("q=budget&owner=me&type=file")); len(got) != 2 {
+		t.Fatalf("owned file search returned %v", got)
+	}
+	if got := names(search("q=budget&mimeType=text/csv")); len(got) != 1 || got[0] != "old_budget.csv" {
+		t.Fatalf("mime type search returned %v", got)
+	}
+	if got := names(search("q=budget&mimeType=text/")); len(got) != 3 {
+		t.Fatalf("mime type prefix search returned %v", got)
+	}
+	if got := names(search("q=budget&minSize=10&maxSize=20")); len(got) != 1 || got[0] != "old_budget.csv" {
+		t.Fatalf("size range search returned %v", got)
+	}
+	if got := names(search("q=budget&trashed=true")); len(got) != 1 || got[0] != "budget-draft.txt" {
+		t.Fatalf("trash search returned %v", got)
+	}
+	if got := names(search("q=_")); len(got) != 1 || got[0] != "old_budget.csv" {
+		t.Fatalf("LIKE wildcards were not escaped: %v", got)
+	}
+	future := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	if got := names(search("q=budget&modifiedFrom=" + future)); len(got) != 0 {
+		t.Fatalf("date range search returned %v", got)
+	}
+
+	page1 := search("q=budget&pageSize=3")
+	page2 := search("q=budget&pageSize=3&page=2")
+	if len(page1.Items) != 3 || !page1.HasMore || len(page2.Items) != 1 || page2.HasMore {
+		t.Fatalf("unexpected pages: %v (more=%v), %v (more=%v)", names(page1), page1.HasMore, names(page2), page2.HasMore)
+	}
+
+	s.expect(s.do(http.MethodGet, "/api/search?q=budget&owner=everyone", token, nil), http.StatusBadRequest, nil)
+
+	// A folder grant reaches the folders and files below it, with the role it gives
+	ledgers := s.createFolder(friendToken, "Ledgers", nil)
+	archive := s.createFolder(friendToken, "ledger archive", &ledgers.ID)
+	s.upload(friendToken, "ledger 2023.txt", &archive.ID, "old numbers")
+	s.expect(s.do(http.MethodPost, "/api/files/share", friendToken, gin.H{
+		"folderId": ledgers.ID, "emails": []string{user.Email}, "permission": models.PermissionEditor,
+	}), http.StatusOK, nil)
+	s.upload(friendToken, "ledger notes.txt", nil, "not shared")
+
+	for _, query := range []string{"q=ledger", "q=ledger&owner=shared"} {
+		res := search(query)
+		if got := names(res); len(got) != 3 || got[2] != "ledger 2023.txt" {
+			t.Fatalf("search %q missed content under a shared folder: %v", query, got)
+		}
+		for _, item := range res.Items {
+			if item.Permission != string(models.PermissionEditor) || item.Path != "" {
+				t.Fatalf("inherited result has the wrong permission or path: %+v", item)
+			}
+		}
+	}
+	if got := names(search("q=ledger&owner=me")); len(got) != 0 {
+		t.Fatalf("shared items matched owner=me: %v", got)
+	}
+}
+
+func TestListingPaginationAndSorting(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.createUser("Walter")
+
+	sizes := map[string]string{"delta.txt": "dddd", "alpha.txt": "a", "charlie.txt": "ccccccc", "bravo.txt": "bb", "echo.txt": "eee"}
+	for name, data := range sizes {
+		s.upload(token, name, nil, data)
+	}
+	for _, name := range []string{"zulu", "yankee", "xray"} {
+		s.createFolder(token, name, nil)
+	}
+
+	collect := func(query string) []string {
+		t.Helper()
+		var names []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatal("pagination did not terminate")
+			}
+			var page dtos.FileListResponse
+			s.expect(s.do(http.MethodGet, "/api/files/?"+query+"&limit=2&cursor="+cursor, token, nil), http.StatusOK, &page)
+			if len(page.Items) > 2 {
+				t.Fatalf("page exceeded the limit: %d items", len(page.Items))
+			}
+			for _, f := range page.Items {
+				names = append(names, f.Name)
+			}
+			if page.NextCursor == nil {
+				return names
+			}
+			cursor = url.QueryEscape(*page.NextCursor)
+		}
+	}
+
+	if got := strings.Join(collect("sort=name"), ","); got != "alpha.txt,bravo.txt,charlie.txt,delta.txt,echo.txt" {
+		t.Fatalf("name order: %s", got)
+	}
+	if got := strings.Join(collect("sort=size&order=desc"), ","); got != "charlie.txt,delta.txt,echo.txt,bravo.txt,alpha.txt" {
+		t.Fatalf("size order: %s", got)
+	}
+	if got := collect("sort=createdAt"); len(got) != 5 {
+		t.Fatalf("created order returned %v", got)
+	}
+
+	var page dtos.FolderListResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/?isTrash=false&limit=2", token, nil), http.StatusOK, &page)
+	if len(page.Items) != 2 || page.Items[0].Name != "xray" || page.NextCursor == nil {
+		t.Fatalf("unexpected first folder page: %+v", page)
+	}
+	s.expect(s.do(http.MethodGet, "/api/folders/?isTrash=false&limit=2&cursor="+url.QueryEscape(*page.NextCursor), token, nil), http.StatusOK, &page)
+	if len(page.Items) != 1 || page.Items[0].Name != "zulu" || page.NextCursor != nil {
+		t.Fatalf("unexpected last folder page: %+v", page)
+	}
+
+	// A cursor only resumes the sort order it was issued for
+	var first dtos.FileListResponse
+	s.expect(s.do(http.MethodGet, "/api/files/?sort=name&limit=1", token, nil), http.StatusOK, &first)
+	s.expect(s.do(http.MethodGet, "/api/files/?sort=size&limit=1&cursor="+url.QueryEscape(*first.NextCursor), token, nil), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodGet, "/api/files/?cursor=garbage", token, nil), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodGet, "/api/files/?sort=owner", token, nil), http.StatusBadRequest, nil)
+}
+
+func TestFolderContents(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.createUser("Xavier")
+	_, strangerToken := s.createUser("Yvonne")
+
+	project := s.createFolder(token, "project", nil)
+	assets := s.createFolder(token, "assets", &project.ID)
+	s.createFolder(token, "docs", &project.ID)
+	s.upload(token, "logo.png", &assets.ID, "png")
+	s.upload(token, "b.txt", &project.ID, "bb")
+	s.upload(token, "a.txt", &project.ID, "a")
+	s.upload(token, "c.txt", &project.ID, "ccc")
+
+	var all []dtos.FolderContentItem
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		var page dtos.FolderContentsResponse
+		s.expect(s.do(http.MethodGet, "/api/folders/contents?limit=2&parentId="+project.ID.String()+"&cursor="+cursor, token, nil), http.StatusOK, &page)
+		all = append(all, page.Items...)
+		if page.NextCursor == nil {
+			break
+		}
+		cursor = url.QueryEscape(*page.NextCursor)
+	}
+
+	var got []string
+	for _, item := range all {
+		got = append(got, item.Type+":"+item.Name)
+	}
+	if strings.Join(got, ",") != "folder:assets,folder:docs,file:a.txt,file:b.txt,file:c.txt" {
+		t.Fatalf("unexpected contents order: %v", got)
+	}
+	if all[0].ChildCount == nil || *all[0].ChildCount != 1 || *all[1].ChildCount != 0 {
+		t.Fatalf("unexpected child counts: %+v %+v", all[0], all[1])
+	}
+	if all[2].Size != 1 || all[2].ChildCount != nil || all[2].Permission != "owner" || all[2].UploadStatus != "completed" {
+		t.Fatalf("unexpected file item: %+v", all[2])
+	}
+
+	// Sorting applies within each section; folders still come first
+	var bySize dtos.FolderContentsResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/contents?sort=size&order=desc&parentId="+project.ID.String(), token, nil), http.StatusOK, &bySize)
+	if len(bySize.Items) != 5 || bySize.Items[0].Type != "folder" || bySize.Items[2].Name != "c.txt" || bySize.NextCursor != nil {
+		t.Fatalf("unexpected size ordering: %+v", bySize.Items)
+	}
+
+	// A page filled exactly by folders only points on when there are files to follow
+	var first dtos.FolderContentsResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/contents?limit=1", token, nil), http.StatusOK, &first)
+	if len(first.Items) != 1 || first.NextCursor != nil {
+		t.Fatalf("root should hold only the project folder: %+v", first)
+	}
+
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+assets.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+	var trash dtos.FolderContentsResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/contents?isTrash=true", token, nil), http.StatusOK, &trash)
+	if len(trash.Items) != 1 || trash.Items[0].ID != assets.ID || *trash.Items[0].ChildCount != 1 {
+		t.Fatalf("unexpected trash contents: %+v", trash.Items)
+	}
+
+	s.expect(s.do(http.MethodGet, "/api/folders/contents?parentId="+project.ID.String(), strangerToken, nil), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodGet, "/api/folders/contents?cursor=nowhere.abc", token, nil), http.StatusBadRequest, nil)
+}
+
+func TestFileVersions(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.createUser("Zara")
+	viewer, viewerToken := s.createUser("Aaron")
+
+	folder := s.createFolder(token, "reports", nil)
+	v1 := s.upload(token, "q1.txt", &folder.ID, "first")
+	v2 := s.upload(token, "q1.txt", &folder.ID, "second!")
+	v3 := s.upload(token, "q1.txt", &folder.ID, "third draft")
+
+	if v2.ID != v1.ID || v3.ID != v1.ID || v3.Version != 3 {
+		t.Fatalf("re-uploads did not become versions of the same file: %v %v %v (version %d)", v1.ID, v2.ID, v3.ID, v3.Version)
+	}
+	if files := s.listFiles(token, "parentId="+folder.ID.String()); len(files) != 1 || files[0].Size != int64(len("third draft")) {
+		t.Fatalf("expected one current file, got %+v", files)
+	}
+	total := int64(len("first") + len("second!") + len("third draft"))
+	if used := s.storageUsed(user.ID); used != total {
+		t.Fatalf("expected every version to count, storage used %d want %d", used, total)
+	}
+
+	s.expect(s.do(http.MethodPost, "/api/files/share", token, gin.H{
+		"fileId":     v1.ID,
+		"emails":     []string{viewer.Email},
+		"permission": models.PermissionViewer,
+	}), http.StatusOK, nil)
+
+	var versions []dtos.FileVersionResponse
+	s.expect(s.do(http.MethodGet, "/api/files/"+v1.ID.String()+"/versions", viewerToken, nil), http.StatusOK, &versions)
+	if len(versions) != 3 || !versions[0].IsCurrent || versions[0].VersionNumber != 3 || versions[1].VersionNumber != 2 || versions[2].Size != int64(len("first")) {
+		t.Fatalf("unexpected version history: %+v", versions)
+	}
+
+	var link struct {
+		URL string `json:"url"`
+	}
+	s.expect(s.do(http.MethodGet, "/api/files/"+v1.ID.String()+"/versions/1/download", viewerToken, nil), http.StatusOK, &link)
+	if !strings.Contains(link.URL, url.PathEscape(v1.ObjectKey)) {
+		t.Fatalf("version 1 download points at %s, want key %s", link.URL, v1.ObjectKey)
+	}
+
+	// Only the owner can change the history
+	s.expect(s.do(http.MethodPost, "/api/files/"+v1.ID.String()+"/versions/1/restore", viewerToken, nil), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/"+v1.ID.String()+"/versions/1/restore", token, nil), http.StatusOK, nil)
+
+	w := s.do(http.MethodGet, "/api/files/"+v1.ID.String()+"/content", token, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "first" {
+		t.Fatalf("restored version serves %d %q", w.Code, w.Body.String())
+	}
+	if used := s.storageUsed(user.ID); used != total {
+		t.Fatalf("restoring a version changed storage used to %d", used)
+	}
+
+	s.expect(s.do(http.MethodDelete, "/api/files/"+v1.ID.String()+"/versions/1", token, nil), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodDelete, "/api/files/"+v1.ID.String()+"/versions/2", token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodDelete, "/api/files/"+v1.ID.String()+"/versions/2", token, nil), http.StatusNotFound, nil)
+	if _, ok := s.store.Object(v2.ObjectKey); ok {
+		t.Fatal("deleted version's object still exists")
+	}
+	if used := s.storageUsed(user.ID); used != total-int64(len("second!")) {
+		t.Fatalf("deleting a version left storage used at %d", used)
+	}
+
+	// Permanently deleting the file releases the remaining old versions too
+	s.expect(s.do(http.MethodPatch, "/api/files/"+v1.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+v1.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+	if used := s.storageUsed(user.ID); used != 0 {
+		t.Fatalf("expected storage used 0 after permanent delete, got %d", used)
+	}
+	if _, ok := s.store.Object(v3.ObjectKey); ok {
+		t.Fatal("old version object survived permanent delete")
+	}
+}
+
+func TestContentDeduplication(t *testing.T) {
+	s := newTestServer(t)
+	hank, hankToken := s.createUser("Hank")
+	_, ivyToken := s.createUser("Ivy")
+
+	content := "the same installer bytes"
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+
+	loadFile := func(name string) models.File {
+		t.Helper()
+		var file models.File
+		if err := s.db.Where("name = ?", name).First(&file).Error; err != nil {
+			t.Fatalf("file %s not found: %v", name, err)
+		}
+		return file
+	}
+	blobRefs := func() int {
+		t.Helper()
+		var blob models.Blob
+		if err := s.db.Where("sha256 = ?", hash).First(&blob).Error; err != nil {
+			return 0
+		}
+		return blob.RefCount
+	}
+
+	w, _ := s.uploadWith(hankToken, "a.bin", nil, nil, gin.H{"sha256": hash}, content[:10], content[10:])
+	s.expect(w, http.StatusOK, nil)
+	first := loadFile("a.bin")
+	if first.SHA256 == nil || *first.SHA256 != hash {
+		t.Fatalf("sha256 was not stored on the file: %v", first.SHA256)
+	}
+
+	w, secondKey := s.uploadWith(hankToken, "b.bin", nil, nil, gin.H{"sha256": strings.ToUpper(hash)}, content)
+	s.expect(w, http.StatusOK, nil)
+	second := loadFile("b.bin")
+	if second.ObjectKey != first.ObjectKey {
+		t.Fatalf("duplicate content was not deduplicated: %s vs %s", second.ObjectKey, first.ObjectKey)
+	}
+	if _, ok := s.store.Object(secondKey); ok {
+		t.Fatal("the duplicate upload's own object was kept")
+	}
+
+	w, _ = s.uploadWith(ivyToken, "c.bin", nil, nil, gin.H{"sha256": hash}, content)
+	s.expect(w, http.StatusOK, nil)
+	if refs := blobRefs(); refs != 3 {
+		t.Fatalf("expected 3 references to the blob, got %d", refs)
+	}
+	if used := s.storageUsed(hank.ID); used != 2*int64(len(content)) {
+		t.Fatalf("each file should still count against quota, got %d used", used)
+	}
+
+	// A wrong hash leaves the file in error and charges nothing
+	w, _ = s.uploadWith(hankToken, "bad.bin", nil, nil, gin.H{"sha256": strings.Repeat("0", 64)}, "something else")
+	s.expect(w, http.StatusUnprocessableEntity, nil)
+	if bad := loadFile("bad.bin"); bad.UploadStatus != "error" {
+		t.Fatalf("expected mismatched upload in error, got %q", bad.UploadStatus)
+	}
+	if used := s.storageUsed(hank.ID); used != 2*int64(len(content)) {
+		t.Fatalf("mismatched upload was charged, got %d used", used)
+	}
+
+	w = s.do(http.MethodGet, "/api/files/"+second.ID.String()+"/content", hankToken, nil)
+	s.expect(w, http.StatusOK, nil)
+	if w.Body.String() != content {
+		t.Fatalf("deduplicated file served %q", w.Body.String())
+	}
+
+	// Copies share the blob instead of copying the object
+	var copied models.File
+	s.expect(s.do(http.MethodPost, "/api/files/"+second.ID.String()+"/copy", hankToken, gin.H{}), http.StatusCreated, &copied)
+	if refs := blobRefs(); refs != 4 {
+		t.Fatalf("expected 4 references after copy, got %d", refs)
+	}
+
+	// Permanent delete keeps the shared object for the other files and for restore
+	s.expect(s.do(http.MethodPatch, "/api/files/"+first.ID.String()+"/trash", hankToken, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+first.ID.String()+"/trash", hankToken, nil), http.StatusOK, nil)
+	if _, ok := s.store.Object(first.ObjectKey); !ok {
+		t.Fatal("shared object was deleted with one of its files")
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/restore-deleted-files", hankToken, nil), http.StatusOK, nil)
+	if !containsFile(s.listFiles(hankToken, ""), first.ID) {
+		t.Fatal("deduplicated file was not restored")
+	}
+	if refs := blobRefs(); refs != 4 {
+		t.Fatalf("expected 4 references after restore, got %d", refs)
+	}
+
+	// The object goes once its last reference is purged
+	for _, token := range []string{hankToken, ivyToken} {
+		for _, f := range s.listFiles(token, "") {
+			s.expect(s.do(http.MethodPatch, "/api/files/"+f.ID.String()+"/trash", token, nil), http.StatusOK, nil)
+		}
+		s.expect(s.do(http.MethodPost, "/api/files/trash/empty", token, nil), http.StatusOK, nil)
+	}
+	if _, ok := s.store.Object(first.ObjectKey); !ok {
+		t.Fatal("shared object was deleted while restorable")
+	}
+	err := s.db.Model(&models.DeletedFile{}).Where("1 = 1").
+		Update("deleted_at", time.Now().AddDate(0, 0, -31)).Error
+	if err != nil {
+		t.Fatalf("failed to backdate deleted files: %v", err)
+	}
+
+	worker.PurgeExpiredDeletedFiles(s.db, s.store, testBucket)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for blobRefs() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("blob still has %d references after purge", blobRefs())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, ok := s.store.Object(first.ObjectKey); ok {
+		t.Fatal("unreferenced object still exists")
+	}
+}
+
+func TestReuploadDeduplicatedFiles(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.createUser("Jude")
+
+	content := "shared first draft"
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w, _ := s.uploadWith(token, name, nil, nil, gin.H{"sha256": hash}, content)
+		s.expect(w, http.StatusOK, nil)
+	}
+	var a models.File
+	if err := s.db.Where("name = ?", "a.txt").First(&a).Error; err != nil {
+		t.Fatalf("file a.txt not found: %v", err)
+	}
+
+	// Both files now push the shared key into their version history
+	secondSum := sha256.Sum256([]byte("a, second draft"))
+	secondHash := hex.EncodeToString(secondSum[:])
+	w, _ := s.uploadWith(token, "a.txt", nil, nil, gin.H{"sha256": secondHash}, "a, second draft")
+	s.expect(w, http.StatusOK, nil)
+	s.upload(token, "b.txt", nil, "b, second draft")
+
+	var versions []models.FileVersion
+	if err := s.db.Where("object_key = ?", a.ObjectKey).Find(&versions).Error; err != nil {
+		t.Fatalf("failed to load versions: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected both files to keep the shared object as a version, got %d", len(versions))
+	}
+	total := int64(2*len(content) + len("a, second draft") + len("b, second draft"))
+	if used := s.storageUsed(user.ID); used != total {
+		t.Fatalf("expected storage used %d, got %d", total, used)
+	}
+
+	// The hash follows the content it describes through new and restored versions
+	fileHash := func(name string) string {
+		t.Helper()
+		var file models.File
+		if err := s.db.Where("name = ?", name).First(&file).Error; err != nil {
+			t.Fatalf("file %s not found: %v", name, err)
+		}
+		if file.SHA256 == nil {
+			return ""
+		}
+		return *file.SHA256
+	}
+	if got := fileHash("a.txt"); got != secondHash {
+		t.Fatalf("re-uploaded file kept sha256 %q, want %q", got, secondHash)
+	}
+	if got := fileHash("b.txt"); got != "" {
+		t.Fatalf("re-upload without a hash kept sha256 %q", got)
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/"+a.ID.String()+"/versions/1/restore", token, nil), http.StatusOK, nil)
+	if got := fileHash("a.txt"); got != hash {
+		t.Fatalf("restored version has sha256 %q, want %q", got, hash)
+	}
+}
+
+func TestUploadChecksums(t *testing.T) {
+	s := newTestServer(t)
+	_, token := s.createUser("Jack")
+
+	b64 := func(sum []byte) string { return base64.StdEncoding.EncodeToString(sum) }
+	sha := func(data string) string { sum := sha256.Sum256([]byte(data)); return b64(sum[:]) }
+	crc := func(data string) string {
+		sum := crc32.Checksum([]byte(data), crc32.MakeTable(crc32.Castagnoli))
+		return b64(binary.BigEndian.AppendUint32(nil, sum))
+	}
+
+	// Whole-file and per-part SHA-256, all correct
+	parts := []string{"first half, ", "second half"}
+	w, _ := s.uploadWith(token, "good.txt", nil, gin.H{
+		"checksumAlgorithm": "sha256",
+		"checksum":          sha(parts[0] + parts[1]),
+		"partChecksums":     []string{sha(parts[0]), sha(parts[1])},
+	}, nil, parts...)
+	s.expect(w, http.StatusOK, nil)
+
+	var good models.File
+	if err := s.db.Where("name = ?", "good.txt").First(&good).Error; err != nil {
+		t.Fatalf("file not found: %v", err)
+	}
+	if good.UploadStatus != "completed" || good.Checksum == nil || *good.Checksum != sha(parts[0]+parts[1]) {
+		t.Fatalf("checksum was not persisted on a completed file: %s %v", good.UploadStatus, good.Checksum)
+	}
+	if good.ChecksumAlgorithm == nil || *good.ChecksumAlgorithm != storage.ChecksumSHA256 {
+		t.Fatalf("unexpected checksum algorithm %v", good.ChecksumAlgorithm)
+	}
+
+	// A new version carries its own checksum, and restoring brings the old one back
+	w, _ = s.uploadWith(token, "good.txt", nil, gin.H{
+		"checksumAlgorithm": "CRC32C",
+		"checksum":          crc("new draft"),
+	}, nil, "new draft")
+	s.expect(w, http.StatusOK, nil)
+	reloaded := func() models.File {
+		t.Helper()
+		var file models.File
+		if err := s.db.First(&file, "id = ?", good.ID).Error; err != nil {
+			t.Fatalf("file not found: %v", err)
+		}
+		return file
+	}
+	if file := reloaded(); file.Checksum == nil || *file.Checksum != crc("new draft") ||
+		file.ChecksumAlgorithm == nil || *file.ChecksumAlgorithm != storage.ChecksumCRC32C {
+		t.Fatalf("new version kept the old checksum: %v %v", file.ChecksumAlgorithm, file.Checksum)
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/"+good.ID.String()+"/versions/1/restore", token, nil), http.StatusOK, nil)
+	if file := reloaded(); file.Checksum == nil || *file.Checksum != sha(parts[0]+parts[1]) ||
+		file.ChecksumAlgorithm == nil || *file.ChecksumAlgorithm != storage.ChecksumSHA256 {
+		t.Fatalf("restored version has checksum %v %v", file.ChecksumAlgorithm, file.Checksum)
+	}
+
+	// A wrong whole-file checksum marks the upload as failed
+	w, _ = s.uploadWith(token, "bad.txt", nil, gin.H{
+		"checksumAlgorithm": "CRC32C",
+		"checksum":          crc("something else"),
+	}, nil, "actual content")
+	s.expect(w, http.StatusUnprocessableEntity, nil)
+	var bad models.File
+	if err := s.db.Where("name = ?", "bad.txt").First(&bad).Error; err != nil {
+		t.Fatalf("file not found: %v", err)
+	}
+	if bad.UploadStatus != "error" {
+		t.Fatalf("expected mismatched upload in error, got %q", bad.UploadStatus)
+	}
+
+	// Presigned parts carry their declared checksum and reject other data
+	var initiated struct {
+		SessionID string `json:"sessionId"`
+		UploadID  string `json:"uploadId"`
+		Key       string `json:"key"`
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", token, gin.H{
+		"fileName":          "part.txt",
+		"contentType":       "text/plain",
+		"size":              4,
+		"totalChunks":       1,
+		"checksumAlgorithm": "CRC32C",
+		"partChecksums":     []string{crc("data")},
+	}), http.StatusOK, &initiated)
+
+	var presigned struct {
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/presign-part", token, gin.H{
+		"sessionId": initiated.SessionID, "partNumber": 1,
+	}), http.StatusOK, &presigned)
+	if presigned.Headers["x-amz-checksum-crc32c"] != crc("data") {
+		t.Fatalf("presign did not return the checksum header: %v", presigned.Headers)
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/presign-part", token, gin.H{
+		"sessionId": initiated.SessionID, "partNumber": 2,
+	}), http.StatusBadRequest, nil)
+
+	if _, err := s.store.UploadPart(initiated.Key, initiated.UploadID, 1, []byte("tampered")); !errors.Is(err, storage.ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch for tampered part, got %v", err)
+	}
+
+	// Malformed declarations are rejected up front
+	for _, body := range []gin.H{
+		{"checksumAlgorithm": "md5", "checksum": sha("x")},
+		{"checksumAlgorithm": "SHA256", "checksum": "not base64!"},
+		{"checksumAlgorithm": "SHA256", "partChecksums": []string{sha("x"), sha("y")}},
+	} {
+		body["fileName"] = "invalid.txt"
+		body["contentType"] = "text/plain"
+		body["size"] = 1
+		body["totalChunks"] = 1
+		s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", token, body), http.StatusBadRequest, nil)
+	}
+}
+
+// readBackStore hides MemoryStore's full-object checksums, like a backend that keeps none.
+type readBackStore struct {
+	storage.ObjectStore
+}
+
+func TestBackgroundUploadVerification(t *testing.T) {
+	s := newTestServer(t)
+	s.router = setupRouter(s.db, readBackStore{s.store}, "test")
+	user, token := s.createUser("Mona")
+
+	content := "content the backend has no checksum for"
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+
+	waitForStatus := func(fileID uuid.UUID) models.File {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var file models.File
+			if err := s.db.First(&file, "id = ?", fileID).Error; err != nil {
+				t.Fatalf("file not found: %v", err)
+			}
+			if file.UploadStatus != "verifying" {
+				return file
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("upload is still verifying")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	var accepted struct {
+		FileID       uuid.UUID `json:"fileId"`
+		UploadStatus string    `json:"uploadStatus"`
+	}
+	w, _ := s.uploadWith(token, "good.bin", nil, nil, gin.H{"sha256": hash}, content)
+	s.expect(w, http.StatusAccepted, &accepted)
+	if accepted.UploadStatus != "verifying" {
+		t.Fatalf("expected the upload to be verifying, got %q", accepted.UploadStatus)
+	}
+	good := waitForStatus(accepted.FileID)
+	if good.UploadStatus != "completed" || good.SHA256 == nil || *good.SHA256 != hash {
+		t.Fatalf("verified upload is %q with sha256 %v", good.UploadStatus, good.SHA256)
+	}
+	if used := s.storageUsed(user.ID); used != int64(len(content)) {
+		t.Fatalf("expected the verified upload to be charged, got %d used", used)
+	}
+
+	w, _ = s.uploadWith(token, "bad.bin", nil, nil, gin.H{"sha256": strings.Repeat("0", 64)}, content)
+	s.expect(w, http.StatusAccepted, &accepted)
+	bad := waitForStatus(accepted.FileID)
+	if bad.UploadStatus != "error" || bad.SHA256 != nil {
+		t.Fatalf("mismatched upload is %q with sha256 %v", bad.UploadStatus, bad.SHA256)
+	}
+	if used := s.storageUsed(user.ID); used != int64(len(content)) {
+		t.Fatalf("mismatched upload was charged, got %d used", used)
+	}
+
+	// Uploads with nothing to check complete straight away
+	s.upload(token, "plain.txt", nil, "no checksum")
+}
+
+func TestUploadSessionValidation(t *testing.T) {
+	s := newTestServer(t)
+	owner, token := s.createUser("Kate")
+	_, strangerToken := s.createUser("Leo")
+
+	initiate := func(name string, size int, totalChunks int) (string, string, string) {
+		t.Helper()
+		var initiated struct {
+			SessionID string `json:"sessionId"`
+			UploadID  string `json:"uploadId"`
+			Key       string `json:"key"`
+		}
+		s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", token, gin.H{
+			"fileName":    name,
+			"contentType": "text/plain",
+			"size":        size,
+			"totalChunks": totalChunks,
+		}), http.StatusOK, &initiated)
+		return initiated.SessionID, initiated.UploadID, initiated.Key
+	}
+	putParts := func(uploadID string, key string, parts ...string) []storage.CompletedPart {
+		t.Helper()
+		var completed []storage.CompletedPart
+		for i, data := range parts {
+			etag, err := s.store.UploadPart(key, uploadID, int32(i+1), []byte(data))
+			if err != nil {
+				t.Fatalf("failed to upload part %d: %v", i+1, err)
+			}
+			completed = append(completed, storage.CompletedPart{PartNumber: int32(i + 1), ETag: strings.Trim(etag, `"`)})
+		}
+		return completed
+	}
+
+	sessionID, uploadID, key := initiate("short.txt", 10, 2)
+
+	presign := func(token string, body gin.H) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/files/uploads/presign-part", token, body)
+	}
+	s.expect(presign(strangerToken, gin.H{"sessionId": sessionID, "partNumber": 1}), http.StatusNotFound, nil)
+	s.expect(presign(token, gin.H{"sessionId": sessionID, "key": "uploads/other/key", "partNumber": 1}), http.StatusBadRequest, nil)
+	s.expect(presign(token, gin.H{"sessionId": sessionID, "uploadId": "someone-elses", "partNumber": 1}), http.StatusBadRequest, nil)
+	s.expect(presign(token, gin.H{"sessionId": sessionID, "partNumber": 3}), http.StatusBadRequest, nil)
+	s.expect(presign(token, gin.H{"sessionId": sessionID, "uploadId": uploadID, "key": key, "partNumber": 2}), http.StatusOK, nil)
+
+	// Seven bytes arrive for a declared ten
+	parts := putParts(uploadID, key, "hello", "!!")
+	complete := func(token string, sessionID string, parts []storage.CompletedPart) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/files/uploads/complete", token, gin.H{"sessionId": sessionID, "parts": parts})
+	}
+	s.expect(complete(strangerToken, sessionID, parts), http.StatusNotFound, nil)
+	s.expect(complete(token, sessionID, append(parts, parts[0])), http.StatusBadRequest, nil)
+	s.expect(complete(token, sessionID, parts), http.StatusBadRequest, nil)
+
+	var file models.File
+	if err := s.db.Where("object_key = ?", key).First(&file).Error; err != nil {
+		t.Fatalf("pending file not found: %v", err)
+	}
+	if file.UploadStatus != "pending" {
+		t.Fatalf("rejected upload should stay pending, got %q", file.UploadStatus)
+	}
+	if _, ok := s.store.Object(key); ok {
+		t.Fatal("a rejected upload was assembled")
+	}
+
+	// Quota is checked again at completion
+	sessionID, uploadID, key = initiate("big.txt", 8, 1)
+	parts = putParts(uploadID, key, "12345678")
+	if err := s.db.Model(&models.Users{}).Where("id = ?", owner.ID).Update("storage_limit", 8).Error; err != nil {
+		t.Fatalf("failed to lower storage limit: %v", err)
+	}
+	s.expect(complete(token, sessionID, parts), http.StatusInsufficientStorage, nil)
+	if used := s.storageUsed(owner.ID); used != 0 {
+		t.Fatalf("over-quota upload was charged %d", used)
+	}
+}
+
+func TestAbortUploads(t *testing.T) {
+	s := newTestServer(t)
+	owner, token := s.createUser("Mia")
+	_, strangerToken := s.createUser("Ned")
+
+	initiate := func(name string) (string, string, string) {
+		t.Helper()
+		var initiated struct {
+			SessionID string `json:"sessionId"`
+			UploadID  string `json:"uploadId"`
+			Key       string `json:"key"`
+		}
+		s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", token, gin.H{
+			"fileName":    name,
+			"contentType": "text/plain",
+			"size":        5,
+			"totalChunks": 1,
+		}), http.StatusOK, &initiated)
+		if _, err := s.store.UploadPart(initiated.Key, initiated.UploadID, 1, []byte("hello")); err != nil {
+			t.Fatalf("failed to upload part: %v", err)
+		}
+		return initiated.SessionID, initiated.UploadID, initiated.Key
+	}
+	gone := func(uploadID string, key string) {
+		t.Helper()
+		if _, err := s.store.ListParts(context.Background(), key, uploadID); !errors.Is(err, storage.ErrNoSuchUpload) {
+			t.Fatalf("upload %s still exists in storage: %v", uploadID, err)
+		}
+		var files, pending int64
+		s.db.Unscoped().Model(&models.File{}).Where("object_key = ?", key).Count(&files)
+		s.db.Unscoped().Model(&models.PendingUpload{}).Where("s3_key = ?", key).Count(&pending)
+		if files != 0 || pending != 0 {
+			t.Fatalf("aborted upload left %d file and %d pending rows", files, pending)
+		}
+	}
+
+	sessionID, uploadID, key := initiate("cancelled.txt")
+	abort := gin.H{"sessionId": sessionID}
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/abort", strangerToken, abort), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/abort", token, abort), http.StatusOK, nil)
+	gone(uploadID, key)
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/abort", token, abort), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/presign-part", token, gin.H{"sessionId": sessionID, "partNumber": 1}), http.StatusNotFound, nil)
+
+	// The janitor aborts uploads idle past the TTL and leaves active ones alone
+	t.Setenv("UPLOAD_SESSION_TTL", "1h")
+	_, staleUploadID, staleKey := initiate("stale.txt")
+	activeSession, _, activeKey := initiate("active.txt")
+	s.db.Model(&models.PendingUpload{}).Where("s3_key = ?", staleKey).Update("updated_at", time.Now().Add(-2*time.Hour))
+	s.db.Model(&models.PendingUpload{}).Where("s3_key = ?", activeKey).Update("updated_at", time.Now().Add(-2*time.Hour))
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/presign-part", token, gin.H{"sessionId": activeSession, "partNumber": 1}), http.StatusOK, nil)
+
+	worker.AbortStaleUploads(s.db, s.store, testBucket)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.db.Unscoped().First(&models.PendingUpload{}, "s3_key = ?", staleKey).Error == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("stale upload was not aborted")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	gone(staleUploadID, staleKey)
+
+	if err := s.db.First(&models.PendingUpload{}, "s3_key = ?", activeKey).Error; err != nil {
+		t.Fatalf("active upload was aborted: %v", err)
+	}
+	if used := s.storageUsed(owner.ID); used != 0 {
+		t.Fatalf("aborted uploads changed storage used to %d", used)
+	}
+}
+
+func TestBatchPresignParts(t *testing.T) {
+	s := newTestServer(t)
+	owner, token := s.createUser("Olga")
+	_, strangerToken := s.createUser("Pete")
+	if err := s.db.Model(&models.Users{}).Where("id = ?", owner.ID).Update("storage_limit", int64(storage.MaxObjectSize)*2).Error; err != nil {
+		t.Fatalf("failed to raise storage limit: %v", err)
+	}
+
+	type initiated struct {
+		SessionID  string `json:"sessionId"`
+		PartSize   int64  `json:"partSize"`
+		TotalParts int    `json:"totalParts"`
+	}
+	initiate := func(name string, size int64) initiated {
+		t.Helper()
+		var resp initiated
+		s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", token, gin.H{
+			"fileName":    name,
+			"contentType": "application/octet-stream",
+			"size":        size,
+		}), http.StatusOK, &resp)
+		return resp
+	}
+
+	small := initiate("small.bin", 2*storage.MinPartSize+1)
+	if small.PartSize != storage.MinPartSize || small.TotalParts != 3 {
+		t.Fatalf("small file split into %d parts of %d, want 3 of %d", small.TotalParts, small.PartSize, storage.MinPartSize)
+	}
+
+	// 100 GiB would need 20,480 parts at the minimum part size
+	huge := initiate("huge.bin", 100<<30)
+	if huge.TotalParts > storage.MaxUploadParts || huge.PartSize%(1<<20) != 0 || int64(huge.TotalParts)*huge.PartSize < 100<<30 {
+		t.Fatalf("huge file split into %d parts of %d", huge.TotalParts, huge.PartSize)
+	}
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", token, gin.H{
+		"fileName":    "too-big.bin",
+		"contentType": "application/octet-stream",
+		"size":        int64(storage.MaxObjectSize) + 1,
+	}), http.StatusBadRequest, nil)
+
+	presign := func(token string, body gin.H) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/files/uploads/presign-parts", token, body)
+	}
+	var batch dtos.PresignedPartsResponse
+	s.expect(presign(token, gin.H{"sessionId": small.SessionID, "startPart": 1, "endPart": 3, "expiresIn": 3600}), http.StatusOK, &batch)
+	if len(batch.Parts) != 3 {
+		t.Fatalf("expected 3 presigned parts, got %d", len(batch.Parts))
+	}
+	for i, part := range batch.Parts {
+		if part.PartNumber != int32(i+1) || !strings.Contains(part.URL, fmt.Sprintf("partNumber=%d", i+1)) {
+			t.Fatalf("unexpected presigned part %d: %+v", i, part)
+		}
+	}
+	if until := time.Until(batch.ExpiresAt); until < 59*time.Minute || until > time.Hour {
+		t.Fatalf("expected URLs to expire in an hour, got %s", until)
+	}
+
+	s.expect(presign(strangerToken, gin.H{"sessionId": small.SessionID, "startPart": 1, "endPart": 3}), http.StatusNotFound, nil)
+	s.expect(presign(token, gin.H{"sessionId": small.SessionID, "startPart": 2, "endPart": 4}), http.StatusBadRequest, nil)
+	s.expect(presign(token, gin.H{"sessionId": small.SessionID, "startPart": 3, "endPart": 2}), http.StatusBadRequest, nil)
+	s.expect(presign(token, gin.H{"sessionId": small.SessionID, "startPart": 1, "endPart": 3, "expiresIn": 1}), http.StatusBadRequest, nil)
+	s.expect(presign(token, gin.H{"sessionId": huge.SessionID, "startPart": 1, "endPart": 1001}), http.StatusBadRequest, nil)
+	s.expect(presign(token, gin.H{"sessionId": huge.SessionID, "startPart": 1001, "endPart": 2000}), http.StatusOK, nil)
+}
+
+func TestFolderSharing(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.createUser("Quinn")
+	grantee, granteeToken := s.createUser("Rosa")
+	_, strangerToken := s.createUser("Sam")
+
+	work := s.createFolder(ownerToken, "work", nil)
+	projects := s.createFolder(ownerToken, "projects", &work.ID)
+	q1 := s.createFolder(ownerToken, "q1", &projects.ID)
+	overview := s.upload(ownerToken, "overview.txt", &projects.ID, "overview")
+	plan := s.upload(ownerToken, "plan.txt", &q1.ID, "quarterly plan")
+	private := s.upload(ownerToken, "private.txt", &work.ID, "not shared")
+
+	share := func(body gin.H) *httptest.ResponseRecorder {
+		body["emails"] = []string{grantee.Email}
+		return s.do(http.MethodPost, "/api/files/share", ownerToken, body)
+	}
+	s.expect(share(gin.H{"fileId": plan.ID, "folderId": projects.ID, "permission": models.PermissionViewer}), http.StatusBadRequest, nil)
+	s.expect(share(gin.H{"permission": models.PermissionViewer}), http.StatusBadRequest, nil)
+	s.expect(share(gin.H{"folderId": projects.ID, "permission": models.PermissionOwner}), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodGet, "/api/files/"+plan.ID.String()+"/content", granteeToken, nil), http.StatusNotFound, nil)
+
+	s.expect(share(gin.H{"folderId": projects.ID, "permission": models.PermissionViewer}), http.StatusOK, nil)
+	// Sharing again updates the grant in place
+	s.expect(share(gin.H{"folderId": projects.ID, "permission": models.PermissionEditor}), http.StatusOK, nil)
+	// A subfolder with a grant of its own is still only reached through projects
+	s.expect(share(gin.H{"folderId": q1.ID, "permission": models.PermissionViewer}), http.StatusOK, nil)
+
+	var shared []dtos.SharedFolderResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/shared-with-me", granteeToken, nil), http.StatusOK, &shared)
+	if len(shared) != 1 || shared[0].ID != projects.ID || shared[0].Permission != string(models.PermissionEditor) || shared[0].SharedBy != owner.FirstName {
+		t.Fatalf("unexpected shared folders: %+v", shared)
+	}
+
+	var contents dtos.FolderContentsResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/contents?parentId="+projects.ID.String(), granteeToken, nil), http.StatusOK, &contents)
+	if len(contents.Items) != 2 || contents.Items[0].ID != q1.ID || contents.Items[1].ID != overview.ID {
+		t.Fatalf("unexpected shared folder contents: %+v", contents.Items)
+	}
+	for _, item := range contents.Items {
+		if item.Permission != string(models.PermissionEditor) {
+			t.Fatalf("expected inherited editor permission, got %+v", item)
+		}
+	}
+	if files := s.listFiles(granteeToken, "parentId="+q1.ID.String()); len(files) != 1 || files[0].ID != plan.ID {
+		t.Fatalf("unexpected files in shared subfolder: %+v", files)
+	}
+	if folders := s.listFolders(granteeToken, "isTrash=false&parentId="+projects.ID.String()); len(folders) != 1 || folders[0].ID != q1.ID {
+		t.Fatalf("unexpected subfolders of shared folder: %+v", folders)
+	}
+
+	// Grants are resolved through the ancestors at access time, so later uploads are covered too
+	late := s.upload(ownerToken, "late.txt", &q1.ID, "added after sharing")
+	for _, file := range []models.File{plan, late} {
+		w := s.do(http.MethodGet, "/api/files/"+file.ID.String()+"/content", granteeToken, nil)
+		s.expect(w, http.StatusOK, nil)
+	}
+	s.expect(s.do(http.MethodGet, "/api/files/"+private.ID.String()+"/content", granteeToken, nil), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodGet, "/api/folders/contents?parentId="+work.ID.String(), granteeToken, nil), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodGet, "/api/folders/contents?parentId="+projects.ID.String(), strangerToken, nil), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodGet, "/api/files/"+plan.ID.String()+"/content", strangerToken, nil), http.StatusNotFound, nil)
+
+	var crumbs []dtos.BreadcrumbResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/"+q1.ID.String()+"/breadcrumbs", granteeToken, nil), http.StatusOK, &crumbs)
+	if len(crumbs) != 2 || crumbs[0].ID != projects.ID || crumbs[1].ID != q1.ID {
+		t.Fatalf("breadcrumbs should start at the shared folder: %+v", crumbs)
+	}
+
+	w := s.do(http.MethodGet, "/api/folders/"+projects.ID.String()+"/download", granteeToken, nil)
+	s.expect(w, http.StatusOK, nil)
+	entries := readZip(t, w.Body.Bytes())
+	if entries["projects/overview.txt"] != "overview" || entries["projects/q1/plan.txt"] != "quarterly plan" || entries["projects/q1/late.txt"] != "added after sharing" {
+		t.Fatalf("unexpected shared folder archive: %v", entries)
+	}
+}
+
+func TestRoleEnforcement(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.createUser("Tara")
+	viewer, viewerToken := s.createUser("Uma")
+	editor, editorToken := s.createUser("Vic")
+
+	team := s.createFolder(ownerToken, "team", nil)
+	notes := s.upload(ownerToken, "notes.txt", &team.ID, "meeting notes")
+	for email, role := range map[string]models.PermissionType{viewer.Email: models.PermissionViewer, editor.Email: models.PermissionEditor} {
+		s.expect(s.do(http.MethodPost, "/api/files/share", ownerToken, gin.H{
+			"folderId": team.ID, "emails": []string{email}, "permission": role,
+		}), http.StatusOK, nil)
+	}
+
+	// Viewers can read but not change anything
+	s.expect(s.do(http.MethodGet, "/api/files/"+notes.ID.String()+"/content", viewerToken, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+notes.ID.String()+"/rename", viewerToken, gin.H{"name": "x.txt"}), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+notes.ID.String()+"/trash", viewerToken, nil), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+team.ID.String()+"/rename", viewerToken, gin.H{"name": "x"}), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPost, "/api/folders/", viewerToken, gin.H{"name": "sub", "parentId": team.ID}), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/uploads/initiate", viewerToken, gin.H{
+		"fileName": "new.txt", "contentType": "text/plain", "size": 3, "parentId": team.ID,
+	}), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/share", viewerToken, gin.H{
+		"fileId": notes.ID, "emails": []string{editor.Email}, "permission": models.PermissionViewer,
+	}), http.StatusForbidden, nil)
+
+	// Editors can change shared content; what they add belongs to and is charged to the owner
+	s.expect(s.do(http.MethodPatch, "/api/files/"+notes.ID.String()+"/rename", editorToken, gin.H{"name": "minutes.txt"}), http.StatusOK, nil)
+	added := s.upload(editorToken, "agenda.txt", &team.ID, "agenda")
+	if added.OwnerID != owner.ID {
+		t.Fatalf("file uploaded into a shared folder should belong to its owner, got %s", added.OwnerID)
+	}
+	if used := s.storageUsed(owner.ID); used != int64(len("meeting notes")+len("agenda")) {
+		t.Fatalf("owner should be charged for the editor's upload, used %d", used)
+	}
+	if used := s.storageUsed(editor.ID); used != 0 {
+		t.Fatalf("editor should not be charged, used %d", used)
+	}
+	sub := s.createFolder(editorToken, "sub", &team.ID)
+	if sub.OwnerID != owner.ID {
+		t.Fatalf("folder created in a shared folder should belong to its owner, got %s", sub.OwnerID)
+	}
+	// Content cannot leave its owner's tree
+	s.expect(s.do(http.MethodPatch, "/api/files/"+added.ID.String()+"/move", editorToken, gin.H{"folderId": nil}), http.StatusBadRequest, nil)
+
+	s.expect(s.do(http.MethodPatch, "/api/files/"+added.ID.String()+"/trash", editorToken, nil), http.StatusOK, nil)
+	// Trashed content is the owner's alone
+	s.expect(s.do(http.MethodPost, "/api/files/restore-file", editorToken, gin.H{"fileId": added.ID}), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodDelete, "/api/folders/"+sub.ID.String(), editorToken, nil), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/restore-file", ownerToken, gin.H{"fileId": added.ID}), http.StatusOK, nil)
+}
+
+func TestShareManagement(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.createUser("Wren")
+	editor, editorToken := s.createUser("Xena")
+	viewer, viewerToken := s.createUser("Yuri")
+	heir, heirToken := s.createUser("Zane")
+
+	docs := s.createFolder(ownerToken, "docs", nil)
+	specs := s.createFolder(ownerToken, "specs", &docs.ID)
+	spec := s.upload(ownerToken, "spec.txt", &specs.ID, "the spec")
+	s.expect(s.do(http.MethodPost, "/api/files/share", ownerToken, gin.H{
+		"folderId": docs.ID, "emails": []string{editor.Email}, "permission": models.PermissionEditor,
+	}), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/share", editorToken, gin.H{
+		"fileId": spec.ID, "emails": []string{viewer.Email}, "permission": models.PermissionViewer,
+	}), http.StatusOK, nil)
+
+	var sharing dtos.SharingResponse
+	s.expect(s.do(http.MethodGet, "/api/files/share?fileId="+spec.ID.String(), viewerToken, nil), http.StatusOK, &sharing)
+	if sharing.Owner.ID != owner.ID || sharing.Owner.Email != owner.Email || len(sharing.Grants) != 2 {
+		t.Fatalf("unexpected sharing: %+v", sharing)
+	}
+	var inherited, direct dtos.GrantResponse
+	for _, g := range sharing.Grants {
+		if g.UserID == editor.ID {
+			inherited = g
+		} else {
+			direct = g
+		}
+	}
+	if inherited.InheritedFrom == nil || *inherited.InheritedFrom != docs.ID || inherited.Email != editor.Email || inherited.GrantedByEmail != owner.Email {
+		t.Fatalf("unexpected inherited grant: %+v", inherited)
+	}
+	if direct.InheritedFrom != nil || direct.UserID != viewer.ID || direct.Permission != string(models.PermissionViewer) || direct.GrantedBy != editor.ID {
+		t.Fatalf("unexpected direct grant: %+v", direct)
+	}
+	s.expect(s.do(http.MethodGet, "/api/files/share?folderId="+docs.ID.String(), heirToken, nil), http.StatusNotFound, nil)
+
+	// Viewers cannot manage grants but may leave
+	s.expect(s.do(http.MethodPatch, "/api/files/share/"+direct.ID.String(), viewerToken, gin.H{"permission": models.PermissionEditor}), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/share/"+direct.ID.String(), editorToken, gin.H{"permission": models.PermissionEditor}), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+spec.ID.String()+"/rename", viewerToken, gin.H{"name": "spec-v2.txt"}), http.StatusOK, nil)
+	s.expect(s.do(http.MethodDelete, "/api/files/share/"+direct.ID.String(), viewerToken, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodGet, "/api/files/"+spec.ID.String()+"/content", viewerToken, nil), http.StatusNotFound, nil)
+
+	// Only the owner can hand an item over, and the new owner takes its storage charge. Emails
+	// match regardless of case, as they do when sharing
+	transfer := gin.H{"folderId": specs.ID, "email": strings.ToUpper(heir.Email)}
+	s.expect(s.do(http.MethodPost, "/api/files/share/transfer", editorToken, transfer), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/share/transfer", ownerToken, gin.H{"folderId": specs.ID, "email": "nobody@example.com"}), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/share/transfer", ownerToken, transfer), http.StatusOK, nil)
+
+	if used := s.storageUsed(owner.ID); used != 0 {
+		t.Fatalf("previous owner should be released, used %d", used)
+	}
+	if used := s.storageUsed(heir.ID); used != int64(len("the spec")) {
+		t.Fatalf("new owner should be charged, used %d", used)
+	}
+	if folders := s.listFolders(heirToken, "isTrash=false"); len(folders) != 1 || folders[0].ID != specs.ID {
+		t.Fatalf("transferred folder should be at the new owner's root: %+v", folders)
+	}
+	if files := s.listFiles(heirToken, "parentId="+specs.ID.String()); len(files) != 1 || files[0].ID != spec.ID {
+		t.Fatalf("transferred folder should keep its files: %+v", files)
+	}
+	if folders := s.listFolders(ownerToken, "isTrash=false&parentId="+docs.ID.String()); len(folders) != 0 {
+		t.Fatalf("transferred folder should leave the previous owner's tree: %+v", folders)
+	}
+	// The previous owner stays on as an editor; the old folder grant no longer reaches it
+	s.expect(s.do(http.MethodPatch, "/api/folders/"+specs.ID.String()+"/rename", ownerToken, gin.H{"name": "specifications"}), http.StatusOK, nil)
+	s.expect(s.do(http.MethodGet, "/api/files/"+spec.ID.String()+"/content", editorToken, nil), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodPost, "/api/files/share/transfer", ownerToken, gin.H{"fileId": spec.ID, "email": owner.Email}), http.StatusBadRequest, nil)
+}
+
+func TestShareLinks(t *testing.T) {
+	s := newTestServer(t)
+	_, ownerToken := s.createUser("Abby")
+	_, viewerToken := s.createUser("Ben")
+
+	album := s.createFolder(ownerToken, "album", nil)
+	photo := s.upload(ownerToken, "photo.txt", &album.ID, "pixels")
+	s.expect(s.do(http.MethodPost, "/api/files/share", ownerToken, gin.H{
+		"folderId": album.ID, "emails": []string{"ben@example.com"}, "permission": models.PermissionViewer,
+	}), http.StatusOK, nil)
+
+	s.expect(s.do(http.MethodPost, "/api/links", "", gin.H{"fileId": photo.ID}), http.StatusUnauthorized, nil)
+	s.expect(s.do(http.MethodPost, "/api/links", viewerToken, gin.H{"fileId": photo.ID}), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPost, "/api/links", ownerToken, gin.H{"fileId": photo.ID, "expiresAt": time.Now().Add(-time.Hour)}), http.StatusBadRequest, nil)
+
+	var limited dtos.ShareLinkResponse
+	s.expect(s.do(http.MethodPost, "/api/links", ownerToken, gin.H{
+		"fileId": photo.ID, "password": "hunter22", "maxDownloads": 1,
+	}), http.StatusCreated, &limited)
+	if limited.Token == "" || !limited.HasPassword || limited.MaxDownloads == nil || *limited.MaxDownloads != 1 {
+		t.Fatalf("unexpected link: %+v", limited)
+	}
+
+	// Details stay hidden until the password is given
+	var info dtos.PublicLinkResponse
+	s.expect(s.do(http.MethodGet, "/api/public/links/"+limited.Token, "", nil), http.StatusOK, &info)
+	if !info.RequiresPassword || info.Name != "" || info.Type != "file" || info.DownloadsRemaining == nil || *info.DownloadsRemaining != 1 {
+		t.Fatalf("unexpected public link info: %+v", info)
+	}
+	s.expect(s.doWithHeaders(http.MethodGet, "/api/public/links/"+limited.Token, "", map[string]string{"X-Link-Password": "hunter22"}), http.StatusOK, &info)
+	if info.Name != "photo.txt" || info.Size != int64(len("pixels")) {
+		t.Fatalf("password should reveal the file: %+v", info)
+	}
+
+	download := "/api/public/links/" + limited.Token + "/download"
+	s.expect(s.do(http.MethodPost, download, "", gin.H{"password": "wrong"}), http.StatusUnauthorized, nil)
+	w := s.do(http.MethodPost, download, "", gin.H{"password": "hunter22"})
+	s.expect(w, http.StatusOK, nil)
+	if w.Body.String() != "pixels" || !strings.Contains(w.Header().Get("Content-Disposition"), "photo.txt") {
+		t.Fatalf("unexpected download: %q %q", w.Body.String(), w.Header().Get("Content-Disposition"))
+	}
+	s.expect(s.do(http.MethodPost, download, "", gin.H{"password": "hunter22"}), http.StatusGone, nil)
+
+	var links []dtos.ShareLinkResponse
+	s.expect(s.do(http.MethodGet, "/api/links?fileId="+photo.ID.String(), ownerToken, nil), http.StatusOK, &links)
+	if len(links) != 1 || links[0].DownloadCount != 1 || links[0].AccessCount != 5 || links[0].LastAccessedAt == nil {
+		t.Fatalf("unexpected link counters: %+v", links)
+	}
+
+	// Folder links download the whole tree
+	var folderLink dtos.ShareLinkResponse
+	s.expect(s.do(http.MethodPost, "/api/links", ownerToken, gin.H{"folderId": album.ID}), http.StatusCreated, &folderLink)
+	w = s.do(http.MethodPost, "/api/public/links/"+folderLink.Token+"/download", "", nil)
+	s.expect(w, http.StatusOK, nil)
+	if entries := readZip(t, w.Body.Bytes()); entries["album/photo.txt"] != "pixels" {
+		t.Fatalf("unexpected folder link archive: %v", entries)
+	}
+
+	s.expect(s.do(http.MethodDelete, "/api/links/"+folderLink.ID.String(), viewerToken, nil), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodDelete, "/api/links/"+folderLink.ID.String(), ownerToken, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodGet, "/api/public/links/"+folderLink.Token, "", nil), http.StatusGone, nil)
+
+	var expiring dtos.ShareLinkResponse
+	s.expect(s.do(http.MethodPost, "/api/links", ownerToken, gin.H{"fileId": photo.ID, "expiresAt": time.Now().Add(time.Hour)}), http.StatusCreated, &expiring)
+	s.db.Model(&models.ShareLink{}).Where("id = ?", expiring.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	s.expect(s.do(http.MethodGet, "/api/public/links/"+expiring.Token, "", nil), http.StatusGone, nil)
+
+	// Trashing the target takes its links down with it
+	var live dtos.ShareLinkResponse
+	s.expect(s.do(http.MethodPost, "/api/links", ownerToken, gin.H{"fileId": photo.ID}), http.StatusCreated, &live)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+photo.ID.String()+"/trash", ownerToken, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodGet, "/api/public/links/"+live.Token, "", nil), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodGet, "/api/public/links/unknown", "", nil), http.StatusNotFound, nil)
+}
+
+func TestShareInvitations(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.createUser("Cara")
+	member, _ := s.createUser("Dina")
+
+	reports := s.createFolder(ownerToken, "reports", nil)
+	summary := s.upload(ownerToken, "summary.txt", &reports.ID, "numbers")
+
+	share := func(emails []string, role models.PermissionType) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/files/share", ownerToken, gin.H{"folderId": reports.ID, "emails": emails, "permission": role})
+	}
+	s.expect(share([]string{"not-an-email"}, models.PermissionViewer), http.StatusBadRequest, nil)
+
+	var result struct {
+		SharedWithCount int `json:"sharedWithCount"`
+		InvitedCount    int `json:"invitedCount"`
+	}
+	s.expect(share([]string{member.Email, "Eve@Example.com", "eve@example.com"}, models.PermissionViewer), http.StatusOK, &result)
+	if result.SharedWithCount != 1 || result.InvitedCount != 1 {
+		t.Fatalf("unexpected share result: %+v", result)
+	}
+	// Inviting again updates the pending invitation
+	s.expect(share([]string{"eve@example.com"}, models.PermissionEditor), http.StatusOK, nil)
+
+	var invitations []models.PendingInvitation
+	s.db.Find(&invitations)
+	if len(invitations) != 1 || invitations[0].Email != "eve@example.com" || invitations[0].Permission != models.PermissionEditor || invitations[0].InvitedBy != owner.ID {
+		t.Fatalf("unexpected invitations: %+v", invitations)
+	}
+
+	// Signing up converts the invitation into a grant
+	eve, eveToken := s.createUser("Eve")
+	accepted, err := repositories.NewPermissionRepository(s.db).AcceptInvitations(&eve)
+	if err != nil || accepted != 1 {
+		t.Fatalf("expected one accepted invitation, got %d: %v", accepted, err)
+	}
+	var remaining int64
+	s.db.Model(&models.PendingInvitation{}).Count(&remaining)
+	if remaining != 0 {
+		t.Fatalf("accepted invitations should be removed, %d left", remaining)
+	}
+
+	s.expect(s.do(http.MethodGet, "/api/files/"+summary.ID.String()+"/content", eveToken, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPatch, "/api/files/"+summary.ID.String()+"/rename", eveToken, gin.H{"name": "q3.txt"}), http.StatusOK, nil)
+	var shared []dtos.SharedFolderResponse
+	s.expect(s.do(http.MethodGet, "/api/folders/shared-with-me", eveToken, nil), http.StatusOK, &shared)
+	if len(shared) != 1 || shared[0].ID != reports.ID || shared[0].SharedBy != owner.FirstName {
+		t.Fatalf("unexpected shared folders after sign up: %+v", shared)
+	}
+
+	// Deleting the item drops invitations to it
+	s.expect(share([]string{"fay@example.com"}, models.PermissionViewer), http.StatusOK, nil)
+	s.expect(s.do(http.MethodDelete, "/api/folders/"+reports.ID.String(), ownerToken, nil), http.StatusOK, nil)
+	s.db.Model(&models.PendingInvitation{}).Count(&remaining)
+	if remaining != 0 {
+		t.Fatalf("invitations to a deleted folder should go with it, %d left", remaining)
+	}
+}
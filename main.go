@@ -29,6 +29,7 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"gorm.io/gorm"
 )
 
 func loadEnv() {
@@ -120,44 +121,9 @@ func RateLimitMiddleware(limiter *IPlimiter) gin.HandlerFunc {
 	}
 }
 
-func main() {
-	loadEnv()
-	db := db.InitDB()
-
-	s3Client := storage.InitS3()
-	bucketName := os.Getenv("S3_BUCKET")
-
-	env := os.Getenv("GO_ENV")
-	if env == "" {
-		env = "development"
-	}
-
-	cronJob := cron.New(cron.WithSeconds())
-	cronJob.AddFunc("0 0 0 * * *", func() {
-		log.Println("--- Starting Storage Sync Job ---")
-
-		err := worker.SyncUserStorage(db)
-
-		if err != nil {
-			log.Printf("Storage Sync failed %v", err)
-		}
-	})
-
-	cronJob.AddFunc("0 0 */6 * * *", func() {
-		log.Println("--- Starting S3 Orphaned Cleanup Job ---")
-		worker.CleanupOrphanedS3Objects(db, s3Client, bucketName)
-	})
-
-	// 0 * * * * * -> every minute for testing
-	cronJob.AddFunc("0 0 2 * * *", func() {
-		log.Println("--- Starting Daily Permanent Purge ---")
-		worker.PurgeExpiredDeletedFiles(db, s3Client, bucketName)
-	})
-
-	cronJob.Start()
-
-	controllers.StartCacheCleaner()
-
+// setupRouter wires middleware, repositories, controllers and routes.
+// It is kept separate from main so the integration tests can boot the same router.
+func setupRouter(db *gorm.DB, store storage.ObjectStore, env string) *gin.Engine {
 	var allowedOrigins []string
 	if env == "production" {
 		allowedOrigins = []string{
@@ -174,9 +140,6 @@ func main() {
 	router.RedirectFixedPath = false
 	router.SetTrustedProxies(nil)
 
-	tp, _ := initTracer()
-	defer tp.Shutdown(context.Background())
-
 	router.Use(otelgin.Middleware("filedrive-backend"))
 
 	reg := prometheus.NewRegistry()
@@ -186,6 +149,7 @@ func main() {
 	})
 
 	router.Use(middleware.PrometheusMiddleware(reg))
+	worker.RegisterMetrics(reg)
 	router.Use(otelgin.Middleware("filedrive-backend"))
 
 	router.GET("/ping", func(c *gin.Context) {
@@ -195,11 +159,15 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Range", "If-None-Match", "If-Range", controllers.LinkPasswordHeader},
+		ExposeHeaders:    []string{"Content-Length", "ETag", "Content-Range", "Accept-Ranges", "Content-Disposition"},
 		AllowCredentials: true,
 	}))
 
+	if localStore, ok := store.(*storage.LocalStore); ok {
+		routes.LocalStorageRoutes(router, &controllers.LocalStorageController{Store: localStore})
+	}
+
 	limiter := NewIPRateLimiter(5, 100)
 
 	api := router.Group("/api")
@@ -209,27 +177,98 @@ func main() {
 	userController := &controllers.UserController{Repo: userRepo}
 	routes.RegisteredUserRoutes(api, userController)
 
+	permissionRepo := repositories.NewPermissionRepository(db)
+
 	folderRepo := repositories.NewFolderRepository(db)
 	folderController := &controllers.FolderController{
-		Repo:     folderRepo,
-		S3Client: s3Client,
-		Bucket:   bucketName,
+		Repo:           folderRepo,
+		PermissionRepo: permissionRepo,
+		Store:          store,
+		Bucket:         store.Bucket(),
 	}
 	routes.FolderRoutes(api, folderController)
 
 	fileRepo := repositories.NewFileRepository(db)
 	fileController := &controllers.FileController{
-		Repo:       fileRepo,
-		FolderRepo: folderRepo,
-		UserRepo:   userRepo,
-		S3Client:   s3Client,
-		Bucket:     bucketName,
+		Repo:           fileRepo,
+		FolderRepo:     folderRepo,
+		UserRepo:       userRepo,
+		PermissionRepo: permissionRepo,
+		Store:          store,
+		Bucket:         store.Bucket(),
 	}
 	routes.FileRoutes(api, fileController)
 
-	authController := controllers.NewAuthController(userRepo)
+	shareLinkController := &controllers.ShareLinkController{
+		Repo:           repositories.NewShareLinkRepository(db),
+		PermissionRepo: permissionRepo,
+		FolderRepo:     folderRepo,
+		Store:          store,
+	}
+	routes.ShareLinkRoutes(api, shareLinkController)
+
+	searchController := &controllers.SearchController{Repo: repositories.NewSearchRepository(db)}
+	routes.SearchRoutes(api, searchController)
+
+	authController := controllers.NewAuthController(userRepo, permissionRepo)
 	routes.AuthRoutes(api, authController)
 
+	return router
+}
+
+func main() {
+	loadEnv()
+	db := db.InitDB()
+
+	bucketName := os.Getenv("S3_BUCKET")
+	store := storage.InitObjectStore(bucketName)
+
+	env := os.Getenv("GO_ENV")
+	if env == "" {
+		env = "development"
+	}
+
+	cronJob := cron.New(cron.WithSeconds())
+	cronJob.AddFunc("0 0 0 * * *", func() {
+		log.Println("--- Starting Storage Sync Job ---")
+
+		err := worker.SyncUserStorage(db)
+
+		if err != nil {
+			log.Printf("Storage Sync failed %v", err)
+		}
+	})
+
+	cronJob.AddFunc("0 0 */6 * * *", func() {
+		log.Println("--- Starting S3 Orphaned Cleanup Job ---")
+		worker.CleanupOrphanedS3Objects(db, store, bucketName)
+	})
+
+	// 0 * * * * * -> every minute for testing
+	cronJob.AddFunc("0 0 2 * * *", func() {
+		log.Println("--- Starting Daily Permanent Purge ---")
+		worker.PurgeExpiredDeletedFiles(db, store, bucketName)
+	})
+
+	cronJob.AddFunc("0 30 * * * *", func() {
+		log.Println("--- Starting Stale Upload Janitor ---")
+		worker.AbortStaleUploads(db, store, bucketName)
+	})
+
+	cronJob.AddFunc("0 15 * * * *", func() {
+		log.Println("--- Starting Stalled Upload Verification ---")
+		worker.VerifyStalledUploads(db, store, bucketName)
+	})
+
+	cronJob.Start()
+
+	controllers.StartCacheCleaner()
+
+	tp, _ := initTracer()
+	defer tp.Shutdown(context.Background())
+
+	router := setupRouter(db, store, env)
+
 	for _, route := range router.Routes() {
 		fmt.Printf("Method: %s | Path: %s\n", route.Method, route.Path)
 	}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blob is a stored object shared by every file whose content hashes to SHA256.
+// Files, file versions and deleted files point at it through their ObjectKey.
+type Blob struct {
+	ID uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+
+	SHA256     string `gorm:"column:sha256;type:varchar(64);uniqueIndex;not null" json:"sha256"`
+	BucketName string `gorm:"type:varchar(255);not null" json:"bucketName"`
+	ObjectKey  string `gorm:"type:text;uniqueIndex;not null" json:"objectKey"`
+	Size       int64  `gorm:"not null" json:"size"`
+	// Number of File, FileVersion and DeletedFile rows using ObjectKey; the object goes at zero
+	RefCount int `gorm:"not null" json:"refCount"`
+
+	CreatedAt time.Time `gorm:"not null;default:now()" json:"createdAt"`
+}
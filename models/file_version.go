@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileVersion is a previous revision of a File. The current revision lives on the File row itself;
+// uploading into an existing name pushes the old one here.
+type FileVersion struct {
+	ID uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+
+	FileID        uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_file_version"`
+	File          *File     `gorm:"foreignKey:FileID;references:ID;constraint:OnDelete:CASCADE;"`
+	VersionNumber int       `gorm:"not null;uniqueIndex:idx_file_version"`
+
+	Size       int64   `gorm:"not null;default:0"`
+	MimeType   *string `gorm:"type:varchar(255)"`
+	BucketName string  `gorm:"type:varchar(255);not null"`
+	ObjectKey  string  `gorm:"type:text;index;not null"`
+	ETag       *string `gorm:"type:varchar(255)"`
+	// Hex SHA-256 and declared checksum of this revision's content, as on File
+	SHA256            *string `gorm:"column:sha256;type:varchar(64)"`
+	ChecksumAlgorithm *string `gorm:"type:varchar(16)"`
+	Checksum          *string `gorm:"type:varchar(64)"`
+
+	CreatedAt time.Time `gorm:"not null;default:now()"`
+}
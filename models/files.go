@@ -18,11 +18,16 @@ type File struct {
 	Size     int64   `gorm:"not null;default:0;index:idx_files_storage_calc" json:"size"`
 	MimeType *string `gorm:"type:varchar(255)" json:"mimeType"`
 
-	// S3 metadata
+	// S3 metadata. ObjectKey is not unique: deduplicated files share their Blob's key
 	BucketName string  `gorm:"type:varchar(255);not null" json:"bucketName"`
-	ObjectKey  string  `gorm:"type:text;uniqueIndex;not null" json:"objectKey"`
+	ObjectKey  string  `gorm:"type:text;index;not null" json:"objectKey"`
 	S3UploadID *string `gorm:"type:text" json:"s3UploadId"`
 	ETag       *string `gorm:"type:varchar(255)" json:"eTag"`
+	// Hex SHA-256 of the content, when the client supplied one at completion
+	SHA256 *string `gorm:"column:sha256;type:varchar(64)" json:"sha256"`
+	// Whole-file checksum declared at initiate, base64 as in S3's x-amz-checksum-* headers
+	ChecksumAlgorithm *string `gorm:"type:varchar(16)" json:"checksumAlgorithm"`
+	Checksum          *string `gorm:"type:varchar(64)" json:"checksum"`
 
 	// Upload tracking
 	UploadStatus        string `gorm:"type:varchar(20);default:'pending';index:idx_files_storage_calc" json:"uploadStatus"`
@@ -30,6 +35,8 @@ type File struct {
 	UploadedChunks      int    `gorm:"default:0" json:"uploadedChunks"`
 	UploadedPartNumbers int    `gorm:"column:uploaded_part_numbers" json:"uploadedPartNumbers"`
 	IsDeleted           bool   `gorm:"default:false;index:idx_files_storage_calc" json:"isDeleted"`
+	// Number of the current revision; older ones are kept as FileVersion rows
+	Version int `gorm:"not null;default:1" json:"version"`
 
 	Folder *Folder `gorm:"foreignKey:FolderID;references:ID;constraint:OnDelete:CASCADE;" json:"-"`
 
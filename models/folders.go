@@ -21,6 +21,8 @@ type Folder struct {
 	Files   []File   `gorm:"foreignKey:FolderID;references:ID"`
 
 	IsDeleted bool `gorm:"default:false"`
+	// Set for every folder and file trashed together, so restore only brings those back
+	DeletedAt *time.Time `gorm:"index"`
 
 	CreatedAt time.Time `gorm:"not null;default:now()"`
 	UpdatedAt time.Time `gorm:"not null;default:now()"`
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PendingInvitation is a share with an email that has no account yet. It becomes a
+// ResourcePermission when someone signs in with that email.
+type PendingInvitation struct {
+	ID uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+
+	// Lower-cased
+	Email string `gorm:"type:varchar(255);not null;uniqueIndex:idx_invite_file;uniqueIndex:idx_invite_folder"`
+
+	FileID   *uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_invite_file,where:file_id IS NOT NULL"`
+	File     *File      `gorm:"foreignKey:FileID;references:ID;constraint:OnDelete:CASCADE;"`
+	FolderID *uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_invite_folder,where:folder_id IS NOT NULL"`
+	Folder   *Folder    `gorm:"foreignKey:FolderID;references:ID;constraint:OnDelete:CASCADE;"`
+
+	InvitedBy     uuid.UUID `gorm:"type:uuid;not null"`
+	InvitedByUser *Users    `gorm:"foreignKey:InvitedBy;constraint:OnDelete:CASCADE;"`
+
+	Permission PermissionType `gorm:"type:permission_type;not null;default:'viewer'"`
+
+	CreatedAt time.Time `gorm:"not null;default:now()"`
+}
@@ -13,5 +13,8 @@ type PendingUpload struct {
     FileName  string    `json:"fileName"`
     ParentID  *uuid.UUID `gorm:"type:uuid"`
     TotalParts int      `json:"totalParts"`
+    // Per-part checksums declared at initiate, comma separated in part order
+    ChecksumAlgorithm string `gorm:"type:varchar(16)"`
+    PartChecksums     string `gorm:"type:text"`
     gorm.Model
 }
\ No newline at end of file
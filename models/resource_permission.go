@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql/driver"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,8 +16,26 @@ const (
 	PermissionOwner  PermissionType = "owner"
 )
 
+var permissionRank = map[PermissionType]int{
+	PermissionViewer: 1,
+	PermissionEditor: 2,
+	PermissionOwner:  3,
+}
+
+// Includes reports whether self grants at least everything other does: owner > editor > viewer.
+func (self PermissionType) Includes(other PermissionType) bool {
+	return permissionRank[self] >= permissionRank[other]
+}
+
 func (self *PermissionType) Scan(value interface{}) error {
-	*self = PermissionType(value.([]byte))
+	switch v := value.(type) {
+	case []byte:
+		*self = PermissionType(v)
+	case string:
+		*self = PermissionType(v)
+	default:
+		return fmt.Errorf("cannot scan %T into PermissionType", value)
+	}
 	return nil
 }
 
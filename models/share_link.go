@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink lets anyone holding Token download a file or folder without an account.
+type ShareLink struct {
+	ID    uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Token string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+
+	FileID   *uuid.UUID `gorm:"type:uuid;index"`
+	File     *File      `gorm:"foreignKey:FileID;references:ID;constraint:OnDelete:CASCADE;"`
+	FolderID *uuid.UUID `gorm:"type:uuid;index"`
+	Folder   *Folder    `gorm:"foreignKey:FolderID;references:ID;constraint:OnDelete:CASCADE;"`
+
+	CreatedBy     uuid.UUID `gorm:"type:uuid;not null"`
+	CreatedByUser *Users    `gorm:"foreignKey:CreatedBy;constraint:OnDelete:CASCADE;"`
+
+	ExpiresAt    *time.Time
+	PasswordHash *string `gorm:"type:varchar(72)"` // bcrypt
+	MaxDownloads *int
+
+	// Counters: every resolution of the token, and the downloads it served
+	AccessCount    int `gorm:"not null;default:0"`
+	DownloadCount  int `gorm:"not null;default:0"`
+	LastAccessedAt *time.Time
+
+	RevokedAt *time.Time
+	CreatedAt time.Time `gorm:"not null;default:now()"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashRetentionPolicy overrides the global trash retention (TRASH_RETENTION_DAYS) for one user.
+type TrashRetentionPolicy struct {
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	User   *Users    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+
+	RetentionDays int `gorm:"not null"`
+	// With AutoEmpty off the purge worker leaves this user's trash alone
+	AutoEmpty bool `gorm:"not null"`
+
+	UpdatedAt time.Time `gorm:"not null;default:now()"`
+}
@@ -0,0 +1,122 @@
+package repositories
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/models"
+	"gorm.io/gorm"
+)
+
+// Action is something a user can do to a file or folder. Every controller authorizes through
+// PermissionRepository.AuthorizeFile or AuthorizeFolder before touching a resource.
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionRename Action = "rename"
+	// Writing content: creating, uploading, moving or copying into a folder, or changing a file's versions
+	ActionUpload Action = "upload"
+	ActionTrash  Action = "trash"
+	// Sharing, and changing or revoking the grants on an item
+	ActionReshare Action = "reshare"
+	// Anything done to trashed content, permanent deletion and restore alike
+	ActionDelete   Action = "delete"
+	ActionTransfer Action = "transfer"
+)
+
+// minimumRole is the weakest role allowed to take each action.
+var minimumRole = map[Action]models.PermissionType{
+	ActionRead:     models.PermissionViewer,
+	ActionRename:   models.PermissionEditor,
+	ActionUpload:   models.PermissionEditor,
+	ActionTrash:    models.PermissionEditor,
+	ActionReshare:  models.PermissionEditor,
+	ActionDelete:   models.PermissionOwner,
+	ActionTransfer: models.PermissionOwner,
+}
+
+// Allows reports whether role may take action.
+func Allows(role models.PermissionType, action Action) bool {
+	minimum, ok := minimumRole[action]
+	return ok && role.Includes(minimum)
+}
+
+// AuthorizeFile loads a file and checks userID may take action on it. gorm.ErrRecordNotFound means
+// the file does not exist or the user has no role on it; ErrForbidden means their role is too weak.
+// Trashed files are only found for ActionDelete.
+func (r *PermissionRepository) AuthorizeFile(fileID uuid.UUID, userID uuid.UUID, action Action) (models.File, models.PermissionType, error) {
+	var file models.File
+	query := r.DB.Where("id = ? AND is_deleted = ?", fileID, false)
+	if action == ActionDelete {
+		query = r.DB.Unscoped().Where("id = ?", fileID)
+	}
+	if err := query.First(&file).Error; err != nil {
+		return models.File{}, "", err
+	}
+
+	role, err := r.FilePermission(&file, userID)
+	if err != nil {
+		return models.File{}, "", err
+	}
+	if !Allows(role, action) {
+		return models.File{}, role, ErrForbidden
+	}
+	return file, role, nil
+}
+
+// AuthorizeFolder is AuthorizeFile for folders.
+func (r *PermissionRepository) AuthorizeFolder(folderID uuid.UUID, userID uuid.UUID, action Action) (models.Folder, models.PermissionType, error) {
+	var folder models.Folder
+	query := r.DB.Where("id = ?", folderID)
+	if action != ActionDelete {
+		query = query.Where("is_deleted = ?", false)
+	}
+	if err := query.First(&folder).Error; err != nil {
+		return models.Folder{}, "", err
+	}
+
+	role, err := r.FolderPermission(&folder, userID)
+	if err != nil {
+		return models.Folder{}, "", err
+	}
+	if !Allows(role, action) {
+		return models.Folder{}, role, ErrForbidden
+	}
+	return folder, role, nil
+}
+
+// AuthorizeFileRemoval authorizes the trash endpoint, which trashes a live file (ActionTrash)
+// and permanently deletes a trashed one (ActionDelete).
+func (r *PermissionRepository) AuthorizeFileRemoval(fileID uuid.UUID, userID uuid.UUID) (models.File, error) {
+	file, _, err := r.AuthorizeFile(fileID, userID, ActionTrash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		file, _, err = r.AuthorizeFile(fileID, userID, ActionDelete)
+	}
+	return file, err
+}
+
+// AuthorizeFolderRemoval is AuthorizeFileRemoval for folders.
+func (r *PermissionRepository) AuthorizeFolderRemoval(folderID uuid.UUID, userID uuid.UUID) (models.Folder, error) {
+	folder, _, err := r.AuthorizeFolder(folderID, userID, ActionTrash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		folder, _, err = r.AuthorizeFolder(folderID, userID, ActionDelete)
+	}
+	return folder, err
+}
+
+// AuthorizeDestination checks userID may put content into folderID and returns whose tree it
+// lands in: the caller's own for the root, otherwise the folder owner's, who is also charged for it.
+func (r *PermissionRepository) AuthorizeDestination(folderID *uuid.UUID, userID uuid.UUID) (uuid.UUID, error) {
+	if folderID == nil {
+		return userID, nil
+	}
+	folder, _, err := r.AuthorizeFolder(*folderID, userID, ActionUpload)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, ErrDestinationNotFound
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return folder.OwnerID, nil
+}
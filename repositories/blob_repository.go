@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// attachBlob deduplicates a just-completed upload. If content with the same hash is already
+// stored the file is pointed at that blob and the upload's own key is returned for the caller
+// to delete once the transaction commits; otherwise the upload's object becomes a new blob.
+func attachBlob(tx *gorm.DB, file *models.File, sha256 string) (string, error) {
+	blob := models.Blob{
+		SHA256:     sha256,
+		BucketName: file.BucketName,
+		ObjectKey:  file.ObjectKey,
+		Size:       file.Size,
+		RefCount:   1,
+	}
+	result := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sha256"}},
+		DoNothing: true,
+	}).Create(&blob)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 1 {
+		file.SHA256 = &sha256
+		return "", tx.Model(file).Update("sha256", sha256).Error
+	}
+
+	var existing models.Blob
+	if err := tx.Where("sha256 = ?", sha256).First(&existing).Error; err != nil {
+		return "", err
+	}
+	if err := tx.Model(&existing).UpdateColumn("ref_count", gorm.Expr("ref_count + 1")).Error; err != nil {
+		return "", err
+	}
+
+	uploadKey := file.ObjectKey
+	if err := tx.Model(file).Updates(map[string]interface{}{
+		"object_key": existing.ObjectKey,
+		"sha256":     sha256,
+	}).Error; err != nil {
+		return "", err
+	}
+	file.ObjectKey = existing.ObjectKey
+	file.SHA256 = &sha256
+	return uploadKey, nil
+}
+
+// retainBlob adds a reference to the blob stored at key and reports whether there was one.
+func retainBlob(tx *gorm.DB, key string) (bool, error) {
+	result := tx.Model(&models.Blob{}).Where("object_key = ?", key).
+		UpdateColumn("ref_count", gorm.Expr("ref_count + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+func isBlobKey(db *gorm.DB, key string) (bool, error) {
+	var count int64
+	err := db.Model(&models.Blob{}).Where("object_key = ?", key).Count(&count).Error
+	return count > 0, err
+}
+
+// ReleaseObjects drops one reference for every occurrence of a key. It returns the keys whose
+// objects should now be deleted: those not shared through a blob, and blobs left unreferenced.
+func ReleaseObjects(tx *gorm.DB, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	counts := make(map[string]int, len(keys))
+	var unique []string
+	for _, key := range keys {
+		if counts[key] == 0 {
+			unique = append(unique, key)
+		}
+		counts[key]++
+	}
+
+	var blobs []models.Blob
+	if err := tx.Where("object_key IN ?", unique).Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+	shared := make(map[string]bool, len(blobs))
+
+	var orphaned []string
+	for _, blob := range blobs {
+		shared[blob.ObjectKey] = true
+		err := tx.Model(&models.Blob{}).Where("id = ?", blob.ID).
+			UpdateColumn("ref_count", gorm.Expr("ref_count - ?", counts[blob.ObjectKey])).Error
+		if err != nil {
+			return nil, err
+		}
+		result := tx.Where("id = ? AND ref_count <= 0", blob.ID).Delete(&models.Blob{})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected > 0 {
+			orphaned = append(orphaned, blob.ObjectKey)
+		}
+	}
+
+	for _, key := range unique {
+		if !shared[key] {
+			orphaned = append(orphaned, key)
+		}
+	}
+	return orphaned, nil
+}
+
+// DeleteObjectsOrQueue deletes keys from storage. Any that fail are queued in FailedS3Deletion
+// for CleanupOrphanedS3Objects to retry.
+func DeleteObjectsOrQueue(db *gorm.DB, store storage.ObjectStore, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	deleted, _ := store.DeleteObjects(context.TODO(), keys)
+	done := make(map[string]bool, len(deleted))
+	for _, key := range deleted {
+		done[key] = true
+	}
+
+	var failures []models.FailedS3Deletion
+	for _, key := range keys {
+		if !done[key] {
+			failures = append(failures, models.FailedS3Deletion{BucketName: store.Bucket(), ObjectKey: key})
+		}
+	}
+	if len(failures) > 0 {
+		_ = db.Create(&failures).Error
+	}
+}
@@ -0,0 +1,13 @@
+package repositories
+
+import "errors"
+
+var (
+	ErrDestinationNotFound = errors.New("destination folder not found")
+	ErrMoveIntoDescendant  = errors.New("cannot move a folder into itself or one of its subfolders")
+	ErrInsufficientStorage = errors.New("not enough space")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrForbidden           = errors.New("your role does not allow this action")
+	ErrCrossOwnerMove      = errors.New("items can only be moved within their owner's folders")
+	ErrAlreadyOwner        = errors.New("the user already owns this item")
+)
@@ -3,16 +3,13 @@ package repositories
 import (
 	"context"
 	"fmt"
-	"net/url"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 	"github.com/richeek45/filedrive/dtos"
 	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/storage"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -37,24 +34,6 @@ GROUP BY f.id;
 
 */
 
-func (r *FileRepository) GetFileByID(fileId uuid.UUID, userId uuid.UUID) (models.File, error) {
-	var file models.File
-
-	query := r.DB.Table("file").Select("file.*").
-		Joins("LEFT JOIN users ON users.id = ?", userId).
-		Joins("LEFT JOIN resource_permission ON resource_permission.user_id = users.id AND resource_permission.file_id = file.id").
-		Where("file.id = ? AND file.is_deleted = ?", fileId, false).
-		Where("file.owner_id = ? OR resource_permission.user_id = ?", userId, userId).
-		Group("file.id")
-
-	err := query.First(&file).Error
-	if err != nil {
-		return models.File{}, err
-	}
-
-	return file, nil
-}
-
 func (r *FileRepository) SharedFilesByUserID(userID uuid.UUID) ([]dtos.SharedFileResponse, error) {
 	var files []dtos.SharedFileResponse
 
@@ -72,7 +51,7 @@ func (r *FileRepository) SharedFilesByUserID(userID uuid.UUID) ([]dtos.SharedFil
 	return files, nil
 }
 
-func (r *FileRepository) DeleteFile(fileID uuid.UUID, userID uuid.UUID, S3Client *s3.Client) error {
+func (r *FileRepository) DeleteFile(fileID uuid.UUID, userID uuid.UUID, store storage.ObjectStore) error {
 	var file models.File
 
 	err := r.DB.Unscoped().Where("id = ? AND owner_id = ?", fileID, userID).First(&file).Error
@@ -81,7 +60,7 @@ func (r *FileRepository) DeleteFile(fileID uuid.UUID, userID uuid.UUID, S3Client
 	}
 
 	if file.IsDeleted {
-		return r.PermanentDeleteFile(&file, S3Client)
+		return r.PermanentDeleteFile(&file, store)
 	}
 
 	return r.SoftDeleteFile(&file)
@@ -99,25 +78,31 @@ func (r *FileRepository) SoftDeleteFile(file *models.File) error {
 	})
 }
 
-func (r *FileRepository) PermanentDeleteFile(file *models.File, S3Client *s3.Client) error {
-	encodedKey := url.PathEscape(file.ObjectKey)
-	copySource := file.BucketName + "/" + encodedKey
-	trashKey := "delete/" + file.ObjectKey
-	_, err := S3Client.CopyObject(context.TODO(), &s3.CopyObjectInput{
-		Bucket:     aws.String(file.BucketName),
-		CopySource: aws.String(copySource),
-		Key:        aws.String(trashKey),
-	})
+func (r *FileRepository) PermanentDeleteFile(file *models.File, store storage.ObjectStore) error {
+	shared, err := isBlobKey(r.DB, file.ObjectKey)
 	if err != nil {
-		return fmt.Errorf("failed to copy to delete folder: %w", err)
+		return err
 	}
 
-	_, err = S3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(file.BucketName),
-		Key:    aws.String(file.ObjectKey),
-	})
+	// A deduplicated object stays where it is; the DeletedFile row takes over the file's reference
+	trashKey := file.ObjectKey
+	if !shared {
+		trashKey = "delete/" + file.ObjectKey
+		err = store.CopyObject(context.TODO(), file.ObjectKey, trashKey)
+		if err != nil {
+			return fmt.Errorf("failed to copy to delete folder: %w", err)
+		}
+
+		err = store.DeleteObject(context.TODO(), file.ObjectKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete from S3: %w", err)
+		}
+	}
+
+	// Old versions are not kept in the delete/ area, so they go for good
+	versionBytes, err := deleteAllVersions(r.DB, []uuid.UUID{file.ID}, store)
 	if err != nil {
-		return fmt.Errorf("failed to delete from S3: %w", err)
+		return err
 	}
 
 	return r.DB.Transaction(func(tx *gorm.DB) error {
@@ -142,11 +127,11 @@ func (r *FileRepository) PermanentDeleteFile(file *models.File, S3Client *s3.Cli
 			return fmt.Errorf("failed to purge from DB: %w", err)
 		}
 		return tx.Model(&models.Users{}).Where("id = ?", file.OwnerID).
-			UpdateColumn("storage_used", gorm.Expr("storage_used - ?", file.Size)).Error
+			UpdateColumn("storage_used", gorm.Expr("storage_used - ?", file.Size+versionBytes)).Error
 	})
 }
 
-func (r *FileRepository) RestoreFileById(fileId uuid.UUID, userId uuid.UUID, S3Client *s3.Client) error {
+func (r *FileRepository) RestoreFileById(fileId uuid.UUID, userId uuid.UUID, store storage.ObjectStore) error {
 	var file models.File
 
 	err := r.DB.Unscoped().Where("id = ? AND owner_id = ?", fileId, userId).First(&file).Error
@@ -167,9 +152,20 @@ func (r *FileRepository) RestoreFileById(fileId uuid.UUID, userId uuid.UUID, S3C
 	return nil
 }
 
-func (r *FileRepository) RestoreDeletedFiles(userId uuid.UUID, S3Client *s3.Client) error {
+func (r *FileRepository) RestoreDeletedFiles(userId uuid.UUID, store storage.ObjectStore) error {
+	policy, err := trashPolicy(r.DB, userId)
+	if err != nil {
+		return err
+	}
+
+	// Copies past the retention window are due for the purge worker and may already be gone
+	query := r.DB.Where("owner_id = ?", userId)
+	if policy.AutoEmpty {
+		query = query.Where("deleted_at >= ?", time.Now().AddDate(0, 0, -policy.RetentionDays))
+	}
+
 	var deletedFiles []models.DeletedFile
-	if err := r.DB.Where("owner_id = ?", userId).Find(&deletedFiles).Error; err != nil {
+	if err := query.Find(&deletedFiles).Error; err != nil {
 		return err
 	}
 	if len(deletedFiles) == 0 {
@@ -179,7 +175,7 @@ func (r *FileRepository) RestoreDeletedFiles(userId uuid.UUID, S3Client *s3.Clie
 	var (
 		mu                sync.Mutex
 		filesToRestore    []models.File
-		s3KeysToDelete    []types.ObjectIdentifier
+		s3KeysToDelete    []string
 		totalRestoredSize int64
 	)
 
@@ -190,23 +186,22 @@ func (r *FileRepository) RestoreDeletedFiles(userId uuid.UUID, S3Client *s3.Clie
 	for w := 1; w < maxWorkers; w++ {
 		go func() {
 			for f := range jobs {
-				encodedKey := url.PathEscape(f.ObjectKey)
-				copySource := f.BucketName + "/" + encodedKey
-
-				_, err := S3Client.CopyObject(context.TODO(), &s3.CopyObjectInput{
-					Bucket:     aws.String(f.BucketName),
-					CopySource: aws.String(copySource),
-					Key:        aws.String(f.OriginalKey),
-				})
-
-				if err != nil {
-					results <- fmt.Errorf("S3 Copy failed for %s: %w", f.Name, err)
-					continue
+				// Deduplicated files were never moved under delete/
+				moved := f.ObjectKey != f.OriginalKey
+				if moved {
+					err := store.CopyObject(context.TODO(), f.ObjectKey, f.OriginalKey)
+
+					if err != nil {
+						results <- fmt.Errorf("S3 Copy failed for %s: %w", f.Name, err)
+						continue
+					}
 				}
 
 				mu.Lock()
 
-				s3KeysToDelete = append(s3KeysToDelete, types.ObjectIdentifier{Key: aws.String(f.ObjectKey)})
+				if moved {
+					s3KeysToDelete = append(s3KeysToDelete, f.ObjectKey)
+				}
 				filesToRestore = append(filesToRestore, models.File{
 					ID: f.OriginalFileID, Name: f.Name, OwnerID: f.OwnerID,
 					FolderID: f.FolderID, Size: f.Size, MimeType: f.MimeType,
@@ -230,11 +225,11 @@ func (r *FileRepository) RestoreDeletedFiles(userId uuid.UUID, S3Client *s3.Clie
 		}
 	}
 
-	err := r.DB.Transaction(func(tx *gorm.DB) error {
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&filesToRestore).Error; err != nil {
 			return err
 		}
-		if err := tx.Where("owner_id = ?", userId).Delete(&models.DeletedFile{}).Error; err != nil {
+		if err := tx.Delete(&deletedFiles).Error; err != nil {
 			return err
 		}
 		return tx.Model(&models.Users{}).Where("id = ?", userId).
@@ -244,17 +239,17 @@ func (r *FileRepository) RestoreDeletedFiles(userId uuid.UUID, S3Client *s3.Clie
 		return err
 	}
 
-	_, err = S3Client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
-		Bucket: aws.String(deletedFiles[0].BucketName),
-		Delete: &types.Delete{Objects: s3KeysToDelete},
-	})
+	if len(s3KeysToDelete) == 0 {
+		return nil
+	}
+	_, err = store.DeleteObjects(context.TODO(), s3KeysToDelete)
 
 	if err != nil {
 		var failures []models.FailedS3Deletion
-		for _, obj := range s3KeysToDelete {
+		for _, key := range s3KeysToDelete {
 			failures = append(failures, models.FailedS3Deletion{
 				BucketName: deletedFiles[0].BucketName,
-				ObjectKey:  *obj.Key,
+				ObjectKey:  key,
 			})
 		}
 		_ = r.DB.Create(&failures).Error
@@ -264,7 +259,84 @@ func (r *FileRepository) RestoreDeletedFiles(userId uuid.UUID, S3Client *s3.Clie
 	return nil
 }
 
-func (r *FileRepository) GetFiles(userId uuid.UUID, folderID *uuid.UUID, isTrash bool) ([]models.File, error) {
+type EmptyTrashResult struct {
+	Files          int64
+	Folders        int64
+	BytesReclaimed int64
+}
+
+// EmptyTrash permanently deletes everything in the user's trash. Like deleting items one by
+// one, completed files keep a delete/ copy that RestoreDeletedFiles can bring back.
+func (r *FileRepository) EmptyTrash(userID uuid.UUID, store storage.ObjectStore) (EmptyTrashResult, error) {
+	var result EmptyTrashResult
+
+	var before models.Users
+	if err := r.DB.Select("storage_used").First(&before, "id = ?", userID).Error; err != nil {
+		return result, err
+	}
+	if err := r.DB.Unscoped().Model(&models.File{}).
+		Where("owner_id = ? AND is_deleted = ?", userID, true).
+		Count(&result.Files).Error; err != nil {
+		return result, err
+	}
+
+	// Only the top of each trashed subtree; PermanentDeleteFolder takes care of the rest
+	var folders []models.Folder
+	err := r.DB.Where("owner_id = ? AND is_deleted = ?", userID, true).
+		Where("parent_id IS NULL OR parent_id NOT IN (SELECT id FROM folder WHERE is_deleted = ?)", true).
+		Find(&folders).Error
+	if err != nil {
+		return result, err
+	}
+	folderRepo := NewFolderRepository(r.DB)
+	for i := range folders {
+		subtree, err := folderRepo.subtreeFolderIDs(r.DB, folders[i].ID)
+		if err != nil {
+			return result, err
+		}
+		if err := folderRepo.PermanentDeleteFolder(&folders[i], store); err != nil {
+			return result, err
+		}
+		result.Folders += int64(len(subtree))
+	}
+
+	var files []models.File
+	if err := r.DB.Unscoped().Where("owner_id = ? AND is_deleted = ?", userID, true).Find(&files).Error; err != nil {
+		return result, err
+	}
+	for i := range files {
+		file := &files[i]
+		if file.UploadStatus != "completed" {
+			err := r.DB.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Unscoped().Delete(file).Error; err != nil {
+					return err
+				}
+				return tx.Where("s3_key = ?", file.ObjectKey).Delete(&models.PendingUpload{}).Error
+			})
+			if err != nil {
+				return result, err
+			}
+			// Uploads that failed verification left an assembled object behind
+			DeleteObjectsOrQueue(r.DB, store, []string{file.ObjectKey})
+			continue
+		}
+		if err := r.PermanentDeleteFile(file, store); err != nil {
+			return result, err
+		}
+	}
+
+	var after models.Users
+	if err := r.DB.Select("storage_used").First(&after, "id = ?", userID).Error; err != nil {
+		return result, err
+	}
+	result.BytesReclaimed = before.StorageUsed - after.StorageUsed
+	return result, nil
+}
+
+// GetFiles lists one page of a folder (or the trash) in the order given by opts and returns
+// the cursor for the next page, empty on the last one.
+func (r *FileRepository) GetFiles(userId uuid.UUID, folderID *uuid.UUID, isTrash bool, opts ListOptions) ([]models.File, string, error) {
+	opts = opts.normalized()
 	var files []models.File
 	fmt.Println(isTrash, folderID, userId)
 	query := r.DB.Unscoped().Where("owner_id = ? AND is_deleted = ?", userId, isTrash)
@@ -279,22 +351,34 @@ func (r *FileRepository) GetFiles(userId uuid.UUID, folderID *uuid.UUID, isTrash
 	} else {
 		if folderID != nil {
 			query = query.Where("folder_id = ?", *folderID)
+		} else {
+			// Files trashed with their folder are listed under that folder instead
+			query = query.Where("(folder_id IS NULL OR folder_id NOT IN (SELECT id FROM folder WHERE is_deleted = ?))", true)
 		}
 	}
 
-	err := query.Find(&files).Error
-	return files, err
+	query, err := paginate(query, fileSortColumns, "file.id", opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := query.Find(&files).Error; err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(files) > opts.Limit {
+		files = files[:opts.Limit]
+		last := &files[len(files)-1]
+		nextCursor = encodeCursor(opts, fileSortValue(last, opts.Sort), last.ID)
+	}
+	return files, nextCursor, nil
 }
 
 func (r *FileRepository) UpsertFilePending(file *models.File, pendingEntry *models.PendingUpload) error {
-	// We use Upsert (On Conflict) so if the user resumes an upload,
-	// we just update the existing record based on ObjectKey or ID
+	// Resumed uploads are answered from PendingUpload before we get here, so every call brings
+	// a fresh object key and the file row is always new
 	return r.DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "object_key"}},
-			DoUpdates: clause.AssignmentColumns([]string{"updated_at", "s3_upload_id", "upload_status"}),
-		}).Create(file).Error
-		if err != nil {
+		if err := tx.Create(file).Error; err != nil {
 			return err
 		}
 
@@ -305,8 +389,11 @@ func (r *FileRepository) UpsertFilePending(file *models.File, pendingEntry *mode
 	})
 }
 
-func (r *FileRepository) FinalizeFile(uploadID string, partsCount int, finalETag string, status string) error {
-	return r.DB.Transaction(func(tx *gorm.DB) error {
+// FinalizeFile records the outcome of a multipart upload. A completed upload with a verified
+// sha256 is deduplicated against the blob table; a duplicate's own object is deleted afterwards.
+func (r *FileRepository) FinalizeFile(uploadID string, partsCount int, finalETag string, status string, sha256 string, store storage.ObjectStore) error {
+	var duplicateKey string
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
 		var file models.File
 		if err := tx.Where("s3_upload_id = ?", uploadID).First(&file).Error; err != nil {
 			return err
@@ -318,12 +405,153 @@ func (r *FileRepository) FinalizeFile(uploadID string, partsCount int, finalETag
 			"s3_upload_id":          nil,
 			"uploaded_chunks":       partsCount,
 			"uploaded_part_numbers": partsCount,
+			// Only a verified sha256 is kept; attachBlob records it below
+			"sha256": nil,
 		}).Error; err != nil {
 			return err
 		}
 
+		if status != "completed" {
+			return nil
+		}
+
+		if sha256 != "" {
+			var err error
+			if duplicateKey, err = attachBlob(tx, &file, sha256); err != nil {
+				return err
+			}
+		}
+
+		file.ETag = &finalETag
+		if err := addAsNewVersion(tx, &file); err != nil {
+			return err
+		}
+
 		// Update the user storage directly here instead of a hook
-		return tx.Model(&models.Users{}).Where("id = ?", file.OwnerID).
-			UpdateColumn("storage_used", gorm.Expr("storage_used + ?", file.Size)).Error
+		return chargeStorage(tx, file.OwnerID, file.Size)
 	})
+	if err != nil {
+		return err
+	}
+
+	if duplicateKey != "" {
+		DeleteObjectsOrQueue(r.DB, store, []string{duplicateKey})
+	}
+	return nil
+}
+
+// chargeStorage adds size to the user's StorageUsed, refusing to go past StorageLimit
+// with the same bound InitiateMultiPartUpload applies.
+func chargeStorage(tx *gorm.DB, userID uuid.UUID, size int64) error {
+	result := tx.Model(&models.Users{}).
+		Where("id = ? AND storage_used + ? < storage_limit", userID, size).
+		UpdateColumn("storage_used", gorm.Expr("storage_used + ?", size))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInsufficientStorage
+	}
+	return nil
+}
+
+// checkDestination makes sure folderID is a live folder owned by userID. A nil folderID is the root.
+func checkDestination(tx *gorm.DB, userID uuid.UUID, folderID *uuid.UUID) error {
+	if folderID == nil {
+		return nil
+	}
+	var count int64
+	err := tx.Model(&models.Folder{}).
+		Where("id = ? AND owner_id = ? AND is_deleted = ?", *folderID, userID, false).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrDestinationNotFound
+	}
+	return nil
+}
+
+// newObjectKey builds a fresh key in the same layout InitiateMultiPartUpload uses.
+func newObjectKey(name string) string {
+	return fmt.Sprintf("uploads/%s/%s", uuid.New().String(), name)
+}
+
+func (r *FileRepository) MoveFile(fileID uuid.UUID, userID uuid.UUID, folderID *uuid.UUID) error {
+	var file models.File
+	if err := r.DB.Where("id = ? AND owner_id = ? AND is_deleted = ?", fileID, userID, false).First(&file).Error; err != nil {
+		return err
+	}
+	if err := checkDestination(r.DB, userID, folderID); err != nil {
+		return err
+	}
+	return r.DB.Model(&file).Update("folder_id", folderID).Error
+}
+
+// CopyFile duplicates a completed file into folderID with a server-side object copy.
+// The copy belongs to ownerID and is charged against their quota.
+func (r *FileRepository) CopyFile(file *models.File, ownerID uuid.UUID, folderID *uuid.UUID, store storage.ObjectStore) (*models.File, error) {
+	if file.UploadStatus != "completed" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if err := checkDestination(r.DB, ownerID, folderID); err != nil {
+		return nil, err
+	}
+
+	var copied *models.File
+	var copiedKeys []string
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := chargeStorage(tx, ownerID, file.Size); err != nil {
+			return err
+		}
+		var err error
+		copied, err = copyFileRow(tx, file, ownerID, folderID, store, &copiedKeys)
+		return err
+	})
+	if err != nil {
+		DeleteObjectsOrQueue(r.DB, store, copiedKeys)
+	}
+	return copied, err
+}
+
+// copyFileRow copies the object behind file to a new key and inserts the matching row for ownerID.
+// The object is copied after the insert and its key appended to copiedKeys; the caller deletes
+// those objects if the transaction does not commit.
+func copyFileRow(tx *gorm.DB, file *models.File, ownerID uuid.UUID, folderID *uuid.UUID, store storage.ObjectStore, copiedKeys *[]string) (*models.File, error) {
+	// Deduplicated content is shared rather than copied
+	shared, err := retainBlob(tx, file.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	objectKey := newObjectKey(file.Name)
+	if shared {
+		objectKey = file.ObjectKey
+	}
+
+	copied := models.File{
+		Name:           file.Name,
+		OwnerID:        ownerID,
+		FolderID:       folderID,
+		Size:           file.Size,
+		MimeType:       file.MimeType,
+		BucketName:     file.BucketName,
+		ObjectKey:      objectKey,
+		ETag:           file.ETag,
+		SHA256:         file.SHA256,
+		UploadStatus:   "completed",
+		TotalChunks:    file.TotalChunks,
+		UploadedChunks: file.UploadedChunks,
+	}
+	if err := tx.Create(&copied).Error; err != nil {
+		return nil, err
+	}
+	if shared {
+		return &copied, nil
+	}
+	if err := store.CopyObject(context.TODO(), file.ObjectKey, copied.ObjectKey); err != nil {
+		return nil, fmt.Errorf("failed to copy object: %w", err)
+	}
+	*copiedKeys = append(*copiedKeys, copied.ObjectKey)
+	return &copied, nil
 }
@@ -0,0 +1,204 @@
+package repositories
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/storage"
+	"gorm.io/gorm"
+)
+
+var ErrCurrentVersion = errors.New("the current version cannot be deleted")
+
+// addAsNewVersion folds a just-completed upload into an existing live file with the same name
+// in the same folder: the existing content is kept as a FileVersion and the upload becomes its
+// current revision. The upload's own row is removed so the file keeps its ID and shares.
+func addAsNewVersion(tx *gorm.DB, upload *models.File) error {
+	var existing models.File
+	query := tx.Where("owner_id = ? AND name = ? AND id <> ? AND upload_status = ? AND is_deleted = ?",
+		upload.OwnerID, upload.Name, upload.ID, "completed", false)
+	if upload.FolderID == nil {
+		query = query.Where("folder_id IS NULL")
+	} else {
+		query = query.Where("folder_id = ?", *upload.FolderID)
+	}
+	err := query.Order("created_at").First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var latest int
+	if err := tx.Model(&models.FileVersion{}).Where("file_id = ?", existing.ID).
+		Select("COALESCE(MAX(version_number), 0)").Scan(&latest).Error; err != nil {
+		return err
+	}
+	if existing.Version > latest {
+		latest = existing.Version
+	}
+
+	if err := tx.Create(&models.FileVersion{
+		FileID:            existing.ID,
+		VersionNumber:     existing.Version,
+		Size:              existing.Size,
+		MimeType:          existing.MimeType,
+		BucketName:        existing.BucketName,
+		ObjectKey:         existing.ObjectKey,
+		ETag:              existing.ETag,
+		SHA256:            existing.SHA256,
+		ChecksumAlgorithm: existing.ChecksumAlgorithm,
+		Checksum:          existing.Checksum,
+		CreatedAt:         existing.UpdatedAt,
+	}).Error; err != nil {
+		return err
+	}
+
+	// The upload's row goes before its key moves over to the existing file
+	if err := tx.Unscoped().Delete(&models.File{}, "id = ?", upload.ID).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&existing).Updates(map[string]interface{}{
+		"object_key":            upload.ObjectKey,
+		"size":                  upload.Size,
+		"mime_type":             upload.MimeType,
+		"e_tag":                 upload.ETag,
+		"sha256":                upload.SHA256,
+		"checksum_algorithm":    upload.ChecksumAlgorithm,
+		"checksum":              upload.Checksum,
+		"total_chunks":          upload.TotalChunks,
+		"uploaded_chunks":       upload.UploadedChunks,
+		"uploaded_part_numbers": upload.UploadedPartNumbers,
+		"version":               latest + 1,
+	}).Error
+}
+
+// GetFileVersions returns the previous versions of a file, newest first.
+func (r *FileRepository) GetFileVersions(fileID uuid.UUID) ([]models.FileVersion, error) {
+	var versions []models.FileVersion
+	err := r.DB.Where("file_id = ?", fileID).Order("version_number DESC").Find(&versions).Error
+	return versions, err
+}
+
+func (r *FileRepository) GetFileVersion(fileID uuid.UUID, versionNumber int) (models.FileVersion, error) {
+	var version models.FileVersion
+	err := r.DB.Where("file_id = ? AND version_number = ?", fileID, versionNumber).First(&version).Error
+	return version, err
+}
+
+// RestoreFileVersion makes an older version current again. The current content swaps places
+// with it and is kept as a version, so nothing is copied and StorageUsed does not change.
+func (r *FileRepository) RestoreFileVersion(file *models.File, versionNumber int) error {
+	if versionNumber == file.Version {
+		return nil
+	}
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		var version models.FileVersion
+		if err := tx.Where("file_id = ? AND version_number = ?", file.ID, versionNumber).First(&version).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&version).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.FileVersion{
+			FileID:            file.ID,
+			VersionNumber:     file.Version,
+			Size:              file.Size,
+			MimeType:          file.MimeType,
+			BucketName:        file.BucketName,
+			ObjectKey:         file.ObjectKey,
+			ETag:              file.ETag,
+			SHA256:            file.SHA256,
+			ChecksumAlgorithm: file.ChecksumAlgorithm,
+			Checksum:          file.Checksum,
+			CreatedAt:         file.UpdatedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(file).Updates(map[string]interface{}{
+			"object_key":         version.ObjectKey,
+			"size":               version.Size,
+			"mime_type":          version.MimeType,
+			"e_tag":              version.ETag,
+			"sha256":             version.SHA256,
+			"checksum_algorithm": version.ChecksumAlgorithm,
+			"checksum":           version.Checksum,
+			"version":            version.VersionNumber,
+		}).Error
+	})
+}
+
+// DeleteFileVersion removes an old version's object and releases its bytes from StorageUsed.
+func (r *FileRepository) DeleteFileVersion(file *models.File, versionNumber int, store storage.ObjectStore) error {
+	if versionNumber == file.Version {
+		return ErrCurrentVersion
+	}
+
+	version, err := r.GetFileVersion(file.ID, versionNumber)
+	if err != nil {
+		return err
+	}
+
+	var orphaned []string
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if orphaned, err = ReleaseObjects(tx, []string{version.ObjectKey}); err != nil {
+			return err
+		}
+		if err := tx.Delete(&version).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Users{}).Where("id = ?", file.OwnerID).
+			UpdateColumn("storage_used", gorm.Expr("storage_used - ?", version.Size)).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	DeleteObjectsOrQueue(r.DB, store, orphaned)
+	return nil
+}
+
+// deleteAllVersions drops every old version of the given files and returns the bytes they held.
+// Objects that fail to delete are queued in FailedS3Deletion for CleanupOrphanedS3Objects.
+func deleteAllVersions(db *gorm.DB, fileIDs []uuid.UUID, store storage.ObjectStore) (int64, error) {
+	if len(fileIDs) == 0 {
+		return 0, nil
+	}
+
+	var versions []models.FileVersion
+	if err := db.Where("file_id IN ?", fileIDs).Find(&versions).Error; err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, nil
+	}
+
+	var total int64
+	keys := make([]string, 0, len(versions))
+	for _, v := range versions {
+		total += v.Size
+		keys = append(keys, v.ObjectKey)
+	}
+
+	var orphaned []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if orphaned, err = ReleaseObjects(tx, keys); err != nil {
+			return err
+		}
+		return tx.Where("file_id IN ?", fileIDs).Delete(&models.FileVersion{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	DeleteObjectsOrQueue(db, store, orphaned)
+	return total, nil
+}
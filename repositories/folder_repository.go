@@ -1,8 +1,14 @@
 package repositories
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/storage"
 	"gorm.io/gorm"
 )
 
@@ -15,15 +21,34 @@ func NewFolderRepository(db *gorm.DB) *FolderRepository {
 }
 
 func (r *FolderRepository) EnsureFolderExists(userID uuid.UUID, parentID *uuid.UUID, name string) (uuid.UUID, error) {
+	folder, err := r.FindFolderByName(userID, parentID, name)
+	if err == nil {
+		return folder.ID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, err
+	}
+
+	created, err := r.CreateFolder(userID, name, parentID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return created.ID, nil
+}
+
+// FindFolderByName looks up a live folder by name directly under parentID (nil for the root).
+func (r *FolderRepository) FindFolderByName(userID uuid.UUID, parentID *uuid.UUID, name string) (models.Folder, error) {
 	var folder models.Folder
 
-	err := r.DB.Where(models.Folder{
-		OwnerID:  userID,
-		ParentID: parentID,
-		Name:     name,
-	}).FirstOrCreate(&folder).Error
+	query := r.DB.Where("owner_id = ? AND name = ? AND is_deleted = ?", userID, name, false)
+	if parentID == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *parentID)
+	}
 
-	return folder.ID, err
+	err := query.Order("created_at").First(&folder).Error
+	return folder, err
 }
 
 func (r *FolderRepository) CreateFolder(userID uuid.UUID, folderName string, parentID *uuid.UUID) (*models.Folder, error) {
@@ -41,33 +66,459 @@ func (r *FolderRepository) CreateFolder(userID uuid.UUID, folderName string, par
 	return &folder, nil
 }
 
-// TODO - Merge the two get folder queries
-func (r *FolderRepository) GetRootLevelFolderFromUserID(userID uuid.UUID, isTrash bool) ([]models.Folder, error) {
+// GetFolders lists one page of the folders directly under parentID (nil for the root).
+// In the trash, the root lists only the top of each trashed tree; its children are listed by parent.
+func (r *FolderRepository) GetFolders(userID uuid.UUID, parentID *uuid.UUID, isTrash bool, opts ListOptions) ([]models.Folder, string, error) {
+
+	query := r.DB.Where("owner_id = ? AND is_deleted = ?", userID, isTrash)
+
+	switch {
+	case parentID != nil:
+		query = query.Where("parent_id = ?", *parentID)
+	case isTrash:
+		query = query.Where("(parent_id IS NULL OR parent_id NOT IN (SELECT id FROM folder WHERE is_deleted = ?))", true)
+	default:
+		query = query.Where("parent_id IS NULL")
+	}
+
+	return r.listFolders(query, opts)
+}
+
+// listFolders runs one page of query and returns the cursor for the next page, empty on the last one.
+func (r *FolderRepository) listFolders(query *gorm.DB, opts ListOptions) ([]models.Folder, string, error) {
+	opts = opts.normalized()
+
+	query, err := paginate(query, folderSortColumns, "folder.id", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var folders []models.Folder
+	if err := query.Find(&folders).Error; err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(folders) > opts.Limit {
+		folders = folders[:opts.Limit]
+		last := &folders[len(folders)-1]
+		nextCursor = encodeCursor(opts, folderSortValue(last, opts.Sort), last.ID)
+	}
+	return folders, nextCursor, nil
+}
+
+func (r *FolderRepository) GetFolderByID(folderID uuid.UUID, userID uuid.UUID) (models.Folder, error) {
+	var folder models.Folder
+	err := r.DB.Where("id = ? AND owner_id = ? AND is_deleted = ?", folderID, userID, false).First(&folder).Error
+	return folder, err
+}
+
+// GetFolderTree returns the folder and all of its live descendants, together with the
+// completed files inside them. Callers authorize ActionRead on the folder first; everything
+// below a readable folder is readable.
+func (r *FolderRepository) GetFolderTree(folderID uuid.UUID) ([]models.Folder, []models.File, error) {
 
 	var folders []models.Folder
+	err := r.DB.Raw(`
+		WITH RECURSIVE tree AS (
+			SELECT * FROM folder WHERE id = ? AND is_deleted = false
+			UNION ALL
+			SELECT f.* FROM folder f
+			JOIN tree t ON f.parent_id = t.id
+			WHERE f.is_deleted = false
+		)
+		SELECT * FROM tree`, folderID).Scan(&folders).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	folderIDs := make([]uuid.UUID, 0, len(folders))
+	for _, f := range folders {
+		folderIDs = append(folderIDs, f.ID)
+	}
+
+	var files []models.File
+	err = r.DB.Table("file").Select("file.*").
+		Where("file.folder_id IN ? AND file.is_deleted = ? AND file.deleted_at IS NULL AND file.upload_status = ?", folderIDs, false, "completed").
+		Order("file.name").
+		Find(&files).Error
+
+	return folders, files, err
+}
+
+// subtreeFolderIDs returns folderID and every folder below it, trashed or not.
+func (r *FolderRepository) subtreeFolderIDs(tx *gorm.DB, folderID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := tx.Raw(`
+		WITH RECURSIVE tree AS (
+			SELECT id FROM folder WHERE id = ?
+			UNION ALL
+			SELECT f.id FROM folder f
+			JOIN tree t ON f.parent_id = t.id
+		)
+		SELECT id FROM tree`, folderID).Scan(&ids).Error
+	return ids, err
+}
+
+// DeleteFolder mirrors FileRepository.DeleteFile: the first call moves the folder to trash,
+// a second call on a trashed folder deletes it permanently.
+func (r *FolderRepository) DeleteFolder(folderID uuid.UUID, userID uuid.UUID, store storage.ObjectStore) error {
+	var folder models.Folder
+
+	err := r.DB.Where("id = ? AND owner_id = ?", folderID, userID).First(&folder).Error
+	if err != nil {
+		return fmt.Errorf("folder not found: %w", err)
+	}
+
+	if folder.IsDeleted {
+		return r.PermanentDeleteFolder(&folder, store)
+	}
+
+	return r.SoftDeleteFolder(&folder)
+}
+
+// SoftDeleteFolder trashes the folder together with all live child folders and files.
+// Everything gets the same deleted_at so RestoreFolder can tell it apart from items trashed earlier.
+func (r *FolderRepository) SoftDeleteFolder(folder *models.Folder) error {
+	now := time.Now()
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		folderIDs, err := r.subtreeFolderIDs(tx, folder.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Folder{}).
+			Where("id IN ? AND is_deleted = ?", folderIDs, false).
+			Updates(map[string]interface{}{
+				"is_deleted": true,
+				"deleted_at": now,
+			}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.File{}).
+			Where("folder_id IN ? AND is_deleted = ?", folderIDs, false).
+			Updates(map[string]interface{}{
+				"is_deleted": true,
+				"deleted_at": now,
+			}).Error
+	})
+}
+
+// RestoreFolder brings back a trashed folder and whatever was trashed along with it.
+// If its parent is still in the trash the folder is restored to the root.
+func (r *FolderRepository) RestoreFolder(folderID uuid.UUID, userID uuid.UUID) error {
+	var folder models.Folder
+
+	err := r.DB.Where("id = ? AND owner_id = ?", folderID, userID).First(&folder).Error
+	if err != nil {
+		return fmt.Errorf("folder not found: %w", err)
+	}
+
+	if !folder.IsDeleted || folder.DeletedAt == nil {
+		return nil
+	}
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		folderIDs, err := r.subtreeFolderIDs(tx, folder.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Folder{}).
+			Where("id IN ? AND is_deleted = ? AND deleted_at >= ?", folderIDs, true, *folder.DeletedAt).
+			Updates(map[string]interface{}{
+				"is_deleted": false,
+				"deleted_at": nil,
+			}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&models.File{}).
+			Where("folder_id IN ? AND is_deleted = ? AND deleted_at >= ?", folderIDs, true, *folder.DeletedAt).
+			Updates(map[string]interface{}{
+				"is_deleted": false,
+				"deleted_at": nil,
+			}).Error; err != nil {
+			return err
+		}
+
+		if folder.ParentID != nil {
+			var parentTrashed int64
+			if err := tx.Model(&models.Folder{}).
+				Where("id = ? AND is_deleted = ?", *folder.ParentID, true).
+				Count(&parentTrashed).Error; err != nil {
+				return err
+			}
+			if parentTrashed > 0 {
+				return tx.Model(&models.Folder{}).Where("id = ?", folder.ID).Update("parent_id", nil).Error
+			}
+		}
+		return nil
+	})
+}
+
+// PermanentDeleteFolder deletes the folder and everything below it. Completed files go through
+// FileRepository.PermanentDeleteFile so their objects move to delete/ and StorageUsed is released;
+// they are recorded without a folder so RestoreDeletedFiles puts them back at the root.
+func (r *FolderRepository) PermanentDeleteFolder(folder *models.Folder, store storage.ObjectStore) error {
+	folderIDs, err := r.subtreeFolderIDs(r.DB, folder.ID)
+	if err != nil {
+		return err
+	}
+
+	var files []models.File
+	if err := r.DB.Unscoped().Where("folder_id IN ?", folderIDs).Find(&files).Error; err != nil {
+		return err
+	}
+
+	fileRepo := NewFileRepository(r.DB)
+	var unfinishedKeys []string
+	for i := range files {
+		file := &files[i]
+		if file.UploadStatus != "completed" {
+			unfinishedKeys = append(unfinishedKeys, file.ObjectKey)
+			continue
+		}
+		file.FolderID = nil
+		if err := fileRepo.PermanentDeleteFile(file, store); err != nil {
+			return err
+		}
+	}
 
-	query := r.DB.Unscoped().Where("owner_id = ? AND parent_id IS NULL AND is_deleted = false", userID)
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if len(unfinishedKeys) > 0 {
+			if err := tx.Unscoped().Where("object_key IN ?", unfinishedKeys).Delete(&models.File{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("s3_key IN ?", unfinishedKeys).Delete(&models.PendingUpload{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("id IN ?", folderIDs).Delete(&models.Folder{}).Error
+	})
+}
 
-	if isTrash {
-		query.Where("is_deleted = ?", isTrash)
+func (r *FolderRepository) MoveFolder(folderID uuid.UUID, userID uuid.UUID, parentID *uuid.UUID) error {
+	folder, err := r.GetFolderByID(folderID, userID)
+	if err != nil {
+		return err
+	}
+	if err := checkDestination(r.DB, userID, parentID); err != nil {
+		return err
 	}
 
-	err := query.Find(&folders).Error
+	if parentID != nil {
+		descendants, err := r.subtreeFolderIDs(r.DB, folder.ID)
+		if err != nil {
+			return err
+		}
+		for _, id := range descendants {
+			if id == *parentID {
+				return ErrMoveIntoDescendant
+			}
+		}
+	}
 
-	return folders, err
+	return r.DB.Model(&folder).Update("parent_id", parentID).Error
 }
 
-func (r *FolderRepository) GetFoldersByParentID(userID uuid.UUID, parentID uuid.UUID, isTrash bool) ([]models.Folder, error) {
+// CopyFolder duplicates the folder, its live subfolders and completed files under parentID,
+// owned by userID. Every copied byte is charged up front, so the copy either fits the quota as a
+// whole or not at all.
+func (r *FolderRepository) CopyFolder(folderID uuid.UUID, userID uuid.UUID, parentID *uuid.UUID, store storage.ObjectStore) (*models.Folder, error) {
+	folders, files, err := r.GetFolderTree(folderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if err := checkDestination(r.DB, userID, parentID); err != nil {
+		return nil, err
+	}
+
+	var totalSize int64
+	filesByFolder := make(map[uuid.UUID][]models.File)
+	for _, f := range files {
+		totalSize += f.Size
+		filesByFolder[*f.FolderID] = append(filesByFolder[*f.FolderID], f)
+	}
+	children := make(map[uuid.UUID][]models.Folder)
+	var root models.Folder
+	for _, f := range folders {
+		if f.ID == folderID {
+			root = f
+		} else if f.ParentID != nil {
+			children[*f.ParentID] = append(children[*f.ParentID], f)
+		}
+	}
+
+	var copiedRoot *models.Folder
+	var copiedKeys []string
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := chargeStorage(tx, userID, totalSize); err != nil {
+			return err
+		}
 
+		var copyTree func(src models.Folder, dstParent *uuid.UUID) (*models.Folder, error)
+		copyTree = func(src models.Folder, dstParent *uuid.UUID) (*models.Folder, error) {
+			dst := models.Folder{Name: src.Name, OwnerID: userID, ParentID: dstParent}
+			if err := tx.Create(&dst).Error; err != nil {
+				return nil, err
+			}
+			for i := range filesByFolder[src.ID] {
+				if _, err := copyFileRow(tx, &filesByFolder[src.ID][i], userID, &dst.ID, store, &copiedKeys); err != nil {
+					return nil, err
+				}
+			}
+			for _, child := range children[src.ID] {
+				if _, err := copyTree(child, &dst.ID); err != nil {
+					return nil, err
+				}
+			}
+			return &dst, nil
+		}
+
+		var err error
+		copiedRoot, err = copyTree(root, parentID)
+		return err
+	})
+	if err != nil {
+		// Objects copied before the failure have no rows left pointing at them
+		DeleteObjectsOrQueue(r.DB, store, copiedKeys)
+	}
+	return copiedRoot, err
+}
+
+// GetAncestors returns the chain from the top-level folder down to folderID, inclusive.
+func (r *FolderRepository) GetAncestors(folderID uuid.UUID, userID uuid.UUID) ([]models.Folder, error) {
 	var folders []models.Folder
+	err := r.DB.Raw(`
+		WITH RECURSIVE ancestors AS (
+			SELECT id, name, parent_id, 0 AS depth FROM folder WHERE id = ? AND owner_id = ?
+			UNION ALL
+			SELECT f.id, f.name, f.parent_id, a.depth + 1 FROM folder f
+			JOIN ancestors a ON f.id = a.parent_id
+		)
+		SELECT id, name, parent_id FROM ancestors ORDER BY depth DESC`, folderID, userID).Scan(&folders).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return folders, nil
+}
+
+// FolderContents is one page of a folder listing: folders always come before files.
+type FolderContents struct {
+	Folders []models.Folder
+	Files   []models.File
+	// Number of subfolders and files in each listed folder, in the same trash state
+	ChildCounts map[uuid.UUID]int64
+}
+
+// GetFolderContents pages through the folders and then the files under parentID, both in the order
+// given by opts. The cursor records which of the two lists the next page resumes in.
+func (r *FolderRepository) GetFolderContents(userID uuid.UUID, parentID *uuid.UUID, isTrash bool, opts ListOptions) (FolderContents, string, error) {
+	opts = opts.normalized()
+	contents := FolderContents{ChildCounts: map[uuid.UUID]int64{}}
+
+	section, inner := "folder", ""
+	if opts.Cursor != "" {
+		var ok bool
+		section, inner, ok = strings.Cut(opts.Cursor, ".")
+		if !ok || (section != "folder" && section != "file") {
+			return contents, "", ErrInvalidCursor
+		}
+	}
 
-	query := r.DB.Unscoped().Where("owner_id = ? AND parent_id = ? AND is_deleted = false", userID, parentID)
+	remaining := opts.Limit
+	var nextCursor string
 
-	if isTrash {
-		query.Where("is_deleted = ?", isTrash)
+	if section == "folder" {
+		folderOpts := opts
+		folderOpts.Cursor = inner
+		folders, next, err := r.GetFolders(userID, parentID, isTrash, folderOpts)
+		if err != nil {
+			return contents, "", err
+		}
+		contents.Folders = folders
+		if next != "" {
+			nextCursor = "folder." + next
+		}
+		remaining -= len(folders)
+		inner = ""
 	}
 
-	err := query.Find(&folders).Error
+	fileRepo := NewFileRepository(r.DB)
+	if nextCursor == "" && remaining > 0 {
+		fileOpts := opts
+		fileOpts.Cursor = inner
+		fileOpts.Limit = remaining
+		files, next, err := fileRepo.GetFiles(userID, parentID, isTrash, fileOpts)
+		if err != nil {
+			return contents, "", err
+		}
+		contents.Files = files
+		if next != "" {
+			nextCursor = "file." + next
+		}
+	} else if nextCursor == "" {
+		// The folders filled this page exactly; only point at the files if there are any
+		files, _, err := fileRepo.GetFiles(userID, parentID, isTrash, ListOptions{Sort: opts.Sort, Desc: opts.Desc, Limit: 1})
+		if err != nil {
+			return contents, "", err
+		}
+		if len(files) > 0 {
+			nextCursor = "file."
+		}
+	}
+
+	if err := r.countChildren(contents.Folders, isTrash, contents.ChildCounts); err != nil {
+		return contents, "", err
+	}
+	return contents, nextCursor, nil
+}
+
+func (r *FolderRepository) countChildren(folders []models.Folder, isTrash bool, counts map[uuid.UUID]int64) error {
+	if len(folders) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(folders))
+	for _, f := range folders {
+		ids = append(ids, f.ID)
+	}
 
-	return folders, err
+	var rows []struct {
+		ParentID uuid.UUID
+		Count    int64
+	}
+	err := r.DB.Model(&models.Folder{}).
+		Select("parent_id, COUNT(*) AS count").
+		Where("parent_id IN ? AND is_deleted = ?", ids, isTrash).
+		Group("parent_id").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		counts[row.ParentID] += row.Count
+	}
+
+	rows = nil
+	err = r.DB.Unscoped().Model(&models.File{}).
+		Select("folder_id AS parent_id, COUNT(*) AS count").
+		Where("folder_id IN ? AND is_deleted = ?", ids, isTrash).
+		Group("folder_id").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		counts[row.ParentID] += row.Count
+	}
+	return nil
 }
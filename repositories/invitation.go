@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"strings"
+	"time"
+
+	"github.com/richeek45/filedrive/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateInvitations records invitations for emails that have no account, on the item and with
+// the role of grant. Inviting an email again updates its role and inviter.
+func (r *PermissionRepository) CreateInvitations(grant models.ResourcePermission, emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+
+	conflict := clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"permission", "invited_by"})}
+	if grant.FolderID != nil {
+		conflict.Columns = []clause.Column{{Name: "email"}, {Name: "folder_id"}}
+		conflict.TargetWhere = clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "folder_id IS NOT NULL"}}}
+	} else {
+		conflict.Columns = []clause.Column{{Name: "email"}, {Name: "file_id"}}
+		conflict.TargetWhere = clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "file_id IS NOT NULL"}}}
+	}
+
+	invitations := make([]models.PendingInvitation, 0, len(emails))
+	for _, email := range emails {
+		invitations = append(invitations, models.PendingInvitation{
+			Email:      strings.ToLower(email),
+			FileID:     grant.FileID,
+			FolderID:   grant.FolderID,
+			InvitedBy:  grant.GrantedBy,
+			Permission: grant.Permission,
+			CreatedAt:  time.Now(),
+		})
+	}
+	return r.DB.Clauses(conflict).Create(&invitations).Error
+}
+
+// AcceptInvitations turns the invitations waiting for user's email into grants, keeping any
+// grant the user already has on the same item, and returns how many were accepted.
+func (r *PermissionRepository) AcceptInvitations(user *models.Users) (int, error) {
+	accepted := 0
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var invitations []models.PendingInvitation
+		if err := tx.Where("email = ?", strings.ToLower(user.Email)).Find(&invitations).Error; err != nil {
+			return err
+		}
+
+		for _, invitation := range invitations {
+			existing := tx.Model(&models.ResourcePermission{}).Where("user_id = ?", user.ID)
+			if invitation.FolderID != nil {
+				existing = existing.Where("folder_id = ?", *invitation.FolderID)
+			} else {
+				existing = existing.Where("file_id = ?", *invitation.FileID)
+			}
+			var count int64
+			if err := existing.Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				grant := models.ResourcePermission{
+					FileID:     invitation.FileID,
+					FolderID:   invitation.FolderID,
+					UserID:     user.ID,
+					GrantedBy:  invitation.InvitedBy,
+					Permission: invitation.Permission,
+					CreatedAt:  time.Now(),
+				}
+				if err := tx.Create(&grant).Error; err != nil {
+					return err
+				}
+				accepted++
+			}
+			if err := tx.Delete(&invitation).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return accepted, err
+}
@@ -0,0 +1,176 @@
+package repositories
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// ListOptions controls sorting and keyset pagination for listings.
+// Sort is one of name, size, createdAt, updatedAt or type; Cursor is the NextCursor of the previous page.
+type ListOptions struct {
+	Sort   string
+	Desc   bool
+	Cursor string
+	Limit  int
+}
+
+type sortKind int
+
+const (
+	sortString sortKind = iota
+	sortInt
+	sortTime
+)
+
+type sortColumn struct {
+	expr string
+	kind sortKind
+}
+
+var fileSortColumns = map[string]sortColumn{
+	"name":      {"file.name", sortString},
+	"size":      {"file.size", sortInt},
+	"createdAt": {"file.created_at", sortTime},
+	"updatedAt": {"file.updated_at", sortTime},
+	"type":      {"COALESCE(file.mime_type, '')", sortString},
+}
+
+// Folders have no size or type, so those sorts fall back to the name
+var folderSortColumns = map[string]sortColumn{
+	"name":      {"folder.name", sortString},
+	"size":      {"folder.name", sortString},
+	"createdAt": {"folder.created_at", sortTime},
+	"updatedAt": {"folder.updated_at", sortTime},
+	"type":      {"folder.name", sortString},
+}
+
+func IsValidSort(sort string) bool {
+	_, ok := fileSortColumns[sort]
+	return ok || sort == ""
+}
+
+type cursor struct {
+	Sort  string          `json:"s"`
+	Desc  bool            `json:"d"`
+	Value json.RawMessage `json:"v"`
+	ID    uuid.UUID       `json:"id"`
+}
+
+func (o ListOptions) normalized() ListOptions {
+	if o.Sort == "" {
+		o.Sort = "name"
+	}
+	if o.Limit <= 0 {
+		o.Limit = DefaultPageSize
+	}
+	if o.Limit > MaxPageSize {
+		o.Limit = MaxPageSize
+	}
+	return o
+}
+
+// paginate orders query by the sort column with idColumn as tie-breaker, resumes after the
+// cursor and fetches one extra row so the caller can tell whether another page follows.
+func paginate(query *gorm.DB, columns map[string]sortColumn, idColumn string, opts ListOptions) (*gorm.DB, error) {
+	column, ok := columns[opts.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidCursor, opts.Sort)
+	}
+
+	op, dir := ">", "ASC"
+	if opts.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	if opts.Cursor != "" {
+		c, value, err := decodeCursor(opts.Cursor, column.kind)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != opts.Sort || c.Desc != opts.Desc {
+			return nil, fmt.Errorf("%w: cursor belongs to a different sort order", ErrInvalidCursor)
+		}
+		query = query.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", column.expr, idColumn, op), value, c.ID)
+	}
+
+	return query.
+		Order(fmt.Sprintf("%s %s, %s %s", column.expr, dir, idColumn, dir)).
+		Limit(opts.Limit + 1), nil
+}
+
+func decodeCursor(raw string, kind sortKind) (cursor, interface{}, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, nil, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, nil, ErrInvalidCursor
+	}
+
+	var value interface{}
+	switch kind {
+	case sortInt:
+		var v int64
+		err = json.Unmarshal(c.Value, &v)
+		value = v
+	case sortTime:
+		var v time.Time
+		err = json.Unmarshal(c.Value, &v)
+		value = v
+	default:
+		var v string
+		err = json.Unmarshal(c.Value, &v)
+		value = v
+	}
+	if err != nil {
+		return c, nil, ErrInvalidCursor
+	}
+	return c, value, nil
+}
+
+func encodeCursor(opts ListOptions, value interface{}, id uuid.UUID) string {
+	v, _ := json.Marshal(value)
+	data, _ := json.Marshal(cursor{Sort: opts.Sort, Desc: opts.Desc, Value: v, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func fileSortValue(f *models.File, sort string) interface{} {
+	switch sort {
+	case "size":
+		return f.Size
+	case "createdAt":
+		return f.CreatedAt
+	case "updatedAt":
+		return f.UpdatedAt
+	case "type":
+		if f.MimeType == nil {
+			return ""
+		}
+		return *f.MimeType
+	default:
+		return f.Name
+	}
+}
+
+func folderSortValue(f *models.Folder, sort string) interface{} {
+	switch sort {
+	case "createdAt":
+		return f.CreatedAt
+	case "updatedAt":
+		return f.UpdatedAt
+	default:
+		return f.Name
+	}
+}
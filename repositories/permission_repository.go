@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/dtos"
+	"github.com/richeek45/filedrive/models"
+	"gorm.io/gorm"
+)
+
+// PermissionRepository resolves a user's role on files and folders. Grants on a folder apply
+// to everything below it; they are found by walking up the folder's ancestors on every check
+// rather than being copied onto descendants, so moves and new uploads pick them up for free.
+type PermissionRepository struct {
+	DB *gorm.DB
+}
+
+func NewPermissionRepository(db *gorm.DB) *PermissionRepository {
+	return &PermissionRepository{DB: db}
+}
+
+// FolderPermission returns userID's role on folder: owner, or the strongest grant on the folder
+// or any of its ancestors. gorm.ErrRecordNotFound means no access.
+func (r *PermissionRepository) FolderPermission(folder *models.Folder, userID uuid.UUID) (models.PermissionType, error) {
+	if folder.OwnerID == userID {
+		return models.PermissionOwner, nil
+	}
+	return r.strongestGrant(r.DB.Where("user_id = ? AND folder_id IN (?)", userID, ancestorIDs(r.DB, folder.ID)))
+}
+
+// FilePermission returns userID's role on file: owner, a grant on the file itself, or the
+// strongest grant on any folder above it. gorm.ErrRecordNotFound means no access.
+func (r *PermissionRepository) FilePermission(file *models.File, userID uuid.UUID) (models.PermissionType, error) {
+	if file.OwnerID == userID {
+		return models.PermissionOwner, nil
+	}
+	query := r.DB.Where("user_id = ?", userID)
+	if file.FolderID == nil {
+		query = query.Where("file_id = ?", file.ID)
+	} else {
+		query = query.Where("(file_id = ? OR folder_id IN (?))", file.ID, ancestorIDs(r.DB, *file.FolderID))
+	}
+	return r.strongestGrant(query)
+}
+
+// GrantedFolderIDs returns which of folderIDs have a grant of their own for userID.
+func (r *PermissionRepository) GrantedFolderIDs(userID uuid.UUID, folderIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	var granted []uuid.UUID
+	err := r.DB.Model(&models.ResourcePermission{}).
+		Where("user_id = ? AND folder_id IN ?", userID, folderIDs).
+		Pluck("folder_id", &granted).Error
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[uuid.UUID]bool, len(granted))
+	for _, id := range granted {
+		set[id] = true
+	}
+	return set, nil
+}
+
+// SharedFoldersByUserID lists the folders shared directly with userID. Their subfolders are
+// reachable by browsing into them and are not listed again, even those with a grant of their own.
+func (r *PermissionRepository) SharedFoldersByUserID(userID uuid.UUID) ([]dtos.SharedFolderResponse, error) {
+	var folders []dtos.SharedFolderResponse
+
+	err := r.DB.Table("folder").
+		Select("folder.id, folder.name, folder.parent_id, folder.created_at, folder.is_deleted, resource_permission.permission, users.first_name as shared_by").
+		Joins("JOIN resource_permission ON resource_permission.folder_id = folder.id").
+		Joins("JOIN users ON resource_permission.granted_by = users.id").
+		Where("resource_permission.user_id = ? AND folder.is_deleted = ?", userID, false).
+		Where("folder.id NOT IN (?)", sharedDescendantIDs(r.DB, userID)).
+		Order("folder.name").
+		Scan(&folders).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+func (r *PermissionRepository) strongestGrant(query *gorm.DB) (models.PermissionType, error) {
+	var grants []models.PermissionType
+	if err := query.Model(&models.ResourcePermission{}).Pluck("permission", &grants).Error; err != nil {
+		return "", err
+	}
+	if len(grants) == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+
+	strongest := grants[0]
+	for _, grant := range grants[1:] {
+		if grant.Includes(strongest) {
+			strongest = grant
+		}
+	}
+	return strongest, nil
+}
+
+// sharedDescendantIDs is a subquery selecting every folder below a live folder shared with userID.
+func sharedDescendantIDs(db *gorm.DB, userID uuid.UUID) *gorm.DB {
+	return db.Raw(`
+		WITH RECURSIVE descendants AS (
+			SELECT f.id FROM folder f
+			JOIN folder shared ON shared.id = f.parent_id AND shared.is_deleted = ?
+			JOIN resource_permission rp ON rp.folder_id = shared.id
+			WHERE rp.user_id = ?
+			UNION
+			SELECT f.id FROM folder f
+			JOIN descendants d ON f.parent_id = d.id
+		)
+		SELECT id FROM descendants`, false, userID)
+}
+
+// grantedFolderRanks is a subquery selecting every folder userID reaches through a grant on it or
+// on a folder above it, with the rank of the strongest such grant: 1 for viewer, 2 for editor.
+// It resolves the same inherited grants as FolderPermission, for all folders at once.
+func grantedFolderRanks(db *gorm.DB, userID uuid.UUID) *gorm.DB {
+	return db.Raw(`
+		WITH RECURSIVE granted AS (
+			SELECT folder_id AS id, CASE WHEN CAST(permission AS TEXT) = ? THEN 2 ELSE 1 END AS grant_rank
+			FROM resource_permission WHERE user_id = ? AND folder_id IS NOT NULL
+			UNION
+			SELECT f.id, g.grant_rank FROM folder f
+			JOIN granted g ON f.parent_id = g.id
+		)
+		SELECT id, MAX(grant_rank) AS grant_rank FROM granted GROUP BY id`, models.PermissionEditor, userID)
+}
+
+// ancestorIDs is a subquery selecting folderID and every folder above it.
+func ancestorIDs(db *gorm.DB, folderID uuid.UUID) *gorm.DB {
+	return db.Raw(`
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM folder WHERE id = ?
+			UNION ALL
+			SELECT f.id, f.parent_id FROM folder f
+			JOIN ancestors a ON f.id = a.parent_id
+		)
+		SELECT id FROM ancestors`, folderID)
+}
@@ -0,0 +1,201 @@
+package repositories
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/models"
+	"gorm.io/gorm"
+)
+
+type SearchRepository struct {
+	DB *gorm.DB
+}
+
+func NewSearchRepository(db *gorm.DB) *SearchRepository {
+	return &SearchRepository{DB: db}
+}
+
+type SearchParams struct {
+	Query  string
+	Prefix bool
+	// "file", "folder" or empty for both
+	Type     string
+	MimeType string
+	MinSize  *int64
+	MaxSize  *int64
+	// Matched against updated_at
+	ModifiedFrom *time.Time
+	ModifiedTo   *time.Time
+	// "me", "shared" or empty for both
+	Owner   string
+	Trashed bool
+	Limit   int
+	Offset  int
+}
+
+type SearchResult struct {
+	Type       string
+	ID         uuid.UUID
+	Name       string
+	FolderID   *uuid.UUID
+	OwnerID    uuid.UUID
+	Size       int64
+	MimeType   *string
+	Permission string
+	IsDeleted  bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Search matches file and folder names case-insensitively. In Postgres the LIKE patterns
+// are served by the pg_trgm indexes on lower(name) created in db.InitDB.
+func (r *SearchRepository) Search(userID uuid.UUID, p SearchParams) ([]SearchResult, error) {
+	pattern := escapeLike(strings.ToLower(p.Query)) + "%"
+	if !p.Prefix {
+		pattern = "%" + pattern
+	}
+
+	// Other users' trash is not ours to search
+	owner := p.Owner
+	if p.Trashed {
+		owner = "me"
+	}
+
+	var queries []*gorm.DB
+
+	// Access through a grant on a folder reaches everything below it, as in FilePermission
+	granted := grantedFolderRanks(r.DB, userID)
+
+	if p.Type != "folder" {
+		files := r.DB.Table("file").
+			Select(`'file' AS type, file.id, file.name, file.folder_id, file.owner_id, file.size, file.mime_type,
+				CASE WHEN file.owner_id = ? THEN 'owner'
+					WHEN CAST(resource_permission.permission AS TEXT) = ? OR granted.grant_rank = 2 THEN ?
+					ELSE ? END AS permission,
+				file.is_deleted, file.created_at, file.updated_at`,
+				userID, models.PermissionEditor, models.PermissionEditor, models.PermissionViewer).
+			Joins("LEFT JOIN resource_permission ON resource_permission.file_id = file.id AND resource_permission.user_id = ?", userID).
+			Joins("LEFT JOIN (?) AS granted ON granted.id = file.folder_id", granted).
+			Where(`LOWER(file.name) LIKE ? ESCAPE '\'`, pattern).
+			Where("file.upload_status = ? AND file.is_deleted = ?", "completed", p.Trashed)
+
+		if !p.Trashed {
+			files = files.Where("file.deleted_at IS NULL")
+		}
+
+		switch owner {
+		case "me":
+			files = files.Where("file.owner_id = ?", userID)
+		case "shared":
+			files = files.Where("file.owner_id <> ? AND (resource_permission.user_id IS NOT NULL OR granted.id IS NOT NULL)", userID)
+		default:
+			files = files.Where("(file.owner_id = ? OR resource_permission.user_id IS NOT NULL OR granted.id IS NOT NULL)", userID)
+		}
+
+		if p.MimeType != "" {
+			if strings.HasSuffix(p.MimeType, "/") {
+				files = files.Where(`file.mime_type LIKE ? ESCAPE '\'`, escapeLike(p.MimeType)+"%")
+			} else {
+				files = files.Where("file.mime_type = ?", p.MimeType)
+			}
+		}
+		if p.MinSize != nil {
+			files = files.Where("file.size >= ?", *p.MinSize)
+		}
+		if p.MaxSize != nil {
+			files = files.Where("file.size <= ?", *p.MaxSize)
+		}
+		if p.ModifiedFrom != nil {
+			files = files.Where("file.updated_at >= ?", *p.ModifiedFrom)
+		}
+		if p.ModifiedTo != nil {
+			files = files.Where("file.updated_at <= ?", *p.ModifiedTo)
+		}
+
+		queries = append(queries, files)
+	}
+
+	// Folders have no type or size, so those filters only ever match files
+	fileOnlyFilters := p.MimeType != "" || p.MinSize != nil || p.MaxSize != nil
+	if p.Type != "file" && !fileOnlyFilters {
+		folders := r.DB.Table("folder").
+			Select(`'folder' AS type, folder.id, folder.name, folder.parent_id AS folder_id, folder.owner_id, 0 AS size, NULL AS mime_type,
+				CASE WHEN folder.owner_id = ? THEN 'owner' WHEN granted.grant_rank = 2 THEN ? ELSE ? END AS permission,
+				folder.is_deleted, folder.created_at, folder.updated_at`,
+				userID, models.PermissionEditor, models.PermissionViewer).
+			Joins("LEFT JOIN (?) AS granted ON granted.id = folder.id", granted).
+			Where(`LOWER(folder.name) LIKE ? ESCAPE '\'`, pattern).
+			Where("folder.is_deleted = ?", p.Trashed)
+
+		switch owner {
+		case "me":
+			folders = folders.Where("folder.owner_id = ?", userID)
+		case "shared":
+			folders = folders.Where("folder.owner_id <> ? AND granted.id IS NOT NULL", userID)
+		default:
+			folders = folders.Where("(folder.owner_id = ? OR granted.id IS NOT NULL)", userID)
+		}
+
+		if p.ModifiedFrom != nil {
+			folders = folders.Where("folder.updated_at >= ?", *p.ModifiedFrom)
+		}
+		if p.ModifiedTo != nil {
+			folders = folders.Where("folder.updated_at <= ?", *p.ModifiedTo)
+		}
+
+		queries = append(queries, folders)
+	}
+
+	var results []SearchResult
+	if len(queries) == 0 {
+		return results, nil
+	}
+
+	sql := "SELECT * FROM (?) AS files"
+	args := []interface{}{queries[0]}
+	if len(queries) == 2 {
+		sql += " UNION ALL SELECT * FROM (?) AS folders"
+		args = append(args, queries[1])
+	}
+	// Folders first, like the dashboard lists them
+	sql += " ORDER BY type DESC, name, id LIMIT ? OFFSET ?"
+	args = append(args, p.Limit, p.Offset)
+
+	err := r.DB.Raw(sql, args...).Scan(&results).Error
+	return results, err
+}
+
+// FolderPaths maps each folder ID to its full path from the owner's root, e.g. "/a/b".
+func (r *SearchRepository) FolderPaths(folderIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	paths := make(map[uuid.UUID]string)
+	if len(folderIDs) == 0 {
+		return paths, nil
+	}
+
+	var rows []struct {
+		StartID uuid.UUID
+		Path    string
+	}
+	err := r.DB.Raw(`
+		WITH RECURSIVE chain AS (
+			SELECT id AS start_id, parent_id, CAST(name AS TEXT) AS path FROM folder WHERE id IN ?
+			UNION ALL
+			SELECT c.start_id, f.parent_id, f.name || '/' || c.path FROM folder f
+			JOIN chain c ON f.id = c.parent_id
+		)
+		SELECT start_id, path FROM chain WHERE parent_id IS NULL`, folderIDs).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		paths[row.StartID] = "/" + row.Path
+	}
+	return paths, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLinkExpired       = errors.New("this link has expired")
+	ErrLinkRevoked       = errors.New("this link has been revoked")
+	ErrLinkExhausted     = errors.New("this link has reached its download limit")
+	ErrLinkPasswordWrong = errors.New("incorrect password")
+)
+
+type ShareLinkRepository struct {
+	DB *gorm.DB
+}
+
+func NewShareLinkRepository(db *gorm.DB) *ShareLinkRepository {
+	return &ShareLinkRepository{DB: db}
+}
+
+// CreateLink fills in a fresh token and, when password is set, its bcrypt hash before saving link.
+func (r *ShareLinkRepository) CreateLink(link *models.ShareLink, password string) error {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	link.Token = base64.RawURLEncoding.EncodeToString(token)
+
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		hashed := string(hash)
+		link.PasswordHash = &hashed
+	}
+	link.CreatedAt = time.Now()
+	return r.DB.Create(link).Error
+}
+
+// LinksFor lists the links on a file or folder, newest first, revoked ones included.
+func (r *ShareLinkRepository) LinksFor(fileID *uuid.UUID, folderID *uuid.UUID) ([]models.ShareLink, error) {
+	query := r.DB.Order("created_at DESC")
+	if folderID != nil {
+		query = query.Where("folder_id = ?", *folderID)
+	} else {
+		query = query.Where("file_id = ?", *fileID)
+	}
+
+	var links []models.ShareLink
+	err := query.Find(&links).Error
+	return links, err
+}
+
+func (r *ShareLinkRepository) GetLink(linkID uuid.UUID) (models.ShareLink, error) {
+	var link models.ShareLink
+	err := r.DB.Where("id = ?", linkID).First(&link).Error
+	return link, err
+}
+
+// RevokeLink disables a link for good. The row stays so its counters remain visible.
+func (r *ShareLinkRepository) RevokeLink(link *models.ShareLink) error {
+	if link.RevokedAt != nil {
+		return nil
+	}
+	return r.DB.Model(link).Update("revoked_at", time.Now()).Error
+}
+
+// ResolveLink looks up token for an anonymous visitor and counts the access. It returns
+// gorm.ErrRecordNotFound for unknown tokens and ErrLinkRevoked or ErrLinkExpired for dead ones.
+func (r *ShareLinkRepository) ResolveLink(token string) (models.ShareLink, error) {
+	var link models.ShareLink
+	if err := r.DB.Where("token = ?", token).First(&link).Error; err != nil {
+		return models.ShareLink{}, err
+	}
+	if link.RevokedAt != nil {
+		return models.ShareLink{}, ErrLinkRevoked
+	}
+	if link.ExpiresAt != nil && !time.Now().Before(*link.ExpiresAt) {
+		return models.ShareLink{}, ErrLinkExpired
+	}
+
+	now := time.Now()
+	err := r.DB.Model(&link).UpdateColumns(map[string]interface{}{
+		"access_count":     gorm.Expr("access_count + 1"),
+		"last_accessed_at": now,
+	}).Error
+	return link, err
+}
+
+// CheckLinkPassword returns ErrLinkPasswordWrong unless the link has no password or password matches it.
+func CheckLinkPassword(link *models.ShareLink, password string) error {
+	if link.PasswordHash == nil {
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(*link.PasswordHash), []byte(password)) != nil {
+		return ErrLinkPasswordWrong
+	}
+	return nil
+}
+
+// ClaimDownload counts one download against the link, refusing with ErrLinkExhausted once
+// MaxDownloads have been served. The check and the increment are one statement, so concurrent
+// downloads cannot overshoot the limit.
+func (r *ShareLinkRepository) ClaimDownload(link *models.ShareLink) error {
+	result := r.DB.Model(&models.ShareLink{}).
+		Where("id = ? AND (max_downloads IS NULL OR download_count < max_downloads)", link.ID).
+		UpdateColumn("download_count", gorm.Expr("download_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLinkExhausted
+	}
+	return nil
+}
+
+// ReleaseDownload gives back a download claimed for a request that then failed.
+func (r *ShareLinkRepository) ReleaseDownload(link *models.ShareLink) error {
+	return r.DB.Model(&models.ShareLink{}).Where("id = ? AND download_count > 0", link.ID).
+		UpdateColumn("download_count", gorm.Expr("download_count - 1")).Error
+}
@@ -0,0 +1,158 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/dtos"
+	"github.com/richeek45/filedrive/models"
+	"gorm.io/gorm"
+)
+
+// FileGrants lists every grant giving access to file: those on the file itself and those on
+// the folders above it, which carry InheritedFrom.
+func (r *PermissionRepository) FileGrants(file *models.File) ([]dtos.GrantResponse, error) {
+	query := r.grants().Where("resource_permission.file_id = ?", file.ID)
+	if file.FolderID != nil {
+		query = r.grants().Where("(resource_permission.file_id = ? OR resource_permission.folder_id IN (?))",
+			file.ID, ancestorIDs(r.DB, *file.FolderID))
+	}
+	return scanGrants(query, uuid.Nil)
+}
+
+// FolderGrants is FileGrants for folders.
+func (r *PermissionRepository) FolderGrants(folder *models.Folder) ([]dtos.GrantResponse, error) {
+	query := r.grants().Where("resource_permission.folder_id IN (?)", ancestorIDs(r.DB, folder.ID))
+	return scanGrants(query, folder.ID)
+}
+
+func (r *PermissionRepository) grants() *gorm.DB {
+	return r.DB.Table("resource_permission").
+		Select(`resource_permission.id, resource_permission.user_id, resource_permission.permission,
+			resource_permission.granted_by, resource_permission.created_at,
+			resource_permission.folder_id AS inherited_from,
+			grantee.first_name || ' ' || grantee.last_name AS name, grantee.email,
+			granter.first_name || ' ' || granter.last_name AS granted_by_name, granter.email AS granted_by_email`).
+		Joins("JOIN users grantee ON grantee.id = resource_permission.user_id").
+		Joins("JOIN users granter ON granter.id = resource_permission.granted_by").
+		Order("resource_permission.created_at")
+}
+
+// scanGrants runs a grants query; a grant on folderID itself is not inherited.
+func scanGrants(query *gorm.DB, folderID uuid.UUID) ([]dtos.GrantResponse, error) {
+	var grants []dtos.GrantResponse
+	if err := query.Scan(&grants).Error; err != nil {
+		return nil, err
+	}
+	for i := range grants {
+		if grants[i].InheritedFrom != nil && *grants[i].InheritedFrom == folderID {
+			grants[i].InheritedFrom = nil
+		}
+	}
+	return grants, nil
+}
+
+// TransferFile makes newOwnerID the owner of file. The file moves to the new owner's root along
+// with its storage charge, and the previous owner keeps editor access.
+func (r *PermissionRepository) TransferFile(file *models.File, newOwnerID uuid.UUID) error {
+	previousOwnerID := file.OwnerID
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := moveStorageCharge(tx, []uuid.UUID{file.ID}, previousOwnerID, newOwnerID); err != nil {
+			return err
+		}
+		if err := tx.Model(file).Updates(map[string]interface{}{
+			"owner_id":  newOwnerID,
+			"folder_id": nil,
+		}).Error; err != nil {
+			return err
+		}
+		return handOverGrants(tx, "file_id", file.ID, previousOwnerID, newOwnerID)
+	})
+}
+
+// TransferFolder is TransferFile for a folder and everything below it, trashed content included.
+// Permanently deleted files stay with the previous owner and are restored to their root.
+func (r *PermissionRepository) TransferFolder(folder *models.Folder, newOwnerID uuid.UUID) error {
+	previousOwnerID := folder.OwnerID
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		folderIDs, err := NewFolderRepository(tx).subtreeFolderIDs(tx, folder.ID)
+		if err != nil {
+			return err
+		}
+		var fileIDs []uuid.UUID
+		if err := tx.Unscoped().Model(&models.File{}).Where("folder_id IN ?", folderIDs).Pluck("id", &fileIDs).Error; err != nil {
+			return err
+		}
+		if err := moveStorageCharge(tx, fileIDs, previousOwnerID, newOwnerID); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Folder{}).Where("id IN ?", folderIDs).Update("owner_id", newOwnerID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(folder).Update("parent_id", nil).Error; err != nil {
+			return err
+		}
+		if len(fileIDs) > 0 {
+			if err := tx.Unscoped().Model(&models.File{}).Where("id IN ?", fileIDs).Update("owner_id", newOwnerID).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.DeletedFile{}).Where("folder_id IN ?", folderIDs).Update("folder_id", nil).Error; err != nil {
+			return err
+		}
+		return handOverGrants(tx, "folder_id", folder.ID, previousOwnerID, newOwnerID)
+	})
+}
+
+// moveStorageCharge moves what fileIDs count against StorageUsed, their current and older
+// versions, from one user to another. The new owner's quota must have room for it.
+func moveStorageCharge(tx *gorm.DB, fileIDs []uuid.UUID, fromID uuid.UUID, toID uuid.UUID) error {
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	var fileBytes, versionBytes int64
+	if err := tx.Unscoped().Model(&models.File{}).
+		Where("id IN ? AND upload_status = ?", fileIDs, "completed").
+		Select("COALESCE(SUM(size), 0)").Scan(&fileBytes).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.FileVersion{}).
+		Where("file_id IN ?", fileIDs).
+		Select("COALESCE(SUM(size), 0)").Scan(&versionBytes).Error; err != nil {
+		return err
+	}
+	size := fileBytes + versionBytes
+	if size == 0 {
+		return nil
+	}
+
+	if err := chargeStorage(tx, toID, size); err != nil {
+		return err
+	}
+	return tx.Model(&models.Users{}).Where("id = ?", fromID).
+		UpdateColumn("storage_used", gorm.Expr("storage_used - ?", size)).Error
+}
+
+// handOverGrants drops the new owner's grants on the item, which ownership supersedes, and
+// gives the previous owner editor access in their place.
+func handOverGrants(tx *gorm.DB, column string, id uuid.UUID, previousOwnerID uuid.UUID, newOwnerID uuid.UUID) error {
+	if err := tx.Where(column+" = ? AND user_id IN ?", id, []uuid.UUID{previousOwnerID, newOwnerID}).
+		Delete(&models.ResourcePermission{}).Error; err != nil {
+		return err
+	}
+
+	grant := models.ResourcePermission{
+		GrantedBy:  newOwnerID,
+		UserID:     previousOwnerID,
+		Permission: models.PermissionEditor,
+		CreatedAt:  time.Now(),
+	}
+	if column == "file_id" {
+		grant.FileID = &id
+	} else {
+		grant.FolderID = &id
+	}
+	return tx.Create(&grant).Error
+}
@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultTrashRetentionDays = 30
+	MaxTrashRetentionDays     = 365
+)
+
+// DefaultTrashRetentionDays is the global retention, read from TRASH_RETENTION_DAYS.
+func DefaultTrashRetentionDays() int {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		return defaultTrashRetentionDays
+	}
+	return days
+}
+
+type TrashPolicy struct {
+	RetentionDays int
+	AutoEmpty     bool
+	// True when the user has no override and the global default applies
+	IsDefault bool
+}
+
+func (r *UserRepository) GetTrashPolicy(userID uuid.UUID) (TrashPolicy, error) {
+	return trashPolicy(r.DB, userID)
+}
+
+func (r *UserRepository) SetTrashPolicy(userID uuid.UUID, retentionDays int, autoEmpty bool) error {
+	return r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"retention_days", "auto_empty", "updated_at"}),
+	}).Create(&models.TrashRetentionPolicy{
+		UserID:        userID,
+		RetentionDays: retentionDays,
+		AutoEmpty:     autoEmpty,
+		UpdatedAt:     time.Now(),
+	}).Error
+}
+
+// ResetTrashPolicy drops the user's override so the global default applies again.
+func (r *UserRepository) ResetTrashPolicy(userID uuid.UUID) error {
+	return r.DB.Where("user_id = ?", userID).Delete(&models.TrashRetentionPolicy{}).Error
+}
+
+func trashPolicy(db *gorm.DB, userID uuid.UUID) (TrashPolicy, error) {
+	var policy models.TrashRetentionPolicy
+	err := db.Where("user_id = ?", userID).First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return TrashPolicy{RetentionDays: DefaultTrashRetentionDays(), AutoEmpty: true, IsDefault: true}, nil
+	}
+	if err != nil {
+		return TrashPolicy{}, err
+	}
+	return TrashPolicy{RetentionDays: policy.RetentionDays, AutoEmpty: policy.AutoEmpty}, nil
+}
+
+// RetentionScope selects the owners whose trash expires at Cutoff.
+type RetentionScope struct {
+	Cutoff time.Time
+	// Condition on an owner_id column, with its arguments
+	OwnerClause string
+	Args        []interface{}
+}
+
+// RetentionScopes groups users by retention: one scope for everyone on the global default and
+// one per distinct override. Users who turned auto-empty off are in none of them.
+func RetentionScopes(db *gorm.DB, now time.Time) ([]RetentionScope, error) {
+	scopes := []RetentionScope{{
+		Cutoff:      now.AddDate(0, 0, -DefaultTrashRetentionDays()),
+		OwnerClause: "owner_id NOT IN (SELECT user_id FROM trash_retention_policy)",
+	}}
+
+	var days []int
+	err := db.Model(&models.TrashRetentionPolicy{}).
+		Where("auto_empty = ?", true).
+		Distinct().Pluck("retention_days", &days).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range days {
+		scopes = append(scopes, RetentionScope{
+			Cutoff:      now.AddDate(0, 0, -d),
+			OwnerClause: "owner_id IN (SELECT user_id FROM trash_retention_policy WHERE auto_empty = ? AND retention_days = ?)",
+			Args:        []interface{}{true, d},
+		})
+	}
+	return scopes, nil
+}
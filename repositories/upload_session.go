@@ -0,0 +1,198 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/storage"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUploadSessionNotFound = errors.New("upload session not found")
+	ErrUploadSessionMismatch = errors.New("uploadId or key does not belong to this upload session")
+	ErrPartOutOfRange        = errors.New("part number out of range")
+	ErrUploadSizeMismatch    = errors.New("uploaded size does not match the declared size")
+)
+
+// UploadSession is an in-progress multipart upload, addressed by the PendingUpload ID we hand
+// out at initiate rather than by the storage backend's upload ID.
+type UploadSession struct {
+	Pending models.PendingUpload
+	// The pending File row the upload will finalize
+	File models.File
+}
+
+// DefaultUploadSessionTTL is how long an upload may sit idle before the janitor aborts it.
+const DefaultUploadSessionTTL = 24 * time.Hour
+
+// UploadSessionTTL reads UPLOAD_SESSION_TTL as a Go duration (e.g. "12h"), falling back to the default.
+func UploadSessionTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("UPLOAD_SESSION_TTL"))
+	if err != nil || ttl <= 0 {
+		return DefaultUploadSessionTTL
+	}
+	return ttl
+}
+
+// GetUploadSession loads one of the user's in-progress uploads.
+func (r *FileRepository) GetUploadSession(sessionID uuid.UUID, userID uuid.UUID) (*UploadSession, error) {
+	var session UploadSession
+	err := r.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session.Pending).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The file may belong to someone else when uploading into a folder shared with the user
+	err = r.DB.Where("s3_upload_id = ? AND object_key = ?",
+		session.Pending.UploadID, session.Pending.S3Key).First(&session.File).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// StaleUploadSessions returns uploads with no activity since cutoff. File is left zero
+// for sessions whose pending File row no longer exists.
+func (r *FileRepository) StaleUploadSessions(cutoff time.Time, limit int) ([]UploadSession, error) {
+	var pending []models.PendingUpload
+	err := r.DB.Where("updated_at < ?", cutoff).Order("updated_at").Limit(limit).Find(&pending).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]UploadSession, 0, len(pending))
+	for _, p := range pending {
+		session := UploadSession{Pending: p}
+		err := r.DB.Where("s3_upload_id = ? AND object_key = ? AND upload_status <> ?",
+			p.UploadID, p.S3Key, "completed").Limit(1).Find(&session.File).Error
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// TouchUploadSession marks the session as active so the janitor leaves it alone.
+func (r *FileRepository) TouchUploadSession(session *UploadSession) error {
+	return r.DB.Model(&session.Pending).Update("updated_at", time.Now()).Error
+}
+
+// AbortUploadSession discards an in-progress upload: the backend upload with its parts,
+// then the pending File and PendingUpload rows. Nothing has been charged for it yet.
+func (r *FileRepository) AbortUploadSession(session *UploadSession, store storage.ObjectStore) error {
+	err := store.AbortMultipartUpload(context.TODO(), session.Key(), session.UploadID())
+	if err != nil && !errors.Is(err, storage.ErrNoSuchUpload) {
+		return err
+	}
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if session.File.ID != uuid.Nil {
+			if err := tx.Unscoped().Delete(&session.File).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&session.Pending).Error
+	})
+}
+
+func (s *UploadSession) ID() uuid.UUID {
+	return s.Pending.ID
+}
+
+func (s *UploadSession) Key() string {
+	return s.Pending.S3Key
+}
+
+func (s *UploadSession) UploadID() string {
+	return s.Pending.UploadID
+}
+
+// Matches checks the storage upload ID and key a client sent alongside the session ID, if any.
+func (s *UploadSession) Matches(uploadID string, key string) error {
+	if (uploadID != "" && uploadID != s.Pending.UploadID) || (key != "" && key != s.Pending.S3Key) {
+		return ErrUploadSessionMismatch
+	}
+	return nil
+}
+
+func (s *UploadSession) CheckPart(partNumber int32) error {
+	if partNumber < 1 || int(partNumber) > s.Pending.TotalParts {
+		return fmt.Errorf("%w: %d is not between 1 and %d", ErrPartOutOfRange, partNumber, s.Pending.TotalParts)
+	}
+	return nil
+}
+
+// CheckParts validates the part list sent at completion: each part in range and listed once.
+func (s *UploadSession) CheckParts(parts []storage.CompletedPart) error {
+	seen := make(map[int32]bool, len(parts))
+	for _, p := range parts {
+		if err := s.CheckPart(p.PartNumber); err != nil {
+			return err
+		}
+		if seen[p.PartNumber] {
+			return fmt.Errorf("%w: %d is listed twice", ErrPartOutOfRange, p.PartNumber)
+		}
+		seen[p.PartNumber] = true
+	}
+	return nil
+}
+
+// PartChecksum returns the checksum declared for a part at initiate, if the upload declared any.
+func (s *UploadSession) PartChecksum(partNumber int32) storage.Checksum {
+	if s.Pending.PartChecksums == "" {
+		return storage.Checksum{}
+	}
+	checksums := strings.Split(s.Pending.PartChecksums, ",")
+	if partNumber < 1 || int(partNumber) > len(checksums) {
+		return storage.Checksum{}
+	}
+	return storage.Checksum{Algorithm: s.Pending.ChecksumAlgorithm, Value: checksums[partNumber-1]}
+}
+
+// DeclaredChecksum is the whole-file checksum declared at initiate, if any.
+func (s *UploadSession) DeclaredChecksum() storage.Checksum {
+	return declaredChecksum(&s.File)
+}
+
+func declaredChecksum(file *models.File) storage.Checksum {
+	if file.Checksum == nil || file.ChecksumAlgorithm == nil {
+		return storage.Checksum{}
+	}
+	return storage.Checksum{Algorithm: *file.ChecksumAlgorithm, Value: *file.Checksum}
+}
+
+// AssembledSize is the size the object will have once the listed parts are completed,
+// given the parts the backend reports as uploaded. It checks it against the declared size.
+func (s *UploadSession) AssembledSize(parts []storage.CompletedPart, uploaded []storage.Part) (int64, error) {
+	sizes := make(map[int32]int64, len(uploaded))
+	for _, p := range uploaded {
+		sizes[p.PartNumber] = p.Size
+	}
+
+	var total int64
+	for _, p := range parts {
+		size, ok := sizes[p.PartNumber]
+		if !ok {
+			return 0, fmt.Errorf("part %d has not been uploaded", p.PartNumber)
+		}
+		total += size
+	}
+	if total != s.File.Size {
+		return total, fmt.Errorf("%w: declared %d bytes, parts add up to %d", ErrUploadSizeMismatch, s.File.Size, total)
+	}
+	return total, nil
+}
@@ -0,0 +1,153 @@
+package repositories
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/storage"
+)
+
+// ContentCheck is what an assembled upload must match: the whole-file checksum declared at
+// initiate and the hex SHA-256 sent at completion. Either may be empty.
+type ContentCheck struct {
+	Declared storage.Checksum
+	SHA256   string
+}
+
+func (c ContentCheck) algorithms() []string {
+	var algorithms []string
+	if c.SHA256 != "" || c.Declared.Algorithm == storage.ChecksumSHA256 {
+		algorithms = append(algorithms, storage.ChecksumSHA256)
+	}
+	if c.Declared.Algorithm == storage.ChecksumCRC32C {
+		algorithms = append(algorithms, storage.ChecksumCRC32C)
+	}
+	return algorithms
+}
+
+// match compares the raw digests of the object against the check. It also returns the verified
+// hex SHA-256, used for deduplication, when one was computed.
+func (c ContentCheck) match(digests map[string][]byte) (bool, string) {
+	if c.SHA256 != "" && hex.EncodeToString(digests[storage.ChecksumSHA256]) != c.SHA256 {
+		return false, ""
+	}
+	if !c.Declared.IsZero() && base64.StdEncoding.EncodeToString(digests[c.Declared.Algorithm]) != c.Declared.Value {
+		return false, ""
+	}
+	if sum, ok := digests[storage.ChecksumSHA256]; ok {
+		return true, hex.EncodeToString(sum)
+	}
+	return true, ""
+}
+
+// CheckWithBackend verifies the object at key with the full-object checksums the backend already
+// keeps. known is false when the backend lacks one of them and the object would have to be read
+// back, which VerifyUpload does outside the request.
+func (c ContentCheck) CheckWithBackend(ctx context.Context, store storage.ObjectStore, key string) (ok bool, sha256 string, known bool, err error) {
+	algorithms := c.algorithms()
+	if len(algorithms) == 0 {
+		return true, "", true, nil
+	}
+	checksummer, supported := store.(storage.FullObjectChecksummer)
+	if !supported {
+		return false, "", false, nil
+	}
+
+	digests := make(map[string][]byte, len(algorithms))
+	for _, algorithm := range algorithms {
+		checksum, found, err := checksummer.FullObjectChecksum(ctx, key, algorithm)
+		if err != nil {
+			return false, "", false, err
+		}
+		if !found {
+			return false, "", false, nil
+		}
+		raw, err := base64.StdEncoding.DecodeString(checksum.Value)
+		if err != nil {
+			return false, "", false, err
+		}
+		digests[algorithm] = raw
+	}
+	ok, sha256 = c.match(digests)
+	return ok, sha256, true, nil
+}
+
+// Check reads the object at key back and verifies it.
+func (c ContentCheck) Check(ctx context.Context, store storage.ObjectStore, key string) (bool, string, error) {
+	algorithms := c.algorithms()
+	if len(algorithms) == 0 {
+		return true, "", nil
+	}
+	digests, _, err := storage.ObjectDigests(ctx, store, key, algorithms...)
+	if err != nil {
+		return false, "", err
+	}
+	ok, sha256 := c.match(digests)
+	return ok, sha256, nil
+}
+
+// MarkVerifying records a completed upload whose content still has to be checked. The hex
+// sha256 the client sent is kept on the file until VerifyUpload confirms or clears it.
+func (r *FileRepository) MarkVerifying(uploadID string, partsCount int, finalETag string, sha256 string) error {
+	updates := map[string]interface{}{
+		"upload_status":         "verifying",
+		"e_tag":                 finalETag,
+		"uploaded_chunks":       partsCount,
+		"uploaded_part_numbers": partsCount,
+		"sha256":                nil,
+	}
+	if sha256 != "" {
+		updates["sha256"] = sha256
+	}
+	return r.DB.Model(&models.File{}).Where("s3_upload_id = ?", uploadID).Updates(updates).Error
+}
+
+// VerifyUpload reads back an upload MarkVerifying left behind, checks it against what was
+// declared for it and finalizes the file as completed or error.
+func (r *FileRepository) VerifyUpload(fileID uuid.UUID, store storage.ObjectStore) error {
+	var file models.File
+	if err := r.DB.Where("id = ? AND upload_status = ?", fileID, "verifying").First(&file).Error; err != nil {
+		return err
+	}
+	if file.S3UploadID == nil {
+		return errors.New("verifying file has no upload ID")
+	}
+
+	check := ContentCheck{Declared: declaredChecksum(&file)}
+	if file.SHA256 != nil {
+		check.SHA256 = *file.SHA256
+	}
+	ok, sha256, err := check.Check(context.TODO(), store, file.ObjectKey)
+	if err != nil {
+		return err
+	}
+	status := "completed"
+	if !ok {
+		status = "error"
+	}
+
+	var finalETag string
+	if file.ETag != nil {
+		finalETag = *file.ETag
+	}
+	err = r.FinalizeFile(*file.S3UploadID, file.UploadedChunks, finalETag, status, sha256, store)
+	if errors.Is(err, ErrInsufficientStorage) {
+		// Other uploads used up the space while this one was being verified
+		err = r.FinalizeFile(*file.S3UploadID, file.UploadedChunks, finalETag, "error", "", store)
+	}
+	return err
+}
+
+// StalledVerifications returns files that have been verifying since before cutoff, whose
+// background check was lost, for example to a restart.
+func (r *FileRepository) StalledVerifications(cutoff time.Time, limit int) ([]models.File, error) {
+	var files []models.File
+	err := r.DB.Where("upload_status = ? AND updated_at < ?", "verifying", cutoff).
+		Order("updated_at").Limit(limit).Find(&files).Error
+	return files, err
+}
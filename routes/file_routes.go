@@ -13,12 +13,25 @@ func FileRoutes(api *gin.RouterGroup, fileController *controllers.FileController
 		fileApi.GET("/", fileController.GetFilesFromParentFolder)
 		fileApi.GET("/shared-by", fileController.SharedWithUserFiles)
 		fileApi.GET("/:fileId/download", fileController.GetDownloadURL)
+		fileApi.GET("/:fileId/content", fileController.StreamFileContent)
+		fileApi.POST("/download-archive", fileController.DownloadArchive)
 		fileApi.PATCH("/:fileId/rename", fileController.RenameFile)
 		fileApi.PATCH("/:fileId/trash", fileController.MoveToTrash)
+		fileApi.PATCH("/:fileId/move", fileController.MoveFile)
+		fileApi.POST("/:fileId/copy", fileController.CopyFile)
+		fileApi.GET("/:fileId/versions", fileController.ListFileVersions)
+		fileApi.GET("/:fileId/versions/:version/download", fileController.GetFileVersionDownloadURL)
+		fileApi.POST("/:fileId/versions/:version/restore", fileController.RestoreFileVersion)
+		fileApi.DELETE("/:fileId/versions/:version", fileController.DeleteFileVersion)
 		fileApi.GET("/sync-active-uploads", fileController.SyncUserUploads)
 		fileApi.POST("/share", fileController.ShareFilesToUsersByEmails)
+		fileApi.GET("/share", fileController.ListGrants)
+		fileApi.PATCH("/share/:permissionId", fileController.UpdateGrant)
+		fileApi.DELETE("/share/:permissionId", fileController.RevokeGrant)
+		fileApi.POST("/share/transfer", fileController.TransferOwnership)
 		fileApi.POST("/restore-file", fileController.RestoreFileById)
 		fileApi.POST("/restore-deleted-files", fileController.RestorePermanentlyDeletedFiles)
+		fileApi.POST("/trash/empty", fileController.EmptyTrash)
 	}
 
 	uploadApi := fileApi.Group("/uploads")
@@ -26,6 +39,8 @@ func FileRoutes(api *gin.RouterGroup, fileController *controllers.FileController
 		// Multipart Upload Routes
 		uploadApi.POST("/initiate", fileController.InitiateMultiPartUpload)
 		uploadApi.POST("/presign-part", fileController.PresignPart)
+		uploadApi.POST("/presign-parts", fileController.PresignParts)
 		uploadApi.POST("/complete", fileController.CompleteMultipartUpload)
+		uploadApi.POST("/abort", fileController.AbortMultipartUpload)
 	}
 }
@@ -13,6 +13,16 @@ func FolderRoutes(api *gin.RouterGroup, folderController *controllers.FolderCont
 	{
 		folderApi.GET("/", folderController.FindRootFolders)
 		folderApi.POST("/", folderController.CreateFolder)
+		folderApi.GET("/contents", folderController.GetFolderContents)
+		folderApi.GET("/shared-with-me", folderController.SharedWithMe)
+		folderApi.GET("/resolve", folderController.ResolvePath)
+		folderApi.GET("/:folderId/breadcrumbs", folderController.GetBreadcrumbs)
 		folderApi.PATCH("/:folderId/rename", folderController.RenameFolder)
+		folderApi.GET("/:folderId/download", folderController.DownloadFolder)
+		folderApi.PATCH("/:folderId/trash", folderController.MoveToTrash)
+		folderApi.POST("/restore-folder", folderController.RestoreFolder)
+		folderApi.DELETE("/:folderId", folderController.PermanentDeleteFolder)
+		folderApi.PATCH("/:folderId/move", folderController.MoveFolder)
+		folderApi.POST("/:folderId/copy", folderController.CopyFolder)
 	}
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/richeek45/filedrive/controllers"
+	"github.com/richeek45/filedrive/middleware"
+)
+
+func SearchRoutes(api *gin.RouterGroup, searchController *controllers.SearchController) {
+	searchApi := api.Group("/search")
+	searchApi.Use(middleware.AuthMiddleware())
+	{
+		searchApi.GET("", searchController.Search)
+	}
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/richeek45/filedrive/controllers"
+	"github.com/richeek45/filedrive/middleware"
+)
+
+// ShareLinkRoutes registers link management behind AuthMiddleware and the public endpoints,
+// which are authenticated by the link token alone, outside it.
+func ShareLinkRoutes(api *gin.RouterGroup, shareLinkController *controllers.ShareLinkController) {
+	linkApi := api.Group("/links")
+	linkApi.Use(middleware.AuthMiddleware())
+	{
+		linkApi.POST("", shareLinkController.CreateLink)
+		linkApi.GET("", shareLinkController.ListLinks)
+		linkApi.DELETE("/:linkId", shareLinkController.RevokeLink)
+	}
+
+	publicApi := api.Group("/public/links")
+	{
+		publicApi.GET("/:token", shareLinkController.GetPublicLink)
+		publicApi.POST("/:token/download", shareLinkController.DownloadPublicLink)
+	}
+}
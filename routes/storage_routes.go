@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/richeek45/filedrive/controllers"
+	"github.com/richeek45/filedrive/storage"
+)
+
+// LocalStorageRoutes are authenticated by the URL signature rather than AuthMiddleware,
+// exactly like presigned S3 URLs.
+func LocalStorageRoutes(router *gin.Engine, storageController *controllers.LocalStorageController) {
+	router.PUT(storage.LocalUploadPartPath, storageController.UploadPart)
+	router.GET(storage.LocalDownloadPath, storageController.Download)
+}
@@ -13,6 +13,9 @@ func RegisteredUserRoutes(api *gin.RouterGroup, userController *controllers.User
 	{
 		protected.GET("/profile", userController.GetProfile)
 		protected.POST("/", userController.CreateUser)
+		protected.GET("/trash-policy", userController.GetTrashPolicy)
+		protected.PUT("/trash-policy", userController.SetTrashPolicy)
+		protected.DELETE("/trash-policy", userController.ResetTrashPolicy)
 		//  protected.GET("/health", healthCheck)
 		// protected.PUT("/me", userController.UpdateProfile) // /api/users/me
 		// protected.DELETE("/me", userController.DeleteAccount)
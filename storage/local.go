@@ -0,0 +1,414 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	LocalUploadPartPath = "/storage/upload-part"
+	LocalDownloadPath   = "/storage/download"
+
+	localPresignExpiry = 15 * time.Minute
+)
+
+var ErrInvalidSignature = errors.New("storage: invalid or expired signature")
+
+// LocalStore is a disk-backed ObjectStore for development and air-gapped deployments.
+// Presigned URLs point back at the Go server, which verifies the HMAC signature
+// and reads or writes the files under root.
+type LocalStore struct {
+	root    string
+	baseURL string
+	secret  []byte
+	bucket  string
+}
+
+// NewLocalStore refuses an empty secret: the storage routes are unauthenticated, so anyone
+// could sign URLs for them.
+func NewLocalStore(root string, baseURL string, secret string, bucket string) (*LocalStore, error) {
+	if secret == "" {
+		return nil, errors.New("storage: a signing secret is required, set LOCAL_STORAGE_SECRET or JWT_SECRET")
+	}
+	if err := os.MkdirAll(filepath.Join(root, "objects"), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(root, "multipart"), 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  []byte(secret),
+		bucket:  bucket,
+	}, nil
+}
+
+func InitLocal(bucket string) *LocalStore {
+	root := os.Getenv("LOCAL_STORAGE_PATH")
+	if root == "" {
+		root = "./storage_data"
+	}
+
+	baseURL := os.Getenv("LOCAL_STORAGE_URL")
+	if baseURL == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		baseURL = "http://localhost:" + port
+	}
+
+	secret := os.Getenv("LOCAL_STORAGE_SECRET")
+	if secret == "" {
+		secret = os.Getenv("JWT_SECRET")
+	}
+
+	store, err := NewLocalStore(filepath.Join(root, bucket), baseURL, secret, bucket)
+	if err != nil {
+		log.Fatalf("failed to initialise local storage: %v", err)
+	}
+	return store
+}
+
+func (s *LocalStore) Bucket() string {
+	return s.bucket
+}
+
+func (s *LocalStore) CreateMultipartUpload(ctx context.Context, key string, contentType string, checksumAlgorithm string) (string, error) {
+	uploadID := uuid.New().String()
+	dir := s.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte(key), 0o644); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (s *LocalStore) PresignUploadPart(ctx context.Context, key string, uploadID string, partNumber int32, checksum Checksum, expiry time.Duration) (string, error) {
+	if err := s.checkUpload(key, uploadID); err != nil {
+		return "", err
+	}
+	params := url.Values{}
+	params.Set("key", key)
+	params.Set("uploadId", uploadID)
+	params.Set("partNumber", strconv.Itoa(int(partNumber)))
+	// Signed along with the rest, so WritePart can hold the part to it
+	if !checksum.IsZero() {
+		params.Set("checksumAlgorithm", checksum.Algorithm)
+		params.Set("checksum", checksum.Value)
+	}
+	return s.signURL(LocalUploadPartPath, params, expiry), nil
+}
+
+func (s *LocalStore) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []CompletedPart) (string, error) {
+	if err := s.checkUpload(key, uploadID); err != nil {
+		return "", err
+	}
+	if len(parts) == 0 {
+		return "", errors.New("storage: no parts to complete")
+	}
+
+	dst := s.objectPath(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".complete-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// Mirrors the S3 multipart ETag: md5 of the concatenated part md5s, suffixed with the part count
+	etagHash := md5.New()
+	for i, p := range parts {
+		if i > 0 && p.PartNumber <= parts[i-1].PartNumber {
+			return "", errors.New("storage: parts must be in ascending order")
+		}
+
+		partFile, err := os.Open(s.partPath(uploadID, p.PartNumber))
+		if err != nil {
+			return "", fmt.Errorf("storage: missing part %d: %w", p.PartNumber, err)
+		}
+		partHash := md5.New()
+		writers := []io.Writer{tmp, partHash}
+		checksumHash := newChecksumHash(p.Checksum.Algorithm)
+		if checksumHash != nil {
+			writers = append(writers, checksumHash)
+		}
+		_, err = io.Copy(io.MultiWriter(writers...), partFile)
+		partFile.Close()
+		if err != nil {
+			return "", err
+		}
+
+		sum := partHash.Sum(nil)
+		if strings.Trim(p.ETag, `"`) != hex.EncodeToString(sum) {
+			return "", fmt.Errorf("storage: etag mismatch for part %d", p.PartNumber)
+		}
+		if checksumHash != nil && base64.StdEncoding.EncodeToString(checksumHash.Sum(nil)) != p.Checksum.Value {
+			return "", fmt.Errorf("%w: part %d", ErrChecksumMismatch, p.PartNumber)
+		}
+		etagHash.Write(sum)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", err
+	}
+	os.RemoveAll(s.uploadDir(uploadID))
+
+	return fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(etagHash.Sum(nil)), len(parts)), nil
+}
+
+func (s *LocalStore) ListParts(ctx context.Context, key string, uploadID string) ([]Part, error) {
+	if err := s.checkUpload(key, uploadID); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(s.uploadDir(uploadID))
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []Part
+	for _, entry := range entries {
+		partNumber, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".part"))
+		if err != nil || !strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		etag, err := fileMD5(filepath.Join(s.uploadDir(uploadID), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{
+			PartNumber:   int32(partNumber),
+			ETag:         `"` + etag + `"`,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+func (s *LocalStore) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	if err := s.checkUpload(key, uploadID); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.uploadDir(uploadID))
+}
+
+func (s *LocalStore) CopyObject(ctx context.Context, srcKey string, dstKey string) error {
+	src, err := os.Open(s.objectPath(srcKey))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst := s.objectPath(dstKey)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, src)
+}
+
+func (s *LocalStore) DeleteObject(ctx context.Context, key string) error {
+	// S3 deletes are idempotent, so a missing object is not an error
+	if err := os.Remove(s.objectPath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) DeleteObjects(ctx context.Context, keys []string) ([]string, error) {
+	var deleted []string
+	for _, key := range keys {
+		if err := s.DeleteObject(ctx, key); err != nil {
+			log.Printf("local storage: failed to delete %s: %v", key, err)
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+	return deleted, nil
+}
+
+func (s *LocalStore) PresignGetObject(ctx context.Context, key string, contentDisposition string) (string, error) {
+	params := url.Values{}
+	params.Set("key", key)
+	params.Set("disposition", contentDisposition)
+	return s.signURL(LocalDownloadPath, params, 0), nil
+}
+
+func (s *LocalStore) GetObject(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	f, err := s.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// WritePart stores the body of a presigned part upload and returns its quoted md5 ETag.
+// A part that does not match the checksum signed into its URL is discarded.
+func (s *LocalStore) WritePart(key string, uploadID string, partNumber int32, body io.Reader, checksum Checksum) (string, error) {
+	if err := s.checkUpload(key, uploadID); err != nil {
+		return "", err
+	}
+
+	hash := md5.New()
+	writers := []io.Writer{hash}
+	checksumHash := newChecksumHash(checksum.Algorithm)
+	if checksumHash != nil {
+		writers = append(writers, checksumHash)
+	}
+	partPath := s.partPath(uploadID, partNumber)
+	if err := writeFileAtomic(partPath, io.TeeReader(body, io.MultiWriter(writers...))); err != nil {
+		return "", err
+	}
+	if checksumHash != nil && base64.StdEncoding.EncodeToString(checksumHash.Sum(nil)) != checksum.Value {
+		os.Remove(partPath)
+		return "", fmt.Errorf("%w: part %d", ErrChecksumMismatch, partNumber)
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+// Open returns the object stored under key for reading.
+func (s *LocalStore) Open(key string) (*os.File, error) {
+	return os.Open(s.objectPath(key))
+}
+
+// Verify checks the signature and expiry of a URL produced by signURL for the given path.
+func (s *LocalStore) Verify(urlPath string, query url.Values) error {
+	signature := query.Get("signature")
+	params := url.Values{}
+	for k, v := range query {
+		if k != "signature" {
+			params[k] = v
+		}
+	}
+
+	expires, err := strconv.ParseInt(params.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ErrInvalidSignature
+	}
+
+	expected := s.sign(urlPath, params)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *LocalStore) signURL(urlPath string, params url.Values, expiry time.Duration) string {
+	if expiry <= 0 {
+		expiry = localPresignExpiry
+	}
+	params.Set("expires", strconv.FormatInt(time.Now().Add(expiry).Unix(), 10))
+	params.Set("signature", s.sign(urlPath, params))
+	return s.baseURL + urlPath + "?" + params.Encode()
+}
+
+func (s *LocalStore) sign(urlPath string, params url.Values) string {
+	mac := hmac.New(sha256.New, s.secret)
+	// Encode sorts by key, so the canonical string is stable
+	mac.Write([]byte(urlPath + "?" + params.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *LocalStore) checkUpload(key string, uploadID string) error {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return fmt.Errorf("%w: %s", ErrNoSuchUpload, uploadID)
+	}
+	storedKey, err := os.ReadFile(filepath.Join(s.uploadDir(uploadID), "key"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrNoSuchUpload, uploadID)
+		}
+		return err
+	}
+	if string(storedKey) != key {
+		return fmt.Errorf("%w: key does not match upload %s", ErrNoSuchUpload, uploadID)
+	}
+	return nil
+}
+
+func (s *LocalStore) objectPath(key string) string {
+	// Clean against a rooted path so keys can never escape the objects directory
+	return filepath.Join(s.root, "objects", filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (s *LocalStore) uploadDir(uploadID string) string {
+	return filepath.Join(s.root, "multipart", uploadID)
+}
+
+func (s *LocalStore) partPath(uploadID string, partNumber int32) string {
+	return filepath.Join(s.uploadDir(uploadID), fmt.Sprintf("%05d.part", partNumber))
+}
+
+func writeFileAtomic(dst string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func fileMD5(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
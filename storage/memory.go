@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memoryUpload struct {
+	key       string
+	algorithm string
+	parts     map[int32][]byte
+	// Checksums bound to presigned part URLs, checked when the part arrives
+	checksums map[int32]Checksum
+}
+
+// MemoryStore is an in-process ObjectStore used by the test suite.
+// Presigned URLs use a memory:// scheme; parts are written with UploadPart directly.
+type MemoryStore struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+	uploads map[string]*memoryUpload
+}
+
+func NewMemoryStore(bucket string) *MemoryStore {
+	return &MemoryStore{
+		bucket:  bucket,
+		objects: make(map[string][]byte),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+func (s *MemoryStore) Bucket() string {
+	return s.bucket
+}
+
+func (s *MemoryStore) CreateMultipartUpload(ctx context.Context, key string, contentType string, checksumAlgorithm string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uploadID := uuid.New().String()
+	s.uploads[uploadID] = &memoryUpload{
+		key:       key,
+		algorithm: checksumAlgorithm,
+		parts:     make(map[int32][]byte),
+		checksums: make(map[int32]Checksum),
+	}
+	return uploadID, nil
+}
+
+func (s *MemoryStore) PresignUploadPart(ctx context.Context, key string, uploadID string, partNumber int32, checksum Checksum, expiry time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.upload(key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if !checksum.IsZero() {
+		upload.checksums[partNumber] = checksum
+	}
+	return fmt.Sprintf("memory://%s/%s?uploadId=%s&partNumber=%d", s.bucket, url.PathEscape(key), uploadID, partNumber), nil
+}
+
+// UploadPart stores data as a part of an in-progress upload and returns its quoted md5 ETag,
+// standing in for the PUT a client would make to the presigned URL.
+func (s *MemoryStore) UploadPart(key string, uploadID string, partNumber int32, data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.upload(key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if checksum, ok := upload.checksums[partNumber]; ok && !checksum.Matches(data) {
+		return "", fmt.Errorf("%w: part %d", ErrChecksumMismatch, partNumber)
+	}
+	upload.parts[partNumber] = append([]byte(nil), data...)
+	return md5ETag(data), nil
+}
+
+func (s *MemoryStore) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []CompletedPart) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.upload(key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) == 0 {
+		return "", errors.New("storage: no parts to complete")
+	}
+
+	var object []byte
+	etagHash := md5.New()
+	for _, p := range parts {
+		data, ok := upload.parts[p.PartNumber]
+		if !ok {
+			return "", fmt.Errorf("storage: missing part %d", p.PartNumber)
+		}
+		if strings.Trim(p.ETag, `"`) != strings.Trim(md5ETag(data), `"`) {
+			return "", fmt.Errorf("storage: etag mismatch for part %d", p.PartNumber)
+		}
+		if upload.algorithm != "" && p.Checksum.IsZero() {
+			return "", fmt.Errorf("storage: missing %s checksum for part %d", upload.algorithm, p.PartNumber)
+		}
+		if !p.Checksum.IsZero() && !p.Checksum.Matches(data) {
+			return "", fmt.Errorf("%w: part %d", ErrChecksumMismatch, p.PartNumber)
+		}
+		sum := md5.Sum(data)
+		etagHash.Write(sum[:])
+		object = append(object, data...)
+	}
+
+	s.objects[key] = object
+	delete(s.uploads, uploadID)
+	return fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(etagHash.Sum(nil)), len(parts)), nil
+}
+
+func (s *MemoryStore) ListParts(ctx context.Context, key string, uploadID string) ([]Part, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.upload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []Part
+	for number, data := range upload.parts {
+		parts = append(parts, Part{
+			PartNumber:   number,
+			ETag:         md5ETag(data),
+			Size:         int64(len(data)),
+			LastModified: time.Now(),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+func (s *MemoryStore) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.upload(key, uploadID); err != nil {
+		return err
+	}
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+func (s *MemoryStore) CopyObject(ctx context.Context, srcKey string, dstKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[srcKey]
+	if !ok {
+		return fmt.Errorf("storage: %s: %w", srcKey, os.ErrNotExist)
+	}
+	s.objects[dstKey] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *MemoryStore) DeleteObject(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteObjects(ctx context.Context, keys []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.objects, key)
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) PresignGetObject(ctx context.Context, key string, contentDisposition string) (string, error) {
+	return fmt.Sprintf("memory://%s/%s?disposition=%s", s.bucket, url.PathEscape(key), url.QueryEscape(contentDisposition)), nil
+}
+
+func (s *MemoryStore) GetObject(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("storage: %s: %w", key, os.ErrNotExist)
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(append([]byte(nil), data...))), nil
+}
+
+// FullObjectChecksum computes the checksum from the stored bytes, standing in for a backend
+// that records one on every object.
+func (s *MemoryStore) FullObjectChecksum(ctx context.Context, key string, algorithm string) (Checksum, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return Checksum{}, false, fmt.Errorf("storage: %s: %w", key, os.ErrNotExist)
+	}
+	value := ComputeChecksum(algorithm, data)
+	return Checksum{Algorithm: algorithm, Value: value}, value != "", nil
+}
+
+// Object returns a copy of the stored object, if present.
+func (s *MemoryStore) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[key]
+	return append([]byte(nil), data...), ok
+}
+
+// ObjectCount returns how many objects are stored.
+func (s *MemoryStore) ObjectCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.objects)
+}
+
+func (s *MemoryStore) upload(key string, uploadID string) (*memoryUpload, error) {
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchUpload, uploadID)
+	}
+	return upload, nil
+}
+
+func md5ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,25 @@
+package storage
+
+// S3 multipart limits; the other backends follow them so uploads behave the same everywhere.
+const (
+	MaxUploadParts = 10000
+	MinPartSize    = 5 << 20
+	MaxPartSize    = 5 << 30
+	MaxObjectSize  = 5 << 40
+)
+
+// partSizeStep keeps recommended part sizes on whole MiB boundaries.
+const partSizeStep = 1 << 20
+
+// RecommendedPartSize is the smallest whole-MiB part size, at least MinPartSize, that splits
+// an object of size bytes into no more than MaxUploadParts parts.
+func RecommendedPartSize(size int64) int64 {
+	partSize := (size + MaxUploadParts - 1) / MaxUploadParts
+	partSize = (partSize + partSizeStep - 1) / partSizeStep * partSizeStep
+	return max(partSize, MinPartSize)
+}
+
+// PartCount is the number of parts of partSize bytes needed for size bytes, at least one.
+func PartCount(size int64, partSize int64) int {
+	return max(int((size+partSize-1)/partSize), 1)
+}
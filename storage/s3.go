@@ -2,13 +2,23 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
 	"google.golang.org/api/idtoken"
 )
 
@@ -71,35 +81,307 @@ func (p *GCPCredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials,
 	}, nil
 }
 
+// InitS3 builds the S3 client from the environment.
+//
+// S3_AUTH_MODE selects the credential chain:
+//   - "web_identity" (default): GCP identity token exchanged through AssumeRoleWithWebIdentity
+//   - "static": S3_ACCESS_KEY_ID / S3_SECRET_ACCESS_KEY (and optional S3_SESSION_TOKEN)
+//   - "default": the standard AWS chain (env vars, shared config, instance role)
+//
+// S3_ENDPOINT and S3_FORCE_PATH_STYLE point the client at S3-compatible stores such as MinIO or Ceph RGW.
 func InitS3() *s3.Client {
 	ctx := context.Background()
 
 	region := os.Getenv("LOCATION")
-
-	customProvider := &GCPCredentialsProvider{
-		ctx:      ctx,
-		RoleArn:  os.Getenv("AWS_ROLE_ARN"),
-		audience: os.Getenv("AWS_OIDC_AUDIENCE"),
-		region:   region,
+	if region == "" {
+		region = "us-east-1"
 	}
 
-	cfg, err := config.LoadDefaultConfig(ctx,
+	opts := []func(*config.LoadOptions) error{
 		config.WithRegion(region),
-		config.WithCredentialsProvider(aws.NewCredentialsCache(customProvider)),
-	)
+	}
+
+	switch mode := os.Getenv("S3_AUTH_MODE"); mode {
+	case "", "web_identity":
+		customProvider := &GCPCredentialsProvider{
+			ctx:      ctx,
+			RoleArn:  os.Getenv("AWS_ROLE_ARN"),
+			audience: os.Getenv("AWS_OIDC_AUDIENCE"),
+			region:   region,
+		}
+		opts = append(opts, config.WithCredentialsProvider(aws.NewCredentialsCache(customProvider)))
+	case "static":
+		accessKey := os.Getenv("S3_ACCESS_KEY_ID")
+		secretKey := os.Getenv("S3_SECRET_ACCESS_KEY")
+		if accessKey == "" || secretKey == "" {
+			log.Fatal("S3_AUTH_MODE=static requires S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+		}
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(accessKey, secretKey, os.Getenv("S3_SESSION_TOKEN")),
+		))
+	case "default":
+		// Leave the credential chain to LoadDefaultConfig
+	default:
+		log.Fatalf("unknown S3_AUTH_MODE %q", mode)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-	// 	// Use the credentials from the AssumeRole output
-	// 	o.Credentials = aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
-	// 		*out.Credentials.AccessKeyId,
-	// 		*out.Credentials.SecretAccessKey,
-	// 		*out.Credentials.SessionToken,
-	// 	))
-	// })
+	endpoint := os.Getenv("S3_ENDPOINT")
+	forcePathStyle, _ := strconv.ParseBool(os.Getenv("S3_FORCE_PATH_STYLE"))
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = forcePathStyle
+	})
+}
+
+// S3Store is the ObjectStore backed by an AWS S3 bucket.
+type S3Store struct {
+	Client  *s3.Client
+	presign *s3.PresignClient
+	bucket  string
+}
+
+func NewS3Store(client *s3.Client, bucket string) *S3Store {
+	return &S3Store{
+		Client:  client,
+		presign: s3.NewPresignClient(client),
+		bucket:  bucket,
+	}
+}
+
+func (s *S3Store) Bucket() string {
+	return s.bucket
+}
+
+func (s *S3Store) CreateMultipartUpload(ctx context.Context, key string, contentType string, checksumAlgorithm string) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}
+	if checksumAlgorithm != "" {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithm(checksumAlgorithm)
+		// S3 can combine CRC part checksums into one for the whole object; SHA-256 stays composite
+		if checksumAlgorithm == ChecksumCRC32C {
+			input.ChecksumType = types.ChecksumTypeFullObject
+		}
+	}
+	resp, err := s.Client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.UploadId), nil
+}
+
+func (s *S3Store) PresignUploadPart(ctx context.Context, key string, uploadID string, partNumber int32, checksum Checksum, expiry time.Duration) (string, error) {
+	input := &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+	}
+	// S3 checks the part against the signed x-amz-checksum-* header the client sends
+	switch checksum.Algorithm {
+	case ChecksumSHA256:
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+		input.ChecksumSHA256 = aws.String(checksum.Value)
+	case ChecksumCRC32C:
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32c
+		input.ChecksumCRC32C = aws.String(checksum.Value)
+	}
+	req, err := s.presign.PresignUploadPart(ctx, input, func(o *s3.PresignOptions) {
+		if expiry > 0 {
+			o.Expires = expiry
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3Store) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []CompletedPart) (string, error) {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		part := types.CompletedPart{
+			PartNumber: aws.Int32(p.PartNumber),
+			ETag:       aws.String(p.ETag),
+		}
+		switch p.Checksum.Algorithm {
+		case ChecksumSHA256:
+			part.ChecksumSHA256 = aws.String(p.Checksum.Value)
+		case ChecksumCRC32C:
+			part.ChecksumCRC32C = aws.String(p.Checksum.Value)
+		}
+		completed = append(completed, part)
+	}
+
+	result, err := s.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: completed,
+		},
+	})
+	if err != nil {
+		return "", mapS3Error(err)
+	}
+	return aws.ToString(result.ETag), nil
+}
+
+// FullObjectChecksum reads the object's checksum from HeadObject. Composite checksums of
+// multipart uploads are checksums of the part checksums, not of the content, and are not returned.
+func (s *S3Store) FullObjectChecksum(ctx context.Context, key string, algorithm string) (Checksum, bool, error) {
+	resp, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return Checksum{}, false, mapS3Error(err)
+	}
+	if resp.ChecksumType != types.ChecksumTypeFullObject {
+		return Checksum{}, false, nil
+	}
+
+	var value *string
+	switch algorithm {
+	case ChecksumSHA256:
+		value = resp.ChecksumSHA256
+	case ChecksumCRC32C:
+		value = resp.ChecksumCRC32C
+	}
+	if value == nil {
+		return Checksum{}, false, nil
+	}
+	return Checksum{Algorithm: algorithm, Value: *value}, true, nil
+}
 
-	return s3.NewFromConfig(cfg)
+func (s *S3Store) ListParts(ctx context.Context, key string, uploadID string) ([]Part, error) {
+	parts := make([]Part, 0)
+	// S3 pages at 1000 parts and an upload can have up to 10,000
+	paginator := s3.NewListPartsPaginator(s.Client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapS3Error(err)
+		}
+		for _, p := range out.Parts {
+			parts = append(parts, Part{
+				PartNumber:   aws.ToInt32(p.PartNumber),
+				ETag:         aws.ToString(p.ETag),
+				Size:         aws.ToInt64(p.Size),
+				LastModified: aws.ToTime(p.LastModified),
+			})
+		}
+	}
+	return parts, nil
+}
+
+func (s *S3Store) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	_, err := s.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return mapS3Error(err)
+}
+
+func (s *S3Store) CopyObject(ctx context.Context, srcKey string, dstKey string) error {
+	copySource := s.bucket + "/" + url.PathEscape(srcKey)
+	_, err := s.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		CopySource: aws.String(copySource),
+		Key:        aws.String(dstKey),
+	})
+	return err
+}
+
+func (s *S3Store) DeleteObject(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3Store) DeleteObjects(ctx context.Context, keys []string) ([]string, error) {
+	objects := make([]types.ObjectIdentifier, 0, len(keys))
+	for _, key := range keys {
+		objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+	}
+
+	output, err := s.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(false)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for _, o := range output.Deleted {
+		deleted = append(deleted, aws.ToString(o.Key))
+	}
+	return deleted, nil
+}
+
+func (s *S3Store) PresignGetObject(ctx context.Context, key string, contentDisposition string) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(s.bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(contentDisposition),
+	})
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3Store) GetObject(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if length == 0 {
+		// A range cannot ask for zero bytes: bytes=0--1 is rejected, so there is nothing to fetch
+		return http.NoBody, nil
+	}
+	if length > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	out, err := s.Client.GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func mapS3Error(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchUpload":
+			return fmt.Errorf("%w: %v", ErrNoSuchUpload, err)
+		case "BadDigest":
+			return fmt.Errorf("%w: %v", ErrChecksumMismatch, err)
+		}
+	}
+	return err
 }
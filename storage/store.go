@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNoSuchUpload is returned when a multipart upload no longer exists in the backend
+// (aborted, completed or removed by a lifecycle rule).
+var ErrNoSuchUpload = errors.New("storage: no such upload")
+
+// Part is an uploaded part of an in-progress multipart upload.
+// Field names match the S3 JSON shape the web uploader already consumes.
+type Part struct {
+	PartNumber   int32
+	ETag         string
+	Size         int64
+	LastModified time.Time
+}
+
+type CompletedPart struct {
+	PartNumber int32
+	ETag       string
+	// Set by the server from the checksums declared at initiate, never by the client
+	Checksum Checksum `json:"-"`
+}
+
+// ObjectStore is the set of object storage operations filedrive depends on.
+// All keys are relative to the store's bucket.
+type ObjectStore interface {
+	Bucket() string
+
+	// A non-empty checksumAlgorithm makes every part of the upload carry a checksum of that kind.
+	CreateMultipartUpload(ctx context.Context, key string, contentType string, checksumAlgorithm string) (string, error)
+	// A non-zero checksum is bound to the URL; the backend rejects a part whose data does not match.
+	// A zero expiry uses the backend's default lifetime for presigned URLs.
+	PresignUploadPart(ctx context.Context, key string, uploadID string, partNumber int32, checksum Checksum, expiry time.Duration) (string, error)
+	CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []CompletedPart) (string, error)
+	ListParts(ctx context.Context, key string, uploadID string) ([]Part, error)
+	// AbortMultipartUpload discards an upload and its parts; ErrNoSuchUpload if it is already gone.
+	AbortMultipartUpload(ctx context.Context, key string, uploadID string) error
+
+	CopyObject(ctx context.Context, srcKey string, dstKey string) error
+	DeleteObject(ctx context.Context, key string) error
+	// DeleteObjects removes keys in one batch and returns the keys that were actually deleted.
+	DeleteObjects(ctx context.Context, keys []string) ([]string, error)
+
+	PresignGetObject(ctx context.Context, key string, contentDisposition string) (string, error)
+	// GetObject streams length bytes of the object starting at offset; a negative length reads to the end.
+	GetObject(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// FullObjectChecksummer is implemented by backends that keep checksums of whole objects, so an
+// upload can be verified without reading it back through the server.
+type FullObjectChecksummer interface {
+	// FullObjectChecksum returns the object's checksum of the given algorithm; ok is false when
+	// the backend does not have one of that kind for it.
+	FullObjectChecksum(ctx context.Context, key string, algorithm string) (checksum Checksum, ok bool, err error)
+}
+
+// InitObjectStore picks the backend from STORAGE_BACKEND ("s3" by default, or "local").
+func InitObjectStore(bucket string) ObjectStore {
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "local":
+		return InitLocal(bucket)
+	default:
+		return NewS3Store(InitS3(), bucket)
+	}
+}
@@ -1,4 +1,4 @@
-import React, { useState } from "react";
+import React, { useEffect, useRef, useState } from "react";
 import Sidebar from "./Sidebar";
 import { useFolders } from "../hooks/useFolders";
 import { NewFolderModal } from "./FolderModal";
@@ -48,7 +48,6 @@ const ShareModal = ({ isOpen, onClose, shareFile, itemName, file }: any) => {
   const handleShareFile = async () => {
     await shareFile({
       fileId: file.id,
-      folderId: file.parentId,
       permission: "viewer",
       emails: [email],
     });
@@ -505,8 +504,24 @@ const Dashboard: React.FC = () => {
     isCreating,
     isLoading,
     activeUploads,
+    hasMore,
+    loadMore,
+    isLoadingMore,
   } = useFolders(folderId || null, isTrashView, sharedWithMeView);
 
+  // Fetch the next page of the listing when the end of it scrolls into view
+  const loadMoreRef = useRef<HTMLDivElement>(null);
+  useEffect(() => {
+    const sentinel = loadMoreRef.current;
+    if (!sentinel || !hasMore) return;
+
+    const observer = new IntersectionObserver((entries) => {
+      if (entries[0].isIntersecting && !isLoadingMore) loadMore();
+    });
+    observer.observe(sentinel);
+    return () => observer.disconnect();
+  }, [hasMore, isLoadingMore, loadMore]);
+
   const handleNewFolder = (name: string) => {
     if (isCreating) return;
 
@@ -654,6 +669,11 @@ const Dashboard: React.FC = () => {
         </header>
 
         {renderContent()}
+        {hasMore && (
+          <div ref={loadMoreRef} className="py-6 text-center text-gray-500">
+            {isLoadingMore ? "Loading more..." : ""}
+          </div>
+        )}
       </main>
 
       {/* RIGHT DRAWER OVERLAY */}
@@ -1,8 +1,13 @@
-import { useQuery, useMutation, useQueryClient } from "@tanstack/react-query";
 import {
-  fetchFolders,
+  useQuery,
+  useInfiniteQuery,
+  useMutation,
+  useQueryClient,
+} from "@tanstack/react-query";
+import type { InfiniteData } from "@tanstack/react-query";
+import {
+  fetchFolderContents,
   createFolderApi,
-  fetchFiles,
   downloadFileApi,
   moveToTrashApi,
   renameFileApi,
@@ -10,10 +15,11 @@ import {
   renameFolderApi,
   shareFilesApi,
   fetchSharedFiles,
+  fetchSharedFolders,
   restoreAllDeletedFilesApi,
   restoreFileApi,
 } from "../services/folder.service";
-import type { Folder } from "../services/folder.service";
+import type { FolderContentsPage } from "../services/folder.service";
 import { uploadFileInParts } from "../lib/upload";
 import { useEffect, useState } from "react";
 
@@ -26,17 +32,19 @@ export const useFolders = (
   const queryClient = useQueryClient();
 
   useEffect(() => {
-    queryClient.removeQueries({ queryKey: ["files"] });
+    queryClient.removeQueries({ queryKey: ["contents"] });
     queryClient.removeQueries({ queryKey: ["folders"] });
-    queryClient.resetQueries({ queryKey: ["files"] });
+    queryClient.resetQueries({ queryKey: ["contents"] });
     queryClient.resetQueries({ queryKey: ["folders"] });
   }, [isShared, isTrash, queryClient]);
 
-  const foldersQuery = useQuery({
-    // Important: Key must include parentId so TanStack treats each folder level as a unique cache
-    queryKey: ["folders", parentId, isTrash],
-    queryFn: () => fetchFolders(parentId, isTrash),
-    enabled: !isShared,
+  // Shared folders are listed at the top of "Shared with me" and browsed like any other folder
+  const isSharedRoot = isShared && !parentId;
+
+  const sharedFoldersQuery = useQuery({
+    queryKey: ["folders", "shared"],
+    queryFn: () => fetchSharedFolders(),
+    enabled: isSharedRoot,
   });
 
   const syncQuery = useQuery({
@@ -46,10 +54,16 @@ export const useFolders = (
     refetchOnWindowFocus: true,
   });
 
-  const filesQuery = useQuery({
-    queryKey: ["files", parentId, isTrash, isShared],
-    queryFn: () => fetchFiles(parentId, isTrash, isShared),
-    enabled: (syncQuery.isSuccess || syncQuery.isError) && !isShared,
+  // Subfolders and files come back together from /folders/contents, one page at a time;
+  // the dashboard asks for the next page as the user scrolls
+  const contentsQuery = useInfiniteQuery({
+    // Important: Key must include parentId so TanStack treats each folder level as a unique cache
+    queryKey: ["contents", parentId, isTrash, isShared],
+    queryFn: ({ pageParam }) =>
+      fetchFolderContents(parentId, isTrash, pageParam),
+    initialPageParam: null as string | null,
+    getNextPageParam: (lastPage) => lastPage.nextCursor ?? undefined,
+    enabled: (syncQuery.isSuccess || syncQuery.isError) && !isSharedRoot,
   });
 
   const sharedFilesQuery = useQuery({
@@ -72,20 +86,22 @@ export const useFolders = (
     mutationFn: ({ id, name }: { id: string; name: string }) =>
       renameFileApi(id, name),
     onSuccess: () =>
-      queryClient.invalidateQueries({ queryKey: ["files", parentId] }),
+      queryClient.invalidateQueries({ queryKey: ["contents", parentId] }),
   });
 
   const renameFolder = useMutation({
     mutationFn: ({ id, name }: { id: string; name: string }) =>
       renameFolderApi(id, name),
     onSuccess: () =>
-      queryClient.invalidateQueries({ queryKey: ["folders", parentId] }),
+      queryClient.invalidateQueries({ queryKey: ["contents", parentId] }),
   });
 
   const moveToTrash = useMutation({
     mutationFn: (fileId: string) => moveToTrashApi(fileId),
     onSuccess: () => {
-      queryClient.invalidateQueries({ queryKey: ["files", parentId, isTrash] });
+      queryClient.invalidateQueries({
+        queryKey: ["contents", parentId, isTrash],
+      });
       queryClient.invalidateQueries({ queryKey: ["authUser"] });
     },
   });
@@ -94,42 +110,50 @@ export const useFolders = (
     mutationFn: createFolderApi,
 
     onSuccess: () => {
-      queryClient.invalidateQueries({ queryKey: ["folders", parentId] });
+      queryClient.invalidateQueries({ queryKey: ["contents", parentId] });
     },
 
     // Optional: Optimistic update
     onMutate: async (newFolder) => {
-      const queryKey = ["folders", parentId];
+      const queryKey = ["contents", parentId, isTrash, isShared];
       await queryClient.cancelQueries({ queryKey });
 
-      const previousFolders = queryClient.getQueryData<Folder[]>(queryKey);
+      const previousContents =
+        queryClient.getQueryData<InfiniteData<FolderContentsPage>>(queryKey);
 
-      if (previousFolders) {
-        queryClient.setQueryData<Folder[]>(
-          ["folders"],
-          [
-            ...previousFolders,
+      if (previousContents && previousContents.pages.length > 0) {
+        const [firstPage, ...rest] = previousContents.pages;
+        queryClient.setQueryData<InfiniteData<FolderContentsPage>>(queryKey, {
+          ...previousContents,
+          pages: [
             {
-              id: "temp-id",
-              name: newFolder.name,
-              parentId: newFolder.parentId ?? null,
-              createdAt: new Date().toISOString(),
-              updatedAt: new Date().toISOString(),
-              folders: null,
-              files: null,
+              ...firstPage,
+              folders: [
+                ...firstPage.folders,
+                {
+                  id: "temp-id",
+                  name: newFolder.name,
+                  parentId: newFolder.parentId ?? null,
+                  createdAt: new Date().toISOString(),
+                  updatedAt: new Date().toISOString(),
+                  folders: null,
+                  files: null,
+                },
+              ],
             },
+            ...rest,
           ],
-        );
+        });
       }
 
-      return { previousFolders };
+      return { previousContents };
     },
 
     onError: (_, __, context) => {
-      if (context?.previousFolders) {
+      if (context?.previousContents) {
         queryClient.setQueryData(
-          ["folders", parentId],
-          context.previousFolders,
+          ["contents", parentId, isTrash, isShared],
+          context.previousContents,
         );
       }
     },
@@ -146,9 +170,8 @@ export const useFolders = (
         delete newUploads[file.name];
         return newUploads;
       });
-      queryClient.invalidateQueries({ queryKey: ["files", parentId, isTrash] });
       queryClient.invalidateQueries({
-        queryKey: ["folders", parentId, isTrash],
+        queryKey: ["contents", parentId, isTrash],
       });
       queryClient.invalidateQueries({ queryKey: ["files", "sync"] });
       queryClient.invalidateQueries({ queryKey: ["authUser"] });
@@ -162,14 +185,13 @@ export const useFolders = (
       emails,
       permission,
     }: {
-      fileId: string;
-      folderId: string;
+      fileId?: string;
+      folderId?: string;
       emails: string[];
       permission: string;
     }) => shareFilesApi({ fileId, folderId, emails, permission }),
     onSuccess: () => {
-      queryClient.invalidateQueries({ queryKey: ["files", parentId] });
-      queryClient.invalidateQueries({ queryKey: ["folders", parentId] });
+      queryClient.invalidateQueries({ queryKey: ["contents", parentId] });
       queryClient.invalidateQueries({ queryKey: ["files", "sync"] });
     },
   });
@@ -177,7 +199,7 @@ export const useFolders = (
   const restoreFileMutation = useMutation({
     mutationFn: (payload: { fileId: string }) => restoreFileApi(payload),
     onSuccess: () => {
-      queryClient.invalidateQueries({ queryKey: ["files"] });
+      queryClient.invalidateQueries({ queryKey: ["contents"] });
       queryClient.invalidateQueries({ queryKey: ["deleted-files"] });
     },
   });
@@ -185,17 +207,21 @@ export const useFolders = (
   const restoreAllDeletedFilesMutation = useMutation({
     mutationFn: restoreAllDeletedFilesApi,
     onSuccess: () => {
-      queryClient.invalidateQueries({ queryKey: ["files"] });
+      queryClient.invalidateQueries({ queryKey: ["contents"] });
       queryClient.invalidateQueries({ queryKey: ["deleted-files"] });
     },
   });
 
-  const files = filesQuery.data ?? [];
-  const sharedFiles = sharedFilesQuery.data ?? [];
-  const renderFiles = isShared ? sharedFiles : files;
+  const pages = contentsQuery.data?.pages ?? [];
+  const renderFolders = isSharedRoot
+    ? (sharedFoldersQuery.data ?? [])
+    : pages.flatMap((page) => page.folders);
+  const renderFiles = isSharedRoot
+    ? (sharedFilesQuery.data ?? [])
+    : pages.flatMap((page) => page.files);
 
   return {
-    folders: foldersQuery.data ?? [],
+    folders: renderFolders,
     files: renderFiles,
     moveToTrash: moveToTrash.mutate,
     downloadFile: downloadFile.mutate,
@@ -203,7 +229,12 @@ export const useFolders = (
     restoreDeletedFiles: restoreAllDeletedFilesMutation.mutate,
     renameFile: renameFile.mutate,
     renameFolder: renameFolder.mutate,
-    isLoading: foldersQuery.isLoading,
+    isLoading: isSharedRoot
+      ? sharedFoldersQuery.isLoading
+      : contentsQuery.isPending,
+    hasMore: !isSharedRoot && contentsQuery.hasNextPage,
+    loadMore: contentsQuery.fetchNextPage,
+    isLoadingMore: contentsQuery.isFetchingNextPage,
     createFolder: createFolderMutation.mutate,
     uploadFile: uploadFileMutation.mutateAsync, // mutateAsync is better for loops
     shareFile: shareFileMutation.mutate,
@@ -1,7 +1,8 @@
 import { api } from "./api";
 
-const CHUNK_SIZE = 5 * 1024 * 1024; // 5MB
 const CONCURRENCY_LIMIT = 4;
+// Parts presigned per request, uploaded before the next batch is signed
+const PRESIGN_BATCH_SIZE = 50;
 const MAX_RETRIES = 3;
 
 export const uploadFileInParts = async (
@@ -10,18 +11,24 @@ export const uploadFileInParts = async (
   onProgress: (percent: number) => void,
 ) => {
   try {
-    const totalParts = Math.ceil(file.size / CHUNK_SIZE);
-
+    // The server picks the part size so large files stay under S3's part limit
     const { data: initData } = await api.post("/files/uploads/initiate", {
       fileName: file.name,
       contentType: file.type,
       parentId: parentId,
       size: file.size,
-      totalChunks: totalParts,
       relativePath: file?.webkitRelativePath,
     });
 
-    const { uploadId, key, completedParts = [], resumed } = initData;
+    const {
+      sessionId,
+      uploadId,
+      key,
+      partSize,
+      totalParts,
+      completedParts = [],
+      resumed,
+    } = initData;
 
     const finishedNumbers = new Set(
       completedParts.map((p: any) => p.PartNumber || p.partNumber),
@@ -33,28 +40,40 @@ export const uploadFileInParts = async (
     const allCompletedParts = [...completedParts];
 
     let completedCount = finishedNumbers.size;
+    const presigned = new Map<number, { url: string; headers?: Record<string, string> }>();
+    const presignBatch = async (partNumbers: number[]) => {
+      const { data } = await api.post("/files/uploads/presign-parts", {
+        sessionId,
+        startPart: partNumbers[0],
+        endPart: partNumbers[partNumbers.length - 1],
+      });
+      for (const part of data.parts) presigned.set(part.partNumber, part);
+    };
+
     // Helper: Upload a single part with retry logic
     const uploadPartWithRetry = async (
       partNumber: number,
       attempt = 1,
     ): Promise<void> => {
       try {
-        const start = (partNumber - 1) * CHUNK_SIZE;
-        const end = Math.min(start + CHUNK_SIZE, file.size);
+        const start = (partNumber - 1) * partSize;
+        const end = Math.min(start + partSize, file.size);
         const blob = file.slice(start, end);
 
-        const { data: urlData } = await api.post(
-          "/files/uploads/presign-part",
-          {
-            uploadId,
-            key,
+        // Retries sign a fresh URL in case the batched one expired
+        let urlData = attempt === 1 ? presigned.get(partNumber) : undefined;
+        if (!urlData) {
+          ({ data: urlData } = await api.post("/files/uploads/presign-part", {
+            sessionId,
             partNumber,
-          },
-        );
+          }));
+        }
 
+        // Parts declared with a checksum must send it in the signed header
         const uploadResponse = await fetch(urlData.url, {
           method: "PUT",
           body: blob,
+          headers: urlData.headers,
         });
 
         if (!uploadResponse.ok) throw new Error("S3 Upload Failed");
@@ -77,21 +96,27 @@ export const uploadFileInParts = async (
       }
     };
 
-    // 2. Concurrency Worker Pool
-    const worker = async () => {
-      while (queue.length > 0) {
-        const partNumber = queue.shift();
-        if (partNumber !== undefined) {
-          await uploadPartWithRetry(partNumber);
+    // 2. Concurrency Worker Pool, fed one presigned batch at a time
+    while (queue.length > 0) {
+      const batch = queue.splice(0, PRESIGN_BATCH_SIZE);
+      await presignBatch(batch);
+
+      const worker = async () => {
+        while (batch.length > 0) {
+          const partNumber = batch.shift();
+          if (partNumber !== undefined) {
+            await uploadPartWithRetry(partNumber);
+          }
         }
-      }
-    };
+      };
 
-    // Fire off workers based on CONCURRENCY_LIMIT
-    await Promise.all(Array.from({ length: CONCURRENCY_LIMIT }, worker));
+      // Fire off workers based on CONCURRENCY_LIMIT
+      await Promise.all(Array.from({ length: CONCURRENCY_LIMIT }, worker));
+    }
 
     allCompletedParts.sort((a, b) => a.PartNumber - b.PartNumber);
     await api.post("/files/uploads/complete", {
+      sessionId,
       uploadId,
       key,
       parts: allCompletedParts,
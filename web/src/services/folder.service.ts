@@ -37,16 +37,66 @@ export interface Folder {
   updatedAt: string;
 }
 
-export const fetchFolders = async (
+interface Page<T> {
+  items: T[];
+  nextCursor: string | null;
+}
+
+// One listing item from /folders/contents; folders come before files
+export interface FolderContentItem {
+  type: "folder" | "file";
+  id: string;
+  name: string;
+  parentId: string | null;
+  size: number;
+  mimeType: string | null;
+  permission: string;
+  childCount?: number;
+  uploadStatus?: string;
+  isDeleted: boolean;
+  createdAt: string;
+  updatedAt: string;
+}
+
+// One page of a folder listing; pass nextCursor back for the page after it
+export interface FolderContentsPage {
+  folders: Folder[];
+  files: FolderContentItem[];
+  nextCursor: string | null;
+}
+
+// Lists the subfolders and files of a folder in one request instead of one per kind.
+// Listings are cursor paginated; callers fetch the next page only when it is needed.
+export const fetchFolderContents = async (
   parentId: string | null = null,
   isTrash: boolean,
-): Promise<Folder[]> => {
-  // Use params to automatically format the URL: /folders?parentId=...
-  const res = await api.get("/folders/", {
-    params: parentId ? { parentId, isTrash } : { isTrash },
-  });
-
-  return res.data;
+  cursor: string | null = null,
+): Promise<FolderContentsPage> => {
+  const params: Record<string, unknown> = parentId
+    ? { parentId, isTrash }
+    : { isTrash };
+  if (cursor) params.cursor = cursor;
+
+  const res: { data: Page<FolderContentItem> } = await api.get(
+    "/folders/contents",
+    { params },
+  );
+  const items = res.data.items;
+  return {
+    folders: items
+      .filter((item) => item.type === "folder")
+      .map((item) => ({
+        id: item.id,
+        name: item.name,
+        parentId: item.parentId,
+        folders: null,
+        files: null,
+        createdAt: item.createdAt,
+        updatedAt: item.updatedAt,
+      })),
+    files: items.filter((item) => item.type === "file"),
+    nextCursor: res.data.nextCursor,
+  };
 };
 
 export const fetchSharedFiles = async (): Promise<File[]> => {
@@ -54,15 +104,8 @@ export const fetchSharedFiles = async (): Promise<File[]> => {
   return res.data;
 };
 
-export const fetchFiles = async (
-  parentId: string | null = null,
-  isTrash: boolean,
-  isShared: boolean,
-): Promise<File[]> => {
-  const res = await api.get("/files/", {
-    params: parentId ? { parentId, isTrash } : { isTrash, isShared },
-  });
-
+export const fetchSharedFolders = async (): Promise<Folder[]> => {
+  const res = await api.get("/folders/shared-with-me");
   return res.data;
 };
 
@@ -97,9 +140,10 @@ export const createFolderApi = async (payload: {
   return res.data;
 };
 
+// Send fileId to share one file, or folderId to share a folder and everything in it
 export const shareFilesApi = async (payload: {
-  fileId: string;
-  folderId: string;
+  fileId?: string;
+  folderId?: string;
   emails: string[];
   permission: string;
 }) => {
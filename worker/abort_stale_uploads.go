@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"log"
+	"time"
+
+	"github.com/richeek45/filedrive/repositories"
+	"github.com/richeek45/filedrive/storage"
+	"gorm.io/gorm"
+)
+
+// AbortStaleUploads aborts multipart uploads that have been idle longer than UPLOAD_SESSION_TTL,
+// so their parts stop accruing storage and their pending files disappear from listings.
+func AbortStaleUploads(db *gorm.DB, store storage.ObjectStore, bucketName string) {
+	go func() {
+		startTime := time.Now()
+
+		tx := db.Begin()
+		defer tx.Rollback()
+
+		var locked bool
+		tx.Raw("SELECT pg_try_advisory_xact_lock(654322)").Scan(&locked)
+		if !locked {
+			log.Println("Upload Janitor: Already running. Skipping.")
+			return
+		}
+
+		const batchSize = 500
+		repo := repositories.NewFileRepository(db)
+		cutoff := startTime.Add(-repositories.UploadSessionTTL())
+		aborted := 0
+
+		for {
+			sessions, err := repo.StaleUploadSessions(cutoff, batchSize)
+			if err != nil {
+				log.Printf("Upload Janitor: failed to load stale uploads: %v", err)
+				break
+			}
+			if len(sessions) == 0 {
+				break
+			}
+
+			failed := 0
+			for i := range sessions {
+				if err := repo.AbortUploadSession(&sessions[i], store); err != nil {
+					log.Printf("Upload Janitor: failed to abort upload %s: %v", sessions[i].UploadID(), err)
+					failed++
+					continue
+				}
+				aborted++
+			}
+			// Sessions that failed stay stale; stop rather than fetch them again
+			if failed > 0 {
+				break
+			}
+		}
+
+		log.Printf("Upload Janitor: aborted %d uploads idle since %s in %s", aborted, cutoff.Format(time.RFC3339), time.Since(startTime))
+	}()
+}
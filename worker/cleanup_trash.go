@@ -5,14 +5,16 @@ import (
 	"log"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
 	"github.com/richeek45/filedrive/models"
+	"github.com/richeek45/filedrive/repositories"
+	"github.com/richeek45/filedrive/storage"
 	"gorm.io/gorm"
 )
 
-func PurgeExpiredDeletedFiles(db *gorm.DB, s3Client *s3.Client, bucketName string) {
+// PurgeExpiredDeletedFiles removes trash that has outlived its owner's retention policy:
+// trashed files and folders, and the delete/ copies kept for RestoreDeletedFiles.
+func PurgeExpiredDeletedFiles(db *gorm.DB, store storage.ObjectStore, bucketName string) {
 	go func() {
 		startTime := time.Now()
 
@@ -27,66 +29,198 @@ func PurgeExpiredDeletedFiles(db *gorm.DB, s3Client *s3.Client, bucketName strin
 		}
 
 		const totalLimit = 50000
-		const batchSize = 1000
 		processedCount := 0
 
-		// expiryDate := time.Now().AddDate(0, 0, -30) // 30days for prod
-		expiryDate := time.Now().Add(-30 * time.Second)
-
-		log.Printf("Purge Worker: Starting cleanup for files deleted before %s", expiryDate.Format("2006-01-02"))
-
-		for processedCount < totalLimit {
-			var filesToPurge []models.File
+		scopes, err := repositories.RetentionScopes(db, startTime)
+		if err != nil {
+			log.Printf("Purge Worker: failed to load retention policies: %v", err)
+			return
+		}
 
-			err := db.Unscoped().
-				Limit(batchSize).
-				Where("is_deleted = ? AND deleted_at < ?", true, expiryDate).
-				Find(&filesToPurge).Error
-			if err != nil {
-				log.Fatalf("Failed to get deleted_at files: %v", err)
+		for _, scope := range scopes {
+			if processedCount >= totalLimit {
 				break
 			}
+			log.Printf("Purge Worker: Starting cleanup for files deleted before %s", scope.Cutoff.Format("2006-01-02"))
 
-			if len(filesToPurge) == 0 {
-				break
-			}
+			processedCount += purgeTrashedFiles(db, store, scope, totalLimit-processedCount)
+			purgeTrashedFolders(db, scope)
+			processedCount += purgeDeletedFileCopies(db, store, scope, totalLimit-processedCount)
+		}
 
-			var objectKeys []types.ObjectIdentifier
-			for _, f := range filesToPurge {
-				key := f.ObjectKey
-				objectKeys = append(objectKeys, types.ObjectIdentifier{Key: &key})
-			}
+		elapsed := time.Since(startTime)
+		log.Printf("Cleanup complete in %s. Goroutine exiting and lock released.", elapsed)
+	}()
+}
 
-			output, err := s3Client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
-				Bucket: aws.String(bucketName),
-				Delete: &types.Delete{Objects: objectKeys, Quiet: aws.Bool(false)},
-			})
+func purgeTrashedFiles(db *gorm.DB, store storage.ObjectStore, scope repositories.RetentionScope, limit int) int {
+	const batchSize = 1000
+	processedCount := 0
+
+	for processedCount < limit {
+		var filesToPurge []models.File
+
+		err := db.Unscoped().
+			Limit(batchSize).
+			Where("is_deleted = ? AND deleted_at < ?", true, scope.Cutoff).
+			Where(scope.OwnerClause, scope.Args...).
+			Find(&filesToPurge).Error
+		if err != nil {
+			log.Printf("Failed to get deleted_at files: %v", err)
+			break
+		}
 
-			if err != nil {
-				log.Fatalf("S3 API error: %v", err)
-				break
+		if len(filesToPurge) == 0 {
+			break
+		}
+
+		// Only completed uploads were ever charged to the owner
+		var objectKeys []string
+		var fileIDs []uuid.UUID
+		owners := make(map[uuid.UUID]uuid.UUID, len(filesToPurge))
+		reclaimed := make(map[uuid.UUID]int64)
+		for _, f := range filesToPurge {
+			objectKeys = append(objectKeys, f.ObjectKey)
+			fileIDs = append(fileIDs, f.ID)
+			owners[f.ID] = f.OwnerID
+			if f.UploadStatus == "completed" {
+				reclaimed[f.OwnerID] += f.Size
 			}
+		}
 
-			var successfullyDeletedKeys []string
-			for _, o := range output.Deleted {
-				successfullyDeletedKeys = append(successfullyDeletedKeys, *o.Key)
+		// Old versions go with the file
+		var versions []models.FileVersion
+		if err := db.Where("file_id IN ?", fileIDs).Find(&versions).Error; err != nil {
+			log.Printf("Failed to get file versions: %v", err)
+			break
+		}
+		for _, v := range versions {
+			objectKeys = append(objectKeys, v.ObjectKey)
+			reclaimed[owners[v.FileID]] += v.Size
+		}
+
+		// Rows go first; objects still shared with other files are kept, and deletes
+		// that fail are retried by CleanupOrphanedS3Objects
+		var orphanedKeys []string
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if orphanedKeys, err = repositories.ReleaseObjects(tx, objectKeys); err != nil {
+				return err
 			}
+			if err := tx.Where("file_id IN ?", fileIDs).Delete(&models.FileVersion{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("id IN ?", fileIDs).Delete(&models.File{}).Error; err != nil {
+				return err
+			}
+			return releaseStorage(tx, reclaimed)
+		})
+		if err != nil {
+			log.Printf("Failed to purge file rows: %v", err)
+			break
+		}
+		repositories.DeleteObjectsOrQueue(db, store, orphanedKeys)
 
-			if len(successfullyDeletedKeys) > 0 {
-				db.Unscoped().
-					Where("object_key IN ?", successfullyDeletedKeys).
-					Delete(&models.File{})
+		RecordTrashReclaimed(ReclaimRetention, len(filesToPurge), sumBytes(reclaimed))
 
-				processedCount += len(successfullyDeletedKeys)
+		processedCount += len(filesToPurge)
+		log.Printf("Cleanup Progress: %d/%d", processedCount, limit)
+	}
+	return processedCount
+}
+
+// Trashed folders go once the files trashed with them are purged. Deleting only empty
+// leaves keeps the folder_id cascade from dropping rows whose objects still exist.
+func purgeTrashedFolders(db *gorm.DB, scope repositories.RetentionScope) {
+	for {
+		result := db.Where("is_deleted = ? AND deleted_at < ?", true, scope.Cutoff).
+			Where(scope.OwnerClause, scope.Args...).
+			Where("NOT EXISTS (SELECT 1 FROM file WHERE file.folder_id = folder.id)").
+			Where("NOT EXISTS (SELECT 1 FROM folder child WHERE child.parent_id = folder.id)").
+			Delete(&models.Folder{})
+		if result.Error != nil {
+			log.Printf("Failed to purge trashed folders: %v", result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			return
+		}
+	}
+}
+
+// purgeDeletedFileCopies drops the delete/ copies of permanently deleted files once they can
+// no longer be restored. Their size was released when the file was deleted.
+func purgeDeletedFileCopies(db *gorm.DB, store storage.ObjectStore, scope repositories.RetentionScope, limit int) int {
+	const batchSize = 1000
+	processedCount := 0
+
+	for processedCount < limit {
+		var expired []models.DeletedFile
+		err := db.Limit(batchSize).
+			Where("deleted_at < ?", scope.Cutoff).
+			Where(scope.OwnerClause, scope.Args...).
+			Find(&expired).Error
+		if err != nil {
+			log.Printf("Failed to get expired deleted files: %v", err)
+			break
+		}
+		if len(expired) == 0 {
+			break
+		}
+
+		var objectKeys []string
+		var ids []uuid.UUID
+		var bytes int64
+		for _, f := range expired {
+			objectKeys = append(objectKeys, f.ObjectKey)
+			ids = append(ids, f.ID)
+			bytes += f.Size
+		}
+
+		var orphanedKeys []string
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if orphanedKeys, err = repositories.ReleaseObjects(tx, objectKeys); err != nil {
+				return err
 			}
-			log.Printf("Cleanup Progress: %d/%d", processedCount, totalLimit)
+			return tx.Where("id IN ?", ids).Delete(&models.DeletedFile{}).Error
+		})
+		if err != nil {
+			log.Printf("Failed to purge deleted file rows: %v", err)
+			break
 		}
-		elapsed := time.Since(startTime)
-		log.Printf("Cleanup complete in %d. Goroutine exiting and lock released.", elapsed)
-	}()
+		repositories.DeleteObjectsOrQueue(db, store, orphanedKeys)
+
+		RecordTrashReclaimed(ReclaimDeletedFile, len(expired), bytes)
+
+		processedCount += len(expired)
+	}
+	return processedCount
 }
 
-func CleanupOrphanedS3Objects(db *gorm.DB, s3Client *s3.Client, bucketName string) {
+func releaseStorage(tx *gorm.DB, reclaimed map[uuid.UUID]int64) error {
+	for ownerID, bytes := range reclaimed {
+		if bytes == 0 {
+			continue
+		}
+		err := tx.Model(&models.Users{}).Where("id = ?", ownerID).
+			UpdateColumn("storage_used", gorm.Expr("storage_used - ?", bytes)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sumBytes(reclaimed map[uuid.UUID]int64) int64 {
+	var total int64
+	for _, bytes := range reclaimed {
+		total += bytes
+	}
+	return total
+}
+
+func CleanupOrphanedS3Objects(db *gorm.DB, store storage.ObjectStore, bucketName string) {
 	go func() {
 		// We use a transaction-level advisory lock so it clears if the app crashes
 		tx := db.Begin()
@@ -119,27 +253,18 @@ func CleanupOrphanedS3Objects(db *gorm.DB, s3Client *s3.Client, bucketName strin
 				return
 			}
 
-			var objectKeys []types.ObjectIdentifier
+			var objectKeys []string
 			for _, file := range failedDeletions {
-				key := file.ObjectKey
-				objectKeys = append(objectKeys, types.ObjectIdentifier{Key: &key})
+				objectKeys = append(objectKeys, file.ObjectKey)
 			}
 
-			output, err := s3Client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
-				Bucket: aws.String(bucketName),
-				Delete: &types.Delete{Objects: objectKeys, Quiet: aws.Bool(false)},
-			})
+			successfullyDeleted, err := store.DeleteObjects(context.TODO(), objectKeys)
 
 			if err != nil {
 				log.Printf("S3 API Error: %v", err)
 				return
 			}
 
-			var successfullyDeleted []string
-			for _, o := range output.Deleted {
-				successfullyDeleted = append(successfullyDeleted, *o.Key)
-			}
-
 			if len(successfullyDeleted) > 0 {
 				// Use the main DB instance for the actual deletion to keep it separate from the lock tx
 				db.Where("bucket_name = ? AND object_key IN ?", bucketName, successfullyDeleted).
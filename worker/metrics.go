@@ -0,0 +1,38 @@
+package worker
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Reasons a trashed item's storage was reclaimed
+const (
+	ReclaimRetention   = "retention"
+	ReclaimDeletedFile = "deleted_file"
+	ReclaimEmptyTrash  = "empty_trash"
+)
+
+var (
+	trashBytesReclaimed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "filedrive_trash_bytes_reclaimed_total",
+			Help: "Bytes of trashed content purged for good",
+		},
+		[]string{"reason"},
+	)
+
+	trashFilesPurged = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "filedrive_trash_files_purged_total",
+			Help: "Count of trashed files removed for good",
+		},
+		[]string{"reason"},
+	)
+)
+
+// RegisterMetrics adds the worker metrics to the registry served on /metrics.
+func RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(trashBytesReclaimed, trashFilesPurged)
+}
+
+func RecordTrashReclaimed(reason string, files int, bytes int64) {
+	trashFilesPurged.WithLabelValues(reason).Add(float64(files))
+	trashBytesReclaimed.WithLabelValues(reason).Add(float64(bytes))
+}
@@ -38,6 +38,11 @@ func SyncUserStorage(db *gorm.DB) error {
 				FROM file f
 				WHERE f.owner_id = u.id
 				AND f.upload_status = 'completed'
+			) + (
+				SELECT COALESCE(SUM(v.size), 0)
+				FROM file_version v
+				JOIN file f ON f.id = v.file_id
+				WHERE f.owner_id = u.id
 			)
 			WHERE u.id IN ?`, userIDs).Error
 
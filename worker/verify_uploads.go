@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/richeek45/filedrive/repositories"
+	"github.com/richeek45/filedrive/storage"
+	"gorm.io/gorm"
+)
+
+// stalledVerificationAge is how long a file may sit in "verifying" before VerifyStalledUploads
+// assumes its background check was lost.
+const stalledVerificationAge = time.Hour
+
+// VerifyUpload checks a completed upload in the background, reading it back from storage when
+// the backend has no full-object checksum for it.
+func VerifyUpload(db *gorm.DB, store storage.ObjectStore, fileID uuid.UUID) {
+	go func() {
+		if err := repositories.NewFileRepository(db).VerifyUpload(fileID, store); err != nil {
+			log.Printf("Upload Verifier: failed to verify file %s: %v", fileID, err)
+		}
+	}()
+}
+
+// VerifyStalledUploads retries verifications lost to a restart or a storage error.
+func VerifyStalledUploads(db *gorm.DB, store storage.ObjectStore, bucketName string) {
+	go func() {
+		startTime := time.Now()
+
+		tx := db.Begin()
+		defer tx.Rollback()
+
+		var locked bool
+		tx.Raw("SELECT pg_try_advisory_xact_lock(654323)").Scan(&locked)
+		if !locked {
+			log.Println("Upload Verifier: Already running. Skipping.")
+			return
+		}
+
+		repo := repositories.NewFileRepository(db)
+		files, err := repo.StalledVerifications(startTime.Add(-stalledVerificationAge), 100)
+		if err != nil {
+			log.Printf("Upload Verifier: failed to load stalled verifications: %v", err)
+			return
+		}
+
+		verified := 0
+		for _, file := range files {
+			if err := repo.VerifyUpload(file.ID, store); err != nil {
+				log.Printf("Upload Verifier: failed to verify file %s: %v", file.ID, err)
+				continue
+			}
+			verified++
+		}
+
+		log.Printf("Upload Verifier: verified %d of %d stalled uploads in %s", verified, len(files), time.Since(startTime))
+	}()
+}